@@ -0,0 +1,207 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type Result string
+
+const (
+	Ok             Result = "OK"
+	Failed         Result = "FAILED"
+	RolledBack     Result = "ROLLED_BACK"
+	RollbackFailed Result = "ROLLBACK_FAILED"
+)
+
+// Entry records a single write to the controller
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal"`
+	Endpoint  string    `json:"endpoint"`
+	Circuit   int32     `json:"circuit,omitempty"`
+	Pnu       uint16    `json:"pnu"`
+	Label     string    `json:"label"`
+	OldValue  uint16    `json:"oldValue"`
+	NewValue  uint16    `json:"newValue"`
+	Result    Result    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Zero values of a filter's fields match everything
+type Filter struct {
+	From    time.Time
+	To      time.Time
+	Circuit int32
+	Pnu     uint16
+}
+
+func (f Filter) matches(e Entry) bool {
+	if !f.From.IsZero() && e.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && e.Time.After(f.To) {
+		return false
+	}
+	if f.Circuit != 0 && e.Circuit != f.Circuit {
+		return false
+	}
+	if f.Pnu != 0 && e.Pnu != f.Pnu {
+		return false
+	}
+	return true
+}
+
+type Log interface {
+	Append(entry Entry) error
+	Query(filter Filter) ([]Entry, error)
+}
+
+/*
+The file log appends one JSON document per line. Entries are never rewritten, the file
+may be rotated by external tools while the application is stopped. A line left incomplete
+by a crash is cut off when the log is opened again.
+*/
+type FileLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileLog(path string) (*FileLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log %s: %w", path, err)
+	}
+	if err := cutPartialLine(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("repairing audit log %s: %w", path, err)
+	}
+	return &FileLog{
+		path: path,
+		file: file,
+	}, nil
+}
+
+func (l *FileLog) Append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err = l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *FileLog) Query(filter Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	var lineErr error
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if lineErr != nil {
+			return nil, lineErr
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// only tolerated as the final line, a write interrupted by a crash
+			lineErr = fmt.Errorf("audit log %s line %d: %w", l.path, lineNo, err)
+			continue
+		}
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if lineErr != nil {
+		slog.Warn("Skipping the unparsable final line of the audit log", "error", lineErr)
+	}
+	return entries, scanner.Err()
+}
+
+// cutPartialLine removes a final line without newline, so the next entry starts a line of its own
+func cutPartialLine(file *os.File) error {
+	content, err := os.ReadFile(file.Name())
+	if err != nil || len(content) == 0 || content[len(content)-1] == '\n' {
+		return err
+	}
+	slog.Warn("Removing the partial final line of the audit log", "file", file.Name())
+	return file.Truncate(int64(bytes.LastIndexByte(content, '\n') + 1))
+}
+
+func (l *FileLog) Close() error {
+	return l.file.Close()
+}
+
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	endpointKey
+)
+
+const anonymous = "anonymous"
+
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+func Principal(ctx context.Context) string {
+	if principal, ok := ctx.Value(principalKey).(string); ok {
+		return principal
+	}
+	return anonymous
+}
+
+func WithEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointKey, endpoint)
+}
+
+func Endpoint(ctx context.Context) string {
+	if endpoint, ok := ctx.Value(endpointKey).(string); ok {
+		return endpoint
+	}
+	return ""
+}
+
+// Middleware stores the called endpoint in the request context
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithEndpoint(r.Context(), fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
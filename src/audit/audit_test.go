@@ -0,0 +1,111 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audit_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treblada/ecl310-rest/audit"
+	"gotest.tools/v3/assert"
+)
+
+var start = time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+func newLog(t *testing.T, path string) *audit.FileLog {
+	log, err := audit.NewFileLog(path)
+	assert.NilError(t, err)
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+func appendEntries(t *testing.T, log *audit.FileLog) {
+	entries := []audit.Entry{
+		{Time: start, Principal: "alice", Circuit: 1, Pnu: 11175, Label: "slope", OldValue: 10, NewValue: 17, Result: audit.Ok},
+		{Time: start.Add(time.Minute), Principal: "alice", Circuit: 2, Pnu: 12180, Label: "comfort room temp", OldValue: 22, NewValue: 21, Result: audit.Ok},
+		{Time: start.Add(2 * time.Minute), Principal: "bob", Pnu: 10198, Label: "DST", NewValue: 1, Result: audit.Failed, Error: "timeout"},
+	}
+	for _, entry := range entries {
+		assert.NilError(t, log.Append(entry))
+	}
+}
+
+func TestFileLog__appendsAndSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log := newLog(t, path)
+	appendEntries(t, log)
+	log.Close()
+
+	entries, err := newLog(t, path).Query(audit.Filter{})
+	assert.NilError(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.DeepEqual(t, entries[2], audit.Entry{Time: start.Add(2 * time.Minute), Principal: "bob", Pnu: 10198, Label: "DST", NewValue: 1, Result: audit.Failed, Error: "timeout"})
+}
+
+func TestFileLog__filters(t *testing.T) {
+	log := newLog(t, filepath.Join(t.TempDir(), "audit.jsonl"))
+	appendEntries(t, log)
+	tests := []struct {
+		name   string
+		filter audit.Filter
+		pnus   []uint16
+	}{
+		{"from", audit.Filter{From: start.Add(time.Minute)}, []uint16{12180, 10198}},
+		{"to", audit.Filter{To: start.Add(time.Minute)}, []uint16{11175, 12180}},
+		{"circuit", audit.Filter{Circuit: 2}, []uint16{12180}},
+		{"pnu", audit.Filter{Pnu: 10198}, []uint16{10198}},
+		{"no match", audit.Filter{Circuit: 1, Pnu: 10198}, []uint16{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := log.Query(test.filter)
+			assert.NilError(t, err)
+			pnus := []uint16{}
+			for _, entry := range entries {
+				pnus = append(pnus, entry.Pnu)
+			}
+			assert.DeepEqual(t, pnus, test.pnus)
+		})
+	}
+}
+
+func TestFileLog__partialFinalLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log := newLog(t, path)
+	assert.NilError(t, log.Append(audit.Entry{Time: start, Principal: "alice", Pnu: 11175, Label: "slope", NewValue: 17, Result: audit.Ok}))
+	// a write interrupted by a crash
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0640)
+	assert.NilError(t, err)
+	_, err = file.WriteString(`{"time":"2024-01-15T12:01:00Z","princ`)
+	assert.NilError(t, err)
+	assert.NilError(t, file.Close())
+
+	entries, err := log.Query(audit.Filter{})
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(entries))
+
+	// reopened, the next entry doesn't continue the partial line
+	log.Close()
+	log = newLog(t, path)
+	assert.NilError(t, log.Append(audit.Entry{Time: start.Add(time.Minute), Principal: "bob", Pnu: 10198, Label: "DST", NewValue: 1, Result: audit.Ok}))
+	entries, err = log.Query(audit.Filter{})
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "bob", entries[1].Principal)
+}
@@ -0,0 +1,190 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/treblada/ecl310-rest/audit"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/logging"
+)
+
+type Role uint16
+
+const (
+	None Role = iota
+	Viewer
+	Operator
+	Installer
+)
+
+var roleNames = []string{"NONE", "VIEWER", "OPERATOR", "INSTALLER"}
+
+func (r Role) String() string {
+	if int(r) < len(roleNames) {
+		return roleNames[r]
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint16(r))
+}
+
+func ParseRole(name string) (Role, error) {
+	for i, roleName := range roleNames[1:] {
+		if strings.EqualFold(name, roleName) {
+			return Role(i + 1), nil
+		}
+	}
+	return None, fmt.Errorf("invalid role %q", name)
+}
+
+func (r *Role) UnmarshalText(text []byte) (err error) {
+	*r, err = ParseRole(string(text))
+	return
+}
+
+type Principal struct {
+	Name string
+	Role Role
+}
+
+/*
+An authenticator checks the credentials of a request. It returns a nil principal and a
+nil error if the request does not carry credentials the authenticator understands, and
+an error if the credentials are present but invalid.
+*/
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return audit.WithPrincipal(context.WithValue(ctx, principalKey, principal), principal.Name)
+}
+
+func PrincipalFrom(ctx context.Context) *Principal {
+	if principal, ok := ctx.Value(principalKey).(*Principal); ok {
+		return principal
+	}
+	return nil
+}
+
+/*
+Middleware identifies the caller by the first authenticator recognising the request's
+credentials. Requests without credentials are passed on anonymously, it's up to Protect
+to reject them for the routes requiring a role.
+*/
+func Middleware(next http.Handler, authenticators ...Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, authenticator := range authenticators {
+			principal, err := authenticator.Authenticate(r)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("Authentication failed", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "error", err)
+				unauthorized(w, r)
+				return
+			}
+			if principal != nil {
+				next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type protectedRouter struct {
+	router    openapi.Router
+	writeRole Role
+	// names of routes which don't write despite their method
+	readOnly []string
+}
+
+/*
+Protect requires the viewer role for reading and the given role for any other method on
+all routes of the router. As each generated controller serves a single OpenAPI tag, this
+maps the roles onto the tags. The named read-only routes only require the viewer role,
+e.g. for a POST which only takes a request body to analyse.
+*/
+func Protect(router openapi.Router, writeRole Role, readOnly ...string) openapi.Router {
+	return &protectedRouter{
+		router:    router,
+		writeRole: writeRole,
+		readOnly:  readOnly,
+	}
+}
+
+func (p *protectedRouter) Routes() openapi.Routes {
+	routes := p.router.Routes()
+	protected := make(openapi.Routes, len(routes))
+	for i, route := range routes {
+		requiredRole := p.writeRole
+		if route.Method == http.MethodGet || route.Method == http.MethodHead || slices.Contains(p.readOnly, route.Name) {
+			requiredRole = Viewer
+		}
+		protected[i] = route
+		protected[i].HandlerFunc = requireRole(route.HandlerFunc, requiredRole)
+	}
+	return protected
+}
+
+// RequireRole protects a handler outside the OpenAPI routes, e.g. the metrics, with the given role
+func RequireRole(next http.Handler, role Role) http.Handler {
+	return requireRole(next.ServeHTTP, role)
+}
+
+func requireRole(next http.HandlerFunc, role Role) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := PrincipalFrom(r.Context())
+		if principal == nil {
+			unauthorized(w, r)
+			return
+		}
+		if principal.Role < role {
+			logging.FromContext(r.Context()).Warn("Access denied", "principal", principal.Name, "role", principal.Role, "method", r.Method, "path", r.URL.Path, "required", role)
+			writeProblem(w, r, http.StatusForbidden, "FORBIDDEN", fmt.Sprintf("Role %v required", role))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("WWW-Authenticate", `Basic realm="ecl310-rest"`)
+	w.Header().Add("WWW-Authenticate", `Bearer realm="ecl310-rest"`)
+	writeProblem(w, r, http.StatusUnauthorized, "UNAUTHENTICATED", "Authentication required")
+}
+
+// writeProblem renders RFC 7807 problem details like the services' error handler
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(openapi.Problem{
+		Type:     "urn:ecl310-rest:error:" + code,
+		Title:    http.StatusText(status),
+		Status:   int32(status),
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	})
+}
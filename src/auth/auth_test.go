@@ -0,0 +1,189 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treblada/ecl310-rest/audit"
+	"github.com/treblada/ecl310-rest/auth"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"golang.org/x/crypto/bcrypt"
+	"gotest.tools/v3/assert"
+)
+
+type testRouter struct{}
+
+func (r *testRouter) Routes() openapi.Routes {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, audit.Principal(r.Context()))
+	}
+	return openapi.Routes{
+		{Name: "Read", Method: http.MethodGet, Pattern: "/resource", HandlerFunc: handler},
+		{Name: "Write", Method: http.MethodPost, Pattern: "/resource", HandlerFunc: handler},
+	}
+}
+
+func newHandler(authenticators ...auth.Authenticator) http.Handler {
+	router := openapi.NewRouter(auth.Protect(&testRouter{}, auth.Operator))
+	return auth.Middleware(router, authenticators...)
+}
+
+func serve(handler http.Handler, method string, setup func(r *http.Request)) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/resource", nil)
+	setup(request)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestApiKey__roles(t *testing.T) {
+	handler := newHandler(auth.NewApiKeyAuthenticator([]auth.ApiKey{
+		{Name: "dashboard", Key: "secret-1", Role: auth.Viewer},
+		{Name: "scheduler", Key: "secret-2", Role: auth.Operator},
+	}))
+	withKey := func(key string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set(auth.ApiKeyHeader, key) }
+	}
+
+	response := serve(handler, http.MethodGet, withKey("secret-1"))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "dashboard", response.Body.String())
+	assert.Equal(t, http.StatusForbidden, serve(handler, http.MethodPost, withKey("secret-1")).Code)
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodPost, withKey("secret-2")).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, http.MethodGet, withKey("wrong")).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, http.MethodGet, func(r *http.Request) {}).Code)
+}
+
+func TestProtect__readOnlyRoutes(t *testing.T) {
+	router := openapi.NewRouter(auth.Protect(&testRouter{}, auth.Operator, "Write"))
+	handler := auth.Middleware(router, auth.NewApiKeyAuthenticator([]auth.ApiKey{
+		{Name: "dashboard", Key: "secret-1", Role: auth.Viewer},
+	}))
+	withKey := func(r *http.Request) { r.Header.Set(auth.ApiKeyHeader, "secret-1") }
+
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodPost, withKey).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, http.MethodPost, func(r *http.Request) {}).Code)
+}
+
+func TestRequireRole(t *testing.T) {
+	handler := auth.Middleware(auth.RequireRole(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), auth.Viewer),
+		auth.NewApiKeyAuthenticator([]auth.ApiKey{{Name: "dashboard", Key: "secret-1", Role: auth.Viewer}}))
+	withKey := func(r *http.Request) { r.Header.Set(auth.ApiKeyHeader, "secret-1") }
+
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, withKey).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, http.MethodGet, func(r *http.Request) {}).Code)
+}
+
+func TestBasic__bcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	assert.NilError(t, err)
+	handler := newHandler(auth.NewBasicAuthenticator([]auth.User{
+		{Name: "installer", PasswordHash: string(hash), Role: auth.Installer},
+	}))
+
+	response := serve(handler, http.MethodPost, func(r *http.Request) { r.SetBasicAuth("installer", "correct horse") })
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "installer", response.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, http.MethodGet, func(r *http.Request) { r.SetBasicAuth("installer", "wrong") }).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, http.MethodGet, func(r *http.Request) { r.SetBasicAuth("nobody", "correct horse") }).Code)
+}
+
+func TestJwt__jwks(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	jwks, _ := json.Marshal(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "EC",
+			"kid": "test",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}},
+	})
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NilError(t, os.WriteFile(jwksFile, jwks, 0600))
+	authenticator, err := auth.NewJwtAuthenticator(auth.JwtConfig{JwksFile: jwksFile, Audience: "ecl310"})
+	assert.NilError(t, err)
+	handler := newHandler(authenticator)
+
+	signWith := func(alg string, hash crypto.Hash, claims map[string]any) func(r *http.Request) {
+		header, _ := json.Marshal(map[string]string{"alg": alg, "kid": "test", "typ": "JWT"})
+		payload, _ := json.Marshal(claims)
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		hasher := hash.New()
+		hasher.Write([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, hasher.Sum(nil))
+		assert.NilError(t, err)
+		signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		token := signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	sign := func(claims map[string]any) func(r *http.Request) {
+		return signWith("ES256", crypto.SHA256, claims)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	response := serve(handler, http.MethodPost, sign(map[string]any{"sub": "mgmt", "aud": []string{"ecl310"}, "exp": exp, "role": []string{"viewer", "operator"}}))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "mgmt", response.Body.String())
+	assert.Equal(t, http.StatusForbidden, serve(handler, http.MethodPost, sign(map[string]any{"sub": "mgmt", "aud": "ecl310", "exp": exp, "role": "viewer"})).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, http.MethodGet, sign(map[string]any{"sub": "mgmt", "aud": "other", "exp": exp, "role": "viewer"})).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, http.MethodGet, sign(map[string]any{"sub": "mgmt", "aud": "ecl310", "exp": exp - 7200, "role": "viewer"})).Code)
+	// ES512 requires a P-521 key
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, http.MethodGet, signWith("ES512", crypto.SHA512, map[string]any{"sub": "mgmt", "aud": "ecl310", "exp": exp, "role": "viewer"})).Code)
+}
+
+func TestJwt__failInvalidKeyIds(t *testing.T) {
+	key := map[string]string{"kty": "RSA", "n": "AQAB", "e": "AQAB"}
+	withKid := func(kid string) map[string]string {
+		copied := map[string]string{"kid": kid}
+		for name, value := range key {
+			copied[name] = value
+		}
+		return copied
+	}
+	tests := []struct {
+		name  string
+		keys  []map[string]string
+		error string
+	}{
+		{"empty kid", []map[string]string{key}, "key 0 without kid"},
+		{"duplicate kid", []map[string]string{withKid("test"), withKid("other"), withKid("test")}, `more than one key "test"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jwks, _ := json.Marshal(map[string]any{"keys": test.keys})
+			jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+			assert.NilError(t, os.WriteFile(jwksFile, jwks, 0600))
+			_, err := auth.NewJwtAuthenticator(auth.JwtConfig{JwksFile: jwksFile})
+			assert.ErrorContains(t, err, test.error)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const ApiKeyHeader = "X-API-Key"
+
+type apiKeyAuthenticator struct {
+	keys []ApiKey
+}
+
+func NewApiKeyAuthenticator(keys []ApiKey) Authenticator {
+	return &apiKeyAuthenticator{
+		keys: keys,
+	}
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(ApiKeyHeader)
+	if key == "" {
+		return nil, nil
+	}
+	for _, apiKey := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(apiKey.Key), []byte(key)) == 1 {
+			return &Principal{Name: apiKey.Name, Role: apiKey.Role}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown API key")
+}
+
+type basicAuthenticator struct {
+	users map[string]User
+}
+
+func NewBasicAuthenticator(users []User) Authenticator {
+	authenticator := &basicAuthenticator{
+		users: map[string]User{},
+	}
+	for _, user := range users {
+		authenticator.users[user.Name] = user
+	}
+	return authenticator
+}
+
+func (a *basicAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	user, known := a.users[name]
+	if !known {
+		return nil, fmt.Errorf("unknown user %q", name)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, fmt.Errorf("invalid password for user %q: %w", name, err)
+	}
+	return &Principal{Name: user.Name, Role: user.Role}, nil
+}
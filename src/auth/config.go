@@ -0,0 +1,84 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type ApiKey struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	Role Role   `json:"role"`
+}
+
+type User struct {
+	Name string `json:"name"`
+	// bcrypt hash, e.g. created with "htpasswd -nbBC 10 user password"
+	PasswordHash string `json:"passwordHash"`
+	Role         Role   `json:"role"`
+}
+
+type JwtConfig struct {
+	JwksFile string `json:"jwksFile"`
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// claim holding the role name or a list of role names, defaults to "role"
+	RoleClaim string `json:"roleClaim"`
+}
+
+type Config struct {
+	ApiKeys []ApiKey   `json:"apiKeys"`
+	Users   []User     `json:"users"`
+	Jwt     *JwtConfig `json:"jwt"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading auth config %s: %w", path, err)
+	}
+	config := &Config{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("parsing auth config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Authenticators returns the authenticators for all configured methods
+func (c *Config) Authenticators() ([]Authenticator, error) {
+	authenticators := []Authenticator{}
+	if len(c.ApiKeys) > 0 {
+		authenticators = append(authenticators, NewApiKeyAuthenticator(c.ApiKeys))
+	}
+	if len(c.Users) > 0 {
+		authenticators = append(authenticators, NewBasicAuthenticator(c.Users))
+	}
+	if c.Jwt != nil {
+		jwtAuthenticator, err := NewJwtAuthenticator(*c.Jwt)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwtAuthenticator)
+	}
+	if len(authenticators) == 0 {
+		return nil, fmt.Errorf("no authentication method configured")
+	}
+	return authenticators, nil
+}
@@ -0,0 +1,311 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwtAuthenticator struct {
+	config JwtConfig
+	keys   map[string]crypto.PublicKey
+}
+
+/*
+The JWT authenticator accepts bearer tokens signed with one of the RSA or EC keys of the
+configured JWKS file. Symmetric and unsigned tokens are rejected.
+*/
+func NewJwtAuthenticator(config JwtConfig) (Authenticator, error) {
+	if config.RoleClaim == "" {
+		config.RoleClaim = "role"
+	}
+	keys, err := loadJwks(config.JwksFile)
+	if err != nil {
+		return nil, err
+	}
+	return &jwtAuthenticator{
+		config: config,
+		keys:   keys,
+	}, nil
+}
+
+func loadJwks(path string) (map[string]crypto.PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWKS %s: %w", path, err)
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &jwks); err != nil {
+		return nil, fmt.Errorf("parsing JWKS %s: %w", path, err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for i, jwk := range jwks.Keys {
+		// tokens select their key by id
+		if jwk.Kid == "" {
+			return nil, fmt.Errorf("JWKS %s key %d without kid", path, i)
+		}
+		if _, ok := keys[jwk.Kid]; ok {
+			return nil, fmt.Errorf("JWKS %s has more than one key %q", path, jwk.Kid)
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("JWKS %s key %q: %w", path, jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in JWKS %s", path)
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, nil
+	}
+	claims, err := a.verify(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		return nil, err
+	}
+	if err := a.validate(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("token without subject")
+	}
+	role := highestRole(claims[a.config.RoleClaim])
+	if role == None {
+		return nil, fmt.Errorf("token for %s without valid %q claim", subject, a.config.RoleClaim)
+	}
+	return &Principal{Name: subject, Role: role}, nil
+}
+
+// verify checks the token's signature and returns its claims
+func (a *jwtAuthenticator) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("token header: %w", err)
+	}
+	key, ok := a.keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", header.Kid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("token signature: %w", err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	claims := map[string]any{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("token claims: %w", err)
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+var esCurves = map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	switch typedKey := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(typedKey, hash, digest, signature)
+		case "PS":
+			return rsa.VerifyPSS(typedKey, hash, digest, signature, nil)
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] == "ES" {
+			// each ES algorithm has its curve, ES512 uses P-521
+			if curve := typedKey.Curve.Params().Name; curve != esCurves[alg] {
+				return fmt.Errorf("algorithm %q does not match curve %s", alg, curve)
+			}
+			size := (typedKey.Curve.Params().BitSize + 7) / 8
+			if len(signature) != 2*size {
+				return errors.New("invalid ECDSA signature length")
+			}
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if !ecdsa.Verify(typedKey, digest, r, s) {
+				return errors.New("invalid ECDSA signature")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("algorithm %q does not match key type %T", alg, key)
+}
+
+func (a *jwtAuthenticator) validate(claims map[string]any, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token without expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not yet valid")
+	}
+	if a.config.Issuer != "" && claims["iss"] != a.config.Issuer {
+		return fmt.Errorf("invalid issuer %v", claims["iss"])
+	}
+	if a.config.Audience != "" && !hasAudience(claims["aud"], a.config.Audience) {
+		return fmt.Errorf("invalid audience %v", claims["aud"])
+	}
+	return nil
+}
+
+// the aud claim is either a single string or a list of strings
+func hasAudience(claim any, audience string) bool {
+	switch typedClaim := claim.(type) {
+	case string:
+		return typedClaim == audience
+	case []any:
+		for _, aud := range typedClaim {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the role claim is either a single role name or a list of role names
+func highestRole(claim any) Role {
+	names := []any{claim}
+	if list, ok := claim.([]any); ok {
+		names = list
+	}
+	highest := None
+	for _, name := range names {
+		if typedName, ok := name.(string); ok {
+			if role, err := ParseRole(typedName); err == nil && role > highest {
+				highest = role
+			}
+		}
+	}
+	return highest
+}
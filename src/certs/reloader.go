@@ -0,0 +1,129 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+The reloader serves the server certificate and the optional client CA bundle from files.
+The files are checked for modifications on each TLS handshake, so renewed certificates
+are picked up without restarting. If a changed file can't be loaded the previous
+certificates are kept.
+*/
+type Reloader struct {
+	mu           sync.Mutex
+	certFile     string
+	keyFile      string
+	clientCaFile string
+	modTimes     map[string]time.Time
+	cert         *tls.Certificate
+	clientCAs    *x509.CertPool
+}
+
+// The client CA file is optional. If given, clients must present a certificate signed by one of its CAs.
+func NewReloader(certFile string, keyFile string, clientCaFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCaFile: clientCaFile,
+		modTimes:     map[string]time.Time{},
+	}
+	if _, err := r.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.configForClient,
+	}
+}
+
+func (r *Reloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	if reloaded, err := r.reloadIfChanged(); err != nil {
+		slog.Error("Error reloading TLS certificates, keeping the previous ones", "error", err)
+	} else if reloaded {
+		slog.Info("Reloaded TLS certificates")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*r.cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.clientCAs != nil {
+		config.ClientCAs = r.clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func (r *Reloader) reloadIfChanged() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCaFile != "" {
+		files = append(files, r.clientCaFile)
+	}
+	modTimes := map[string]time.Time{}
+	changed := false
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		modTimes[file] = info.ModTime()
+		changed = changed || !info.ModTime().Equal(r.modTimes[file])
+	}
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("loading certificate %s with key %s: %w", r.certFile, r.keyFile, err)
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCaFile != "" {
+		pem, err := os.ReadFile(r.clientCaFile)
+		if err != nil {
+			return false, err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("no certificates in client CA bundle %s", r.clientCaFile)
+		}
+	}
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return true, nil
+}
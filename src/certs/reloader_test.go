@@ -0,0 +1,101 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treblada/ecl310-rest/certs"
+	"gotest.tools/v3/assert"
+)
+
+// writeCertificate writes a self-signed certificate and its key, the files' modification time is set to modTime
+func writeCertificate(t *testing.T, certFile string, keyFile string, serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "ecl310-rest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NilError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NilError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	assert.NilError(t, os.Chtimes(certFile, modTime, modTime))
+	assert.NilError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func servedSerial(t *testing.T, config *tls.Config) int64 {
+	serverConfig, err := config.GetConfigForClient(&tls.ClientHelloInfo{})
+	assert.NilError(t, err)
+	leaf, err := x509.ParseCertificate(serverConfig.Certificates[0].Certificate[0])
+	assert.NilError(t, err)
+	return leaf.SerialNumber.Int64()
+}
+
+func TestReloader__reloadOnChange(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, 1, time.Now().Add(-time.Minute))
+
+	reloader, err := certs.NewReloader(certFile, keyFile, "")
+	assert.NilError(t, err)
+	config := reloader.TLSConfig()
+	assert.Equal(t, int64(1), servedSerial(t, config))
+
+	writeCertificate(t, certFile, keyFile, 2, time.Now())
+	assert.Equal(t, int64(2), servedSerial(t, config))
+
+	// a broken update keeps the previous certificate
+	assert.NilError(t, os.WriteFile(keyFile, []byte("garbage"), 0600))
+	assert.Equal(t, int64(2), servedSerial(t, config))
+}
+
+func TestReloader__clientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, 1, time.Now())
+
+	reloader, err := certs.NewReloader(certFile, keyFile, certFile)
+	assert.NilError(t, err)
+	serverConfig, err := reloader.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	assert.NilError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, serverConfig.ClientAuth)
+	assert.Assert(t, serverConfig.ClientCAs != nil)
+
+	_, err = certs.NewReloader(certFile, keyFile, keyFile)
+	assert.ErrorContains(t, err, "no certificates")
+}
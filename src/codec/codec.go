@@ -0,0 +1,164 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+/*
+Package codec converts between the raw 16 bit holding registers of the ECL310 and typed
+values. Registers are transferred big-endian, values spanning several registers start
+with the most significant word.
+*/
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Registers holds the bytes of consecutive registers as returned by the modbus client
+type Registers []byte
+
+// Len returns the number of registers
+func (r Registers) Len() int {
+	return len(r) / 2
+}
+
+func (r Registers) Uint16(i int) uint16 {
+	return binary.BigEndian.Uint16(r[i*2 : i*2+2])
+}
+
+func (r Registers) Int16(i int) int16 {
+	return int16(r.Uint16(i))
+}
+
+// Uint32 decodes the registers i and i+1
+func (r Registers) Uint32(i int) uint32 {
+	return binary.BigEndian.Uint32(r[i*2 : i*2+4])
+}
+
+// Decimal decodes a signed value stored with the given number of decimal places, e.g. 18 as 1.8
+func (r Registers) Decimal(i int, places int) float64 {
+	return float64(r.Int16(i)) / math.Pow10(places)
+}
+
+func (r Registers) Bool(i int) bool {
+	return r.Uint16(i) != 0
+}
+
+func (r Registers) Bits(i int) BitField {
+	return BitField(r.Uint16(i))
+}
+
+// Bytes returns the high and low byte of a register holding two 8 bit values
+func (r Registers) Bytes(i int) (high byte, low byte) {
+	return r[i*2], r[i*2+1]
+}
+
+// Char decodes a register holding a single ASCII character, '?' if it's not printable
+func (r Registers) Char(i int) string {
+	value := r.Uint16(i)
+	if value < 0x20 || value > 0x7e {
+		return "?"
+	}
+	return string(rune(value))
+}
+
+// IPv4 decodes the four registers starting at i, each holding one octet
+func (r Registers) IPv4(i int) string {
+	octets := make([]string, 4)
+	for j := range octets {
+		octets[j] = fmt.Sprint(r.Uint16(i + j))
+	}
+	return strings.Join(octets, ".")
+}
+
+func (r Registers) Enum(i int, e Enum) string {
+	return e.Name(r.Uint16(i))
+}
+
+type BitField uint16
+
+func (b BitField) Bit(n uint) bool {
+	return b&(1<<n) != 0
+}
+
+func (b BitField) With(n uint, value bool) BitField {
+	if value {
+		return b | 1<<n
+	}
+	return b &^ (1 << n)
+}
+
+/*
+An enum lists the names of the values 0 to n-1 of a register. Values the controller may
+report beyond the documented ones decode to UNKNOWN(n) instead of failing.
+*/
+type Enum []string
+
+func (e Enum) Lookup(value uint16) (string, bool) {
+	if int(value) < len(e) && e[value] != "" {
+		return e[value], true
+	}
+	return "", false
+}
+
+func (e Enum) Name(value uint16) string {
+	if name, ok := e.Lookup(value); ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", value)
+}
+
+func (e Enum) Value(name string) (uint16, bool) {
+	for i, enumName := range e {
+		if enumName != "" && enumName == name {
+			return uint16(i), true
+		}
+	}
+	return 0, false
+}
+
+// EncodeUint16 returns the register value for v, an error if v does not fit
+func EncodeUint16(v int64) (uint16, error) {
+	if v < 0 || v > math.MaxUint16 {
+		return 0, fmt.Errorf("value %d out of range [0, %d]", v, math.MaxUint16)
+	}
+	return uint16(v), nil
+}
+
+// EncodeInt16 returns the two's complement register value for v, an error if v does not fit
+func EncodeInt16(v int64) (uint16, error) {
+	if v < math.MinInt16 || v > math.MaxInt16 {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, math.MinInt16, math.MaxInt16)
+	}
+	return uint16(int16(v)), nil
+}
+
+// EncodeDecimal rounds v to the given number of decimal places and encodes it like Decimal decodes it
+func EncodeDecimal(v float64, places int) (uint16, error) {
+	scaled := math.Round(v * math.Pow10(places))
+	if math.IsNaN(scaled) || scaled < math.MinInt16 || scaled > math.MaxInt16 {
+		return 0, fmt.Errorf("value %v out of range for %d decimal places", v, places)
+	}
+	return uint16(int16(scaled)), nil
+}
+
+func EncodeBool(v bool) uint16 {
+	if v {
+		return 1
+	}
+	return 0
+}
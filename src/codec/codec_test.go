@@ -0,0 +1,86 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package codec_test
+
+import (
+	"testing"
+
+	"github.com/treblada/ecl310-rest/codec"
+	"gotest.tools/v3/assert"
+)
+
+func TestRegisters__numbers(t *testing.T) {
+	r := codec.Registers{0xff, 0xf6, 0, 42, 0, 2, 0, 42}
+	assert.Equal(t, 4, r.Len())
+	assert.Equal(t, int16(-10), r.Int16(0))
+	assert.Equal(t, uint16(0xfff6), r.Uint16(0))
+	assert.Equal(t, uint16(42), r.Uint16(1))
+	assert.Equal(t, uint32(2<<16+42), r.Uint32(2))
+	assert.Equal(t, -1.0, r.Decimal(0, 1))
+	assert.Equal(t, 4.2, r.Decimal(1, 1))
+	assert.Assert(t, r.Bool(1))
+}
+
+func TestRegisters__bitsAndBytes(t *testing.T) {
+	r := codec.Registers{3, 7, 0, 5}
+	high, low := r.Bytes(0)
+	assert.Equal(t, byte(3), high)
+	assert.Equal(t, byte(7), low)
+	bits := r.Bits(1)
+	assert.Assert(t, bits.Bit(0))
+	assert.Assert(t, !bits.Bit(1))
+	assert.Assert(t, bits.Bit(2))
+	assert.Equal(t, codec.BitField(7), bits.With(1, true))
+	assert.Equal(t, codec.BitField(4), bits.With(0, false))
+}
+
+func TestRegisters__strings(t *testing.T) {
+	r := codec.Registers{0, 'f', 0, 0, 0, 192, 0, 168, 0, 1, 0, 1}
+	assert.Equal(t, "f", r.Char(0))
+	assert.Equal(t, "?", r.Char(1))
+	assert.Equal(t, "192.168.1.1", r.IPv4(2))
+}
+
+func TestEnum__unknownFallback(t *testing.T) {
+	e := codec.Enum{"OFF", "ON"}
+	r := codec.Registers{0, 1, 0, 7}
+	assert.Equal(t, "ON", r.Enum(0, e))
+	assert.Equal(t, "UNKNOWN(7)", r.Enum(1, e))
+	value, ok := e.Value("OFF")
+	assert.Assert(t, ok)
+	assert.Equal(t, uint16(0), value)
+	_, ok = e.Value("UNKNOWN(7)")
+	assert.Assert(t, !ok)
+}
+
+func TestEncode__roundTrip(t *testing.T) {
+	value, err := codec.EncodeInt16(-25)
+	assert.NilError(t, err)
+	assert.Equal(t, int16(-25), codec.Registers{byte(value >> 8), byte(value)}.Int16(0))
+
+	value, err = codec.EncodeDecimal(-1.85, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, -1.9, codec.Registers{byte(value >> 8), byte(value)}.Decimal(0, 1))
+
+	_, err = codec.EncodeInt16(40000)
+	assert.ErrorContains(t, err, "out of range")
+	_, err = codec.EncodeUint16(-1)
+	assert.ErrorContains(t, err, "out of range")
+	_, err = codec.EncodeDecimal(5000, 1)
+	assert.ErrorContains(t, err, "out of range")
+	assert.Equal(t, uint16(1), codec.EncodeBool(true))
+}
@@ -1,21 +1,98 @@
 package main
 
-import "flag"
+import (
+	"flag"
+	"time"
+)
 
 type CmdLineArgs struct {
-	eclHost    string
-	eclPort    int
-	listenPort int
+	eclHost     string
+	eclPort     int
+	listenPort  int
+	auditLog    string
+	authConfig  string
+	tlsCert     string
+	tlsKey      string
+	tlsClientCa string
+	readMaxGap  uint
+	readMaxSize uint
+	// consecutive failures opening the circuit breaker, 0 to disable it
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	logLevel         string
+	logFormat        string
+	requireIfMatch   bool
+	// tracing is disabled if both are empty
+	traceFile     string
+	traceEndpoint string
+	// IANA time zone of the controller's clock, the host's if empty
+	timeZone string
+	// the controller's clock isn't synchronised if 0
+	clockSyncInterval  time.Duration
+	clockSyncThreshold time.Duration
+	// the history is disabled if empty
+	historyDir          string
+	historyInterval     time.Duration
+	historySeries       string
+	historyRetention    time.Duration
+	historyCompactAfter time.Duration
+	historyCompactStep  time.Duration
 }
 
 func parseCmdLine() CmdLineArgs {
 	host := flag.String("host", "localhost", "ECL310 hostname or IP address. Defaults to localhost")
 	port := flag.Int("port", 502, "ECL310 MODbus port. Defaults to 502")
 	listenPort := flag.Int("listen", 8080, "Local port this application is listing to")
+	auditLog := flag.String("audit", "ecl310-rest-audit.jsonl", "Append-only audit log file for all writes to the ECL310. Empty to disable")
+	authConfig := flag.String("auth", "", "JSON file configuring API keys, users and JWT validation. Authentication is disabled if empty")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file, enables HTTPS. Reloaded when changed")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for the certificate")
+	tlsClientCa := flag.String("tls-client-ca", "", "PEM CA bundle, requires clients to present a certificate signed by one of these CAs")
+	readMaxGap := flag.Uint("read-max-gap", 16, "Maximum number of unused registers between PNUs read in one request")
+	readMaxSize := flag.Uint("read-max-size", 64, "Maximum number of registers read in one request, 0 disables merging reads")
+	breakerThreshold := flag.Int("breaker-threshold", 5, "Consecutive failed modbus requests after which requests fail fast, 0 to disable")
+	breakerCooldown := flag.Duration("breaker-cooldown", 30*time.Second, "Time requests fail fast before the ECL310 is probed again")
+	logLevel := flag.String("log-level", "info", "Minimum level of log messages: debug, info, warn or error. Modbus requests are logged at debug level")
+	logFormat := flag.String("log-format", "text", "Log output format: text or json")
+	requireIfMatch := flag.Bool("require-if-match", false, "Reject writes without an If-Match header holding the ETag of the resource they change")
+	traceFile := flag.String("trace-file", "", "File OpenTelemetry spans are appended to as JSON, one span per line. Empty to disable")
+	traceEndpoint := flag.String("trace-endpoint", "", "OTLP/HTTP collector URL spans are sent to, e.g. http://localhost:4318. Empty to disable")
+	timeZone := flag.String("time-zone", "", "IANA time zone the controller's clock is kept in, e.g. Europe/Berlin. Defaults to the host's time zone")
+	clockSyncInterval := flag.Duration("clock-sync-interval", 0, "Interval the controller's clock is compared with the host's clock, 0 to disable")
+	clockSyncThreshold := flag.Duration("clock-sync-threshold", 2*time.Minute, "Drift beyond which the clock sync corrects the controller's clock")
+	historyDir := flag.String("history-dir", "", "Directory the polled values are recorded in, one file per day. Empty to disable the history")
+	historyInterval := flag.Duration("history-interval", time.Minute, "Interval the history's series are polled")
+	historySeries := flag.String("history-series", "", "JSON file of the series recorded in the history, the sensors, modes, states and heat curves by default")
+	historyRetention := flag.Duration("history-retention", 365*24*time.Hour, "Age after which recorded values are deleted, 0 to keep them forever")
+	historyCompactAfter := flag.Duration("history-compact-after", 7*24*time.Hour, "Age after which recorded values are compacted, 0 to disable compaction")
+	historyCompactStep := flag.Duration("history-compact-step", 15*time.Minute, "Step compacted values keep the min, max and average of")
 	flag.Parse()
 	return CmdLineArgs{
-		eclHost:    *host,
-		eclPort:    *port,
-		listenPort: *listenPort,
+		eclHost:             *host,
+		eclPort:             *port,
+		listenPort:          *listenPort,
+		auditLog:            *auditLog,
+		authConfig:          *authConfig,
+		tlsCert:             *tlsCert,
+		tlsKey:              *tlsKey,
+		tlsClientCa:         *tlsClientCa,
+		readMaxGap:          *readMaxGap,
+		readMaxSize:         *readMaxSize,
+		breakerThreshold:    *breakerThreshold,
+		breakerCooldown:     *breakerCooldown,
+		logLevel:            *logLevel,
+		logFormat:           *logFormat,
+		requireIfMatch:      *requireIfMatch,
+		traceFile:           *traceFile,
+		traceEndpoint:       *traceEndpoint,
+		timeZone:            *timeZone,
+		clockSyncInterval:   *clockSyncInterval,
+		clockSyncThreshold:  *clockSyncThreshold,
+		historyDir:          *historyDir,
+		historyInterval:     *historyInterval,
+		historySeries:       *historySeries,
+		historyRetention:    *historyRetention,
+		historyCompactAfter: *historyCompactAfter,
+		historyCompactStep:  *historyCompactStep,
 	}
 }
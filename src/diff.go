@@ -0,0 +1,144 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/treblada/ecl310-rest/auth"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	api "github.com/treblada/ecl310-rest/services"
+)
+
+// Environment variable holding the API key for gateways, if not given by -api-key
+const apiKeyEnv = "ECL310_API_KEY"
+
+/*
+runDiff compares two configurations, each either a backup file or the URL of a gateway
+whose /backup is read. Like diff(1) it exits with 1 if they differ and 2 on errors.
+*/
+func runDiff(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ecl310-rest diff [flags] FROM TO")
+		fmt.Fprintln(flags.Output(), "FROM and TO are backup files or gateway URLs like https://ecl.example.com:8080")
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", "text", "Output format: text or json")
+	apiKey := flags.String("api-key", "", "API key sent to gateways, defaults to $"+apiKeyEnv)
+	timeout := flags.Duration("timeout", 30*time.Second, "Timeout for reading a gateway's backup")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 || (*format != "text" && *format != "json") {
+		flags.Usage()
+		return 2
+	}
+
+	if *apiKey == "" {
+		*apiKey = os.Getenv(apiKeyEnv)
+	}
+	client := &http.Client{Timeout: *timeout}
+	backups := make([]openapi.BackupDocument, 2)
+	for i, source := range flags.Args() {
+		backup, err := loadBackup(client, source, *apiKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", source, err)
+			return 2
+		}
+		backups[i] = backup
+	}
+
+	diff := api.DiffBackups(backups[0], backups[1])
+	if *format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(diff); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	} else {
+		writeDiffTable(out, diff)
+	}
+	if len(diff.Entries) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func loadBackup(client *http.Client, source string, apiKey string) (openapi.BackupDocument, error) {
+	var backup openapi.BackupDocument
+	var body io.ReadCloser
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		request, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(source, "/")+"/backup", nil)
+		if err != nil {
+			return backup, err
+		}
+		if apiKey != "" {
+			request.Header.Set(auth.ApiKeyHeader, apiKey)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return backup, err
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return backup, fmt.Errorf("reading the backup failed: %s", response.Status)
+		}
+		body = response.Body
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			return backup, err
+		}
+		body = file
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&backup); err != nil {
+		return backup, fmt.Errorf("invalid backup: %w", err)
+	}
+	return backup, nil
+}
+
+func writeDiffTable(out io.Writer, diff openapi.ConfigDiff) {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "STATUS\tPARAMETER\tPNU\tFROM\tTO")
+	for _, entry := range diff.Entries {
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\n", entry.Status, entry.Name, entry.Pnu, withUnit(entry.From, entry.Unit), withUnit(entry.To, entry.Unit))
+	}
+	table.Flush()
+	fmt.Fprintf(out, "%d of %d parameters differ\n", len(diff.Entries), diff.Compared)
+}
+
+// withUnit appends the unit to a value, a missing value is shown as -
+func withUnit(value string, unit string) string {
+	if value == "" {
+		return "-"
+	}
+	if unit == "" {
+		return value
+	}
+	return value + " " + unit
+}
@@ -0,0 +1,147 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/treblada/ecl310-rest/auth"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"gotest.tools/v3/assert"
+)
+
+func testBackup(comfortRoomTemp int32) openapi.BackupDocument {
+	return openapi.BackupDocument{
+		Version:            1,
+		Controller:         openapi.GetSystemInfoResponse{Application: "A266.1", SoftwareVersion: 42},
+		AutoDaylightSaving: true,
+		Circuits: []openapi.CircuitBackup{{
+			CircuitNo:       1,
+			Mode:            "SCHEDULED",
+			ComfortRoomTemp: comfortRoomTemp,
+			SetbackRoomTemp: 16,
+			Slope:           -1.7,
+			MinFlowTemp:     30,
+			MaxFlowTemp:     70,
+			CurvePoints: []openapi.FlowTempPoint{
+				{OutdoorTemp: -30, FlowTemp: 65},
+				{OutdoorTemp: -15, FlowTemp: 63},
+				{OutdoorTemp: -5, FlowTemp: 61},
+				{OutdoorTemp: 0, FlowTemp: 59},
+				{OutdoorTemp: 5, FlowTemp: 57},
+				{OutdoorTemp: 15, FlowTemp: 55},
+			},
+		}},
+	}
+}
+
+func writeBackupFile(t *testing.T, backup openapi.BackupDocument) string {
+	path := filepath.Join(t.TempDir(), "backup.json")
+	content, err := json.Marshal(backup)
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(path, content, 0o600))
+	return path
+}
+
+// gateway serves the backup to requests with the API key
+func gateway(t *testing.T, backup openapi.BackupDocument, apiKey string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/backup" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get(auth.ApiKeyHeader) != apiKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Check(t, json.NewEncoder(w).Encode(backup))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestRunDiff__equalFiles(t *testing.T) {
+	out := &bytes.Buffer{}
+	code := runDiff([]string{writeBackupFile(t, testBackup(21)), writeBackupFile(t, testBackup(21))}, out)
+	assert.Equal(t, 0, code)
+	assert.Check(t, strings.HasSuffix(out.String(), "0 of 17 parameters differ\n"), out.String())
+}
+
+func TestRunDiff__differentFiles(t *testing.T) {
+	out := &bytes.Buffer{}
+	code := runDiff([]string{writeBackupFile(t, testBackup(21)), writeBackupFile(t, testBackup(23))}, out)
+	assert.Equal(t, 1, code)
+	assert.Check(t, strings.Contains(out.String(), "circuit1.comfortRoomTemp"), out.String())
+	assert.Check(t, strings.HasSuffix(out.String(), "1 of 17 parameters differ\n"), out.String())
+}
+
+func TestRunDiff__json(t *testing.T) {
+	out := &bytes.Buffer{}
+	code := runDiff([]string{"-format", "json", writeBackupFile(t, testBackup(21)), writeBackupFile(t, testBackup(23))}, out)
+	assert.Equal(t, 1, code)
+	var diff openapi.ConfigDiff
+	assert.NilError(t, json.Unmarshal(out.Bytes(), &diff))
+	assert.Equal(t, 1, len(diff.Entries))
+	assert.Equal(t, "23", diff.Entries[0].To)
+}
+
+func TestRunDiff__gatewayWithApiKeyFlag(t *testing.T) {
+	url := gateway(t, testBackup(23), "secret")
+	out := &bytes.Buffer{}
+	code := runDiff([]string{"-api-key", "secret", writeBackupFile(t, testBackup(21)), url + "/"}, out)
+	assert.Equal(t, 1, code)
+	assert.Check(t, strings.Contains(out.String(), "circuit1.comfortRoomTemp"), out.String())
+}
+
+func TestRunDiff__gatewayWithApiKeyFromEnvironment(t *testing.T) {
+	t.Setenv(apiKeyEnv, "secret")
+	url := gateway(t, testBackup(21), "secret")
+	code := runDiff([]string{url, writeBackupFile(t, testBackup(21))}, &bytes.Buffer{})
+	assert.Equal(t, 0, code)
+}
+
+func TestRunDiff__errors(t *testing.T) {
+	t.Setenv(apiKeyEnv, "")
+	file := writeBackupFile(t, testBackup(21))
+	invalid := filepath.Join(t.TempDir(), "invalid.json")
+	assert.NilError(t, os.WriteFile(invalid, []byte("{"), 0o600))
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"one source", []string{file}},
+		{"unknown format", []string{"-format", "xml", file, file}},
+		{"unknown flag", []string{"-verbose", file, file}},
+		{"missing file", []string{file, filepath.Join(t.TempDir(), "missing.json")}},
+		{"invalid file", []string{file, invalid}},
+		{"unauthorized", []string{file, gateway(t, testBackup(21), "secret")}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			assert.Equal(t, 2, runDiff(test.args, out))
+			assert.Equal(t, "", out.String())
+		})
+	}
+}
@@ -0,0 +1,104 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package etag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const Header = "ETag"
+
+// Compute returns a strong entity tag of the register values a resource is built from
+func Compute(registers ...[]byte) string {
+	hash := sha256.New()
+	for _, r := range registers {
+		hash.Write(r)
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:16] + `"`
+}
+
+/*
+Match reports whether the If-Match header value matches the current entity tag, using
+the strong comparison required for If-Match: weak tags never match.
+*/
+func Match(ifMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey int
+
+const holderKey contextKey = iota
+
+type holder struct {
+	mu   sync.Mutex
+	etag string
+}
+
+// Set reports the entity tag of the resource the response represents
+func Set(ctx context.Context, etag string) {
+	if h, ok := ctx.Value(holderKey).(*holder); ok {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.etag = etag
+	}
+}
+
+type etagWriter struct {
+	http.ResponseWriter
+	holder      *holder
+	wroteHeader bool
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.holder.mu.Lock()
+		etag := w.holder.etag
+		w.holder.mu.Unlock()
+		if etag != "" && status >= 200 && status < 300 {
+			w.ResponseWriter.Header().Set(Header, etag)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Middleware adds the entity tag the services Set to successful responses
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := &holder{}
+		ctx := context.WithValue(r.Context(), holderKey, h)
+		next.ServeHTTP(&etagWriter{ResponseWriter: w, holder: h}, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,59 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package etag_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/treblada/ecl310-rest/etag"
+	"gotest.tools/v3/assert"
+)
+
+func TestCompute__dependsOnAllRegisters(t *testing.T) {
+	tag := etag.Compute([]byte{0, 17}, []byte{0, 33, 0, 66})
+	assert.Equal(t, 18, len(tag))
+	assert.Equal(t, tag, etag.Compute([]byte{0, 17}, []byte{0, 33, 0, 66}))
+	assert.Assert(t, tag != etag.Compute([]byte{0, 17}, []byte{0, 33, 0, 67}))
+}
+
+func TestMatch(t *testing.T) {
+	tag := `"0123456789abcdef"`
+	assert.Assert(t, etag.Match(tag, tag))
+	assert.Assert(t, etag.Match(`"other", `+tag, tag))
+	assert.Assert(t, etag.Match("*", tag))
+	assert.Assert(t, !etag.Match(`"other"`, tag))
+	// If-Match uses the strong comparison
+	assert.Assert(t, !etag.Match("W/"+tag, tag))
+}
+
+func TestMiddleware__setsHeaderOnSuccess(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusBadGateway} {
+		handler := etag.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			etag.Set(r.Context(), `"0123456789abcdef"`)
+			w.WriteHeader(status)
+		}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/heatcurve/1", nil))
+		if status == http.StatusOK {
+			assert.Equal(t, `"0123456789abcdef"`, w.Header().Get(etag.Header))
+		} else {
+			assert.Equal(t, "", w.Header().Get(etag.Header))
+		}
+	}
+}
@@ -0,0 +1,392 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sample holds the values of the series polled at one time
+type Sample struct {
+	Time   time.Time          `json:"t"`
+	Values map[string]float64 `json:"v,omitempty"`
+	// a compacted sample aggregates the values of a step starting at its time
+	Aggregates map[string]Aggregate `json:"a,omitempty"`
+}
+
+type Aggregate struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Sum   float64 `json:"sum"`
+	Count int     `json:"n"`
+}
+
+func (a Aggregate) Avg() float64 {
+	return a.Sum / float64(a.Count)
+}
+
+func (a *Aggregate) add(other Aggregate) {
+	if a.Count == 0 {
+		*a = other
+		return
+	}
+	a.Min = math.Min(a.Min, other.Min)
+	a.Max = math.Max(a.Max, other.Max)
+	a.Sum += other.Sum
+	a.Count += other.Count
+}
+
+func single(value float64) Aggregate {
+	return Aggregate{Min: value, Max: value, Sum: value, Count: 1}
+}
+
+// Query selects the samples from From to To, both inclusive, downsampled to steps starting at From
+type Query struct {
+	Series []string
+	From   time.Time
+	To     time.Time
+	Step   time.Duration
+}
+
+// Point aggregates the values of a series in the step starting at its time
+type Point struct {
+	Time time.Time
+	Aggregate
+}
+
+type Store interface {
+	Append(sample Sample) error
+	// Query returns the points of each series, steps without values are left out
+	Query(query Query) (map[string][]Point, error)
+}
+
+// Settings of the file store, zero durations disable retention or compaction
+type Retention struct {
+	// files are deleted once all their samples are older
+	MaxAge time.Duration
+	// files are compacted once all their samples are older
+	CompactAfter time.Duration
+	// compacted files keep the min, max and average of each step
+	CompactStep time.Duration
+}
+
+const (
+	fileSuffix      = ".jsonl"
+	compactedSuffix = ".compacted.jsonl"
+	dayLayout       = "2006-01-02"
+)
+
+/*
+The file store appends the samples as JSON lines to one file per UTC day. Whenever a new
+day starts, the files past the retention are deleted and those past the compaction age
+are rewritten with one aggregated sample per compaction step.
+*/
+type FileStore struct {
+	mu        sync.Mutex
+	dir       string
+	retention Retention
+	file      *os.File
+	fileDay   string
+}
+
+func NewFileStore(dir string, retention Retention) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("creating history directory %s: %w", dir, err)
+	}
+	store := &FileStore{dir: dir, retention: retention}
+	if err := store.compact(); err != nil {
+		slog.Warn("Error compacting the history", "error", err)
+	}
+	return store, nil
+}
+
+func (s *FileStore) Append(sample Sample) error {
+	line, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	day := sample.Time.UTC().Format(dayLayout)
+	if day != s.fileDay {
+		if err := s.open(day); err != nil {
+			return err
+		}
+	}
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// open switches to the file of the day, compacting the older files. A failed compaction
+// is only logged, it's retried on the next day.
+func (s *FileStore) open(day string) error {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	file, err := os.OpenFile(filepath.Join(s.dir, day+fileSuffix), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("opening history file: %w", err)
+	}
+	if err := cutPartialLine(file); err != nil {
+		file.Close()
+		return fmt.Errorf("repairing history file: %w", err)
+	}
+	s.file = file
+	s.fileDay = day
+	if err := s.compact(); err != nil {
+		slog.Warn("Error compacting the history", "error", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Query(query Query) (map[string][]Point, error) {
+	if query.Step <= 0 {
+		return nil, fmt.Errorf("invalid step %v", query.Step)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := make(map[string]bool, len(query.Series))
+	for _, name := range query.Series {
+		wanted[name] = true
+	}
+	buckets := map[string]map[int64]*Aggregate{}
+	collect := func(name string, at time.Time, value Aggregate) {
+		if !wanted[name] || at.Before(query.From) || at.After(query.To) {
+			return
+		}
+		bucket := int64(at.Sub(query.From) / query.Step)
+		if buckets[name] == nil {
+			buckets[name] = map[int64]*Aggregate{}
+		}
+		if buckets[name][bucket] == nil {
+			buckets[name][bucket] = &Aggregate{}
+		}
+		buckets[name][bucket].add(value)
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.day.Add(24*time.Hour).Before(query.From) || file.day.After(query.To) {
+			continue
+		}
+		err := readSamples(file.path, func(sample Sample) {
+			for name, value := range sample.Values {
+				collect(name, sample.Time, single(value))
+			}
+			for name, aggregate := range sample.Aggregates {
+				collect(name, sample.Time, aggregate)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string][]Point, len(query.Series))
+	for _, name := range query.Series {
+		points := make([]Point, 0, len(buckets[name]))
+		for bucket, aggregate := range buckets[name] {
+			points = append(points, Point{Time: query.From.Add(time.Duration(bucket) * query.Step), Aggregate: *aggregate})
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+		result[name] = points
+	}
+	return result, nil
+}
+
+type dayFile struct {
+	path      string
+	day       time.Time
+	compacted bool
+}
+
+// files lists the day files in the directory, ignoring any other files
+func (s *FileStore) files() ([]dayFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading history directory: %w", err)
+	}
+	files := []dayFile{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		compacted := strings.HasSuffix(name, compactedSuffix)
+		day, err := time.Parse(dayLayout, strings.TrimSuffix(strings.TrimSuffix(name, compactedSuffix), fileSuffix))
+		if err != nil {
+			continue
+		}
+		files = append(files, dayFile{path: filepath.Join(s.dir, name), day: day, compacted: compacted})
+	}
+	return files, nil
+}
+
+/*
+readSamples passes the samples of the file to handle. An unparsable final line, left by a
+write interrupted by a crash, is skipped with a warning, any other one fails.
+*/
+func readSamples(path string, handle func(sample Sample)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	var lineErr error
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if lineErr != nil {
+			return lineErr
+		}
+		var sample Sample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			lineErr = fmt.Errorf("history file %s line %d: %w", path, lineNo, err)
+			continue
+		}
+		handle(sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if lineErr != nil {
+		slog.Warn("Skipping the unparsable final line of a history file", "error", lineErr)
+	}
+	return nil
+}
+
+// cutPartialLine removes a final line without newline, so the next sample starts a line of its own
+func cutPartialLine(file *os.File) error {
+	content, err := os.ReadFile(file.Name())
+	if err != nil || len(content) == 0 || content[len(content)-1] == '\n' {
+		return err
+	}
+	slog.Warn("Removing the partial final line of a history file", "file", file.Name())
+	return file.Truncate(int64(bytes.LastIndexByte(content, '\n') + 1))
+}
+
+// compact deletes and compacts the files of the days past the retention, except the open one.
+// A file that fails is logged and left in place, so it doesn't hold up the others.
+func (s *FileStore) compact() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, file := range files {
+		dayEnd := file.day.Add(24 * time.Hour)
+		switch {
+		case file.day.Format(dayLayout) == s.fileDay:
+		case s.retention.MaxAge > 0 && now.Sub(dayEnd) >= s.retention.MaxAge:
+			if err := os.Remove(file.path); err != nil {
+				slog.Warn("Error deleting history file", "file", file.path, "error", err)
+			}
+		case !file.compacted && s.retention.CompactAfter > 0 && s.retention.CompactStep > 0 && now.Sub(dayEnd) >= s.retention.CompactAfter:
+			if err := s.compactFile(file); err != nil {
+				slog.Warn("Error compacting history file", "file", file.path, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// compactFile replaces the file by one holding an aggregated sample per step, merged with an existing one
+func (s *FileStore) compactFile(file dayFile) error {
+	compactedPath := filepath.Join(s.dir, file.day.Format(dayLayout)+compactedSuffix)
+	steps := map[int64]map[string]*Aggregate{}
+	aggregate := func(sample Sample) {
+		step := int64(sample.Time.Sub(file.day) / s.retention.CompactStep)
+		if steps[step] == nil {
+			steps[step] = map[string]*Aggregate{}
+		}
+		add := func(name string, value Aggregate) {
+			if steps[step][name] == nil {
+				steps[step][name] = &Aggregate{}
+			}
+			steps[step][name].add(value)
+		}
+		for name, value := range sample.Values {
+			add(name, single(value))
+		}
+		for name, aggregate := range sample.Aggregates {
+			add(name, aggregate)
+		}
+	}
+	if err := readSamples(file.path, aggregate); err != nil {
+		return err
+	}
+	if err := readSamples(compactedPath, aggregate); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	stepNos := make([]int64, 0, len(steps))
+	for stepNo := range steps {
+		stepNos = append(stepNos, stepNo)
+	}
+	sort.Slice(stepNos, func(i, j int) bool { return stepNos[i] < stepNos[j] })
+	tmpPath := compactedPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("compacting history file: %w", err)
+	}
+	writer := bufio.NewWriter(out)
+	for _, stepNo := range stepNos {
+		sample := Sample{Time: file.day.Add(time.Duration(stepNo) * s.retention.CompactStep), Aggregates: map[string]Aggregate{}}
+		for name, aggregate := range steps[stepNo] {
+			sample.Aggregates[name] = *aggregate
+		}
+		line, _ := json.Marshal(sample)
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		out.Close()
+		return fmt.Errorf("compacting history file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("compacting history file: %w", err)
+	}
+	if err := os.Rename(tmpPath, compactedPath); err != nil {
+		return fmt.Errorf("compacting history file: %w", err)
+	}
+	return os.Remove(file.path)
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,150 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package history_test
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/treblada/ecl310-rest/history"
+	"gotest.tools/v3/assert"
+)
+
+func newStore(t *testing.T, dir string, retention history.Retention) *history.FileStore {
+	store, err := history.NewFileStore(dir, retention)
+	assert.NilError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func appendValues(t *testing.T, store *history.FileStore, start time.Time, interval time.Duration, name string, values ...float64) {
+	for i, value := range values {
+		err := store.Append(history.Sample{Time: start.Add(time.Duration(i) * interval), Values: map[string]float64{name: value, "other": 1}})
+		assert.NilError(t, err)
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFileStore__downsamples(t *testing.T) {
+	dir := t.TempDir()
+	store := newStore(t, dir, history.Retention{})
+	start := time.Date(2024, 1, 15, 23, 50, 0, 0, time.UTC)
+	// crosses midnight into the next day's file
+	appendValues(t, store, start, 5*time.Minute, "flow", 40, 44, 42, 50, 0, 60)
+
+	points, err := store.Query(history.Query{Series: []string{"flow", "missing"}, From: start, To: start.Add(25 * time.Minute), Step: 10 * time.Minute})
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(points["missing"]))
+	flow := points["flow"]
+	assert.Equal(t, 3, len(flow))
+	assert.Equal(t, start, flow[0].Time)
+	assert.DeepEqual(t, flow[0].Aggregate, history.Aggregate{Min: 40, Max: 44, Sum: 84, Count: 2})
+	assert.Equal(t, 46.0, flow[1].Avg())
+	assert.DeepEqual(t, flow[2].Aggregate, history.Aggregate{Min: 0, Max: 60, Sum: 60, Count: 2})
+	assert.DeepEqual(t, dirNames(t, dir), []string{"2024-01-15.jsonl", "2024-01-16.jsonl"})
+}
+
+func TestFileStore__compactsAndDeletes(t *testing.T) {
+	dir := t.TempDir()
+	retention := history.Retention{MaxAge: 30 * 24 * time.Hour, CompactAfter: 7 * 24 * time.Hour, CompactStep: 15 * time.Minute}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	old := today.Add(-40 * 24 * time.Hour)
+	compacted := today.Add(-10 * 24 * time.Hour)
+	store := newStore(t, dir, retention)
+	appendValues(t, store, old, time.Minute, "flow", 40)
+	appendValues(t, store, compacted, time.Minute, "flow", 40, 42, 44, 46, 48, 50, 52, 54, 56, 58, 60, 62, 64, 66, 68, 70, 72)
+	appendValues(t, store, today, time.Minute, "flow", 30)
+
+	assert.DeepEqual(t, dirNames(t, dir), []string{compacted.Format("2006-01-02") + ".compacted.jsonl", today.Format("2006-01-02") + ".jsonl"})
+	points, err := store.Query(history.Query{Series: []string{"flow"}, From: compacted, To: today, Step: time.Minute})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, points["flow"], []history.Point{
+		{Time: compacted, Aggregate: history.Aggregate{Min: 40, Max: 68, Sum: 810, Count: 15}},
+		{Time: compacted.Add(15 * time.Minute), Aggregate: history.Aggregate{Min: 70, Max: 72, Sum: 142, Count: 2}},
+		{Time: today, Aggregate: history.Aggregate{Min: 30, Max: 30, Sum: 30, Count: 1}},
+	})
+
+	// the samples survive a restart
+	store.Close()
+	reopened := newStore(t, dir, retention)
+	points, err = reopened.Query(history.Query{Series: []string{"other"}, From: compacted, To: today, Step: 24 * time.Hour})
+	assert.NilError(t, err)
+	assert.Equal(t, 18, points["other"][0].Count+points["other"][1].Count)
+}
+
+func TestFileStore__ignoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("notes"), 0640))
+	store := newStore(t, dir, history.Retention{MaxAge: time.Hour})
+	points, err := store.Query(history.Query{Series: []string{"flow"}, From: time.Now().Add(-time.Hour), To: time.Now(), Step: time.Minute})
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(points["flow"]))
+	assert.DeepEqual(t, dirNames(t, dir), []string{"README"})
+}
+
+func TestFileStore__skipsPartialFinalLine(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	// a write interrupted by a crash
+	content := `{"t":"2024-01-15T12:00:00Z","v":{"flow":40}}` + "\n" + `{"t":"2024-01-15T12:01:00Z","v":{"fl`
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "2024-01-15.jsonl"), []byte(content), 0640))
+	store := newStore(t, dir, history.Retention{})
+	query := history.Query{Series: []string{"flow"}, From: start, To: start.Add(time.Hour), Step: time.Hour}
+
+	points, err := store.Query(query)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, points["flow"], []history.Point{{Time: start, Aggregate: history.Aggregate{Min: 40, Max: 40, Sum: 40, Count: 1}}})
+
+	// the next sample doesn't continue the partial line
+	appendValues(t, store, start.Add(2*time.Minute), time.Minute, "flow", 50)
+	points, err = store.Query(query)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, points["flow"], []history.Point{{Time: start, Aggregate: history.Aggregate{Min: 40, Max: 50, Sum: 90, Count: 2}}})
+}
+
+func TestFileStore__keepsRecordingWhenCompactionFails(t *testing.T) {
+	dir := t.TempDir()
+	retention := history.Retention{MaxAge: 30 * 24 * time.Hour, CompactAfter: 7 * 24 * time.Hour, CompactStep: 15 * time.Minute}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	corrupt := today.Add(-10 * 24 * time.Hour).Format("2006-01-02")
+	old := today.Add(-9 * 24 * time.Hour).Format("2006-01-02")
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, corrupt+".jsonl"), []byte("garbage\n"+`{"t":"`+corrupt+`T12:00:00Z","v":{"flow":40}}`+"\n"), 0640))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, old+".jsonl"), []byte(`{"t":"`+old+`T12:00:00Z","v":{"flow":40}}`+"\n"), 0640))
+
+	// the corrupt file is left for inspection, the others are compacted
+	store := newStore(t, dir, retention)
+	assert.DeepEqual(t, dirNames(t, dir), []string{corrupt + ".jsonl", old + ".compacted.jsonl"})
+
+	// the compaction on a new day fails again, the sample is recorded anyway
+	appendValues(t, store, today, time.Minute, "flow", 30)
+	points, err := store.Query(history.Query{Series: []string{"flow"}, From: today, To: today.Add(time.Hour), Step: time.Hour})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, points["flow"], []history.Point{{Time: today, Aggregate: history.Aggregate{Min: 30, Max: 30, Sum: 30, Count: 1}}})
+}
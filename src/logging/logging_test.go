@@ -0,0 +1,82 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/treblada/ecl310-rest/logging"
+	"gotest.tools/v3/assert"
+)
+
+func serve(t *testing.T, requestID string) (*httptest.ResponseRecorder, string) {
+	var seen string
+	handler := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/system/info", nil)
+	if requestID != "" {
+		r.Header.Set(logging.RequestIDHeader, requestID)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w, seen
+}
+
+func TestMiddleware__propagatesRequestID(t *testing.T) {
+	w, seen := serve(t, "abc-123")
+	assert.Equal(t, "abc-123", seen)
+	assert.Equal(t, "abc-123", w.Header().Get(logging.RequestIDHeader))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestMiddleware__generatesMissingRequestID(t *testing.T) {
+	w, seen := serve(t, "")
+	assert.Equal(t, 16, len(seen))
+	assert.Equal(t, seen, w.Header().Get(logging.RequestIDHeader))
+}
+
+func TestMiddleware__replacesInvalidRequestID(t *testing.T) {
+	w, seen := serve(t, "bad id\nwith newline")
+	assert.Equal(t, 16, len(seen))
+	assert.Equal(t, seen, w.Header().Get(logging.RequestIDHeader))
+}
+
+func TestFromContext__addsRequestID(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var out bytes.Buffer
+	assert.NilError(t, logging.Setup(&out, "debug", "json"))
+
+	logging.FromContext(logging.WithRequestID(context.Background(), "abc-123")).Debug("Test")
+
+	var line map[string]any
+	assert.NilError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "Test", line["msg"])
+	assert.Equal(t, "abc-123", line["request_id"])
+}
+
+func TestSetup__rejectsInvalidSettings(t *testing.T) {
+	assert.ErrorContains(t, logging.Setup(&bytes.Buffer{}, "verbose", "text"), "invalid log level")
+	assert.ErrorContains(t, logging.Setup(&bytes.Buffer{}, "info", "xml"), "invalid log format")
+}
@@ -17,37 +17,312 @@ ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"fmt"
 
 	"github.com/goburrow/modbus"
+	"github.com/treblada/ecl310-rest/audit"
+	"github.com/treblada/ecl310-rest/auth"
+	"github.com/treblada/ecl310-rest/certs"
+	"github.com/treblada/ecl310-rest/etag"
 	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/history"
+	"github.com/treblada/ecl310-rest/logging"
+	"github.com/treblada/ecl310-rest/tracing"
 
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata"
 
 	wrapper "github.com/treblada/ecl310-rest/modbus"
 	api "github.com/treblada/ecl310-rest/services"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
+// Name the service reports its traces as
+const serviceName = "ecl310-rest"
+
+// Time pending requests get to complete on shutdown
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	log.Println("ECL310 API starting")
-	config := parseCmdLine()
-	log.Printf("Working with remote instance %s:%d\n", config.eclHost, config.eclPort)
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiff(os.Args[2:], os.Stdout))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx, parseCmdLine())
+	stop()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// run serves the API until the context is done, so the deferred clean-ups run before the process exits
+func run(ctx context.Context, config CmdLineArgs) error {
+	if err := logging.Setup(os.Stderr, config.logLevel, config.logFormat); err != nil {
+		return err
+	}
+	slog.Info("ECL310 API starting")
+	slog.Info("Working with remote instance", "host", config.eclHost, "port", config.eclPort)
+	if config.traceFile != "" && config.traceEndpoint != "" {
+		return errors.New("-trace-file and -trace-endpoint are mutually exclusive")
+	}
+	var traceExporter sdktrace.SpanExporter
+	if config.traceFile != "" {
+		exporter, err := tracing.NewFileExporter(config.traceFile)
+		if err != nil {
+			return err
+		}
+		traceExporter = exporter
+		slog.Info("Writing traces", "file", config.traceFile)
+	}
+	if config.traceEndpoint != "" {
+		exporter, err := tracing.NewHTTPExporter(ctx, config.traceEndpoint)
+		if err != nil {
+			return err
+		}
+		traceExporter = exporter
+		slog.Info("Sending traces", "endpoint", config.traceEndpoint)
+	}
+	if traceExporter != nil {
+		provider := tracing.Install(traceExporter, serviceName)
+		defer func() {
+			// the context is done by now, the pending spans get a time of their own
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := provider.Shutdown(shutdownCtx); err != nil {
+				slog.Warn("Error exporting pending spans", "error", err)
+			}
+		}()
+	}
 	// Modbus TCP
 	modbusClient := wrapper.NewModbusClientWrapper(modbus.TCPClient(fmt.Sprintf("%s:%d", config.eclHost, config.eclPort)))
-	log.Println("ECL client ready.")
+	modbusStats := wrapper.NewStatsClient(&modbusClient)
+	var breaker *wrapper.Breaker
+	var breakerClient wrapper.ZeroBasedAddressClientWrapper = modbusStats
+	if config.breakerThreshold > 0 {
+		breaker = wrapper.NewBreaker(modbusStats, config.breakerThreshold, config.breakerCooldown)
+		breakerClient = breaker
+	}
+	// concurrent requests share identical reads
+	client := wrapper.NewDedupClient(breakerClient)
+	publishMetrics(client, breaker, modbusStats)
+	slog.Info("ECL client ready.")
+
+	var auditLog audit.Log
+	if config.auditLog != "" {
+		fileLog, err := audit.NewFileLog(config.auditLog)
+		if err != nil {
+			return err
+		}
+		defer fileLog.Close()
+		auditLog = fileLog
+		slog.Info("Writing audit log", "file", config.auditLog)
+	}
+
+	var readPlanner *wrapper.ReadPlanner
+	if config.readMaxSize > wrapper.MaxReadQuantity || config.readMaxGap > wrapper.MaxReadQuantity {
+		return fmt.Errorf("-read-max-size and -read-max-gap must not exceed %d registers", wrapper.MaxReadQuantity)
+	}
+	if config.readMaxSize > 0 {
+		readPlanner = wrapper.NewReadPlanner(uint16(config.readMaxGap), uint16(config.readMaxSize))
+	}
 
-	HealthService := api.NewHealthApiService(&modbusClient)
+	timeZone := time.Local
+	if config.timeZone != "" {
+		location, err := time.LoadLocation(config.timeZone)
+		if err != nil {
+			return fmt.Errorf("invalid -time-zone: %w", err)
+		}
+		timeZone = location
+	}
+
+	var historyStore history.Store
+	historySeries := api.DefaultHistorySeries()
+	if config.historyDir != "" {
+		retention := history.Retention{MaxAge: config.historyRetention, CompactAfter: config.historyCompactAfter, CompactStep: config.historyCompactStep}
+		fileStore, err := history.NewFileStore(config.historyDir, retention)
+		if err != nil {
+			return err
+		}
+		defer fileStore.Close()
+		historyStore = fileStore
+		if config.historySeries != "" {
+			historySeries, err = loadHistorySeries(config.historySeries)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// background jobs report to the health details
+	pollers := api.NewPollers()
+	HealthService := api.NewHealthApiService(
+		client,
+		api.WithBreaker(breaker),
+		api.WithModbusStats(modbusStats),
+		api.WithReadPlanner(readPlanner),
+		api.WithTimeZone(timeZone),
+		api.WithPollers(pollers),
+	)
 	HealthServiceController := openapi.NewHealthApiControllerWithErrorHandler(HealthService, api.ApiErrorHandler)
 
-	SystemService := api.NewSystemApiService(&modbusClient)
+	SystemService := api.NewSystemApiService(
+		client,
+		api.WithAuditLog(auditLog),
+		api.WithReadPlanner(readPlanner),
+		api.WithRequireIfMatch(config.requireIfMatch),
+		api.WithTimeZone(timeZone),
+		api.WithClockSyncThreshold(config.clockSyncThreshold),
+		api.WithPollers(pollers),
+	)
 	SystemServiceController := openapi.NewSystemApiControllerWithErrorHandler(SystemService, api.ApiErrorHandler)
+	if config.clockSyncInterval > 0 {
+		api.StartClockSync(ctx, SystemService, config.clockSyncInterval)
+		slog.Info("Synchronising controller clock", "zone", timeZone, "interval", config.clockSyncInterval, "threshold", config.clockSyncThreshold)
+	}
 
-	HeatingService := api.NewHeatingApiService(&modbusClient)
+	HeatingService := api.NewHeatingApiService(
+		client,
+		api.WithAuditLog(auditLog),
+		api.WithReadPlanner(readPlanner),
+		api.WithRequireIfMatch(config.requireIfMatch),
+		api.WithHistoryStore(historyStore),
+	)
 	HeatingServiceController := openapi.NewHeatingApiControllerWithErrorHandler(HeatingService, api.ApiErrorHandler)
 
-	router := openapi.NewRouter(HealthServiceController, SystemServiceController, HeatingServiceController)
+	AuditService := api.NewAuditApiService(auditLog)
+	AuditServiceController := openapi.NewAuditApiControllerWithErrorHandler(AuditService, api.ApiErrorHandler)
+
+	BackupService := api.NewBackupApiService(SystemService, HeatingService, api.WithAuditLog(auditLog), api.WithReadPlanner(readPlanner))
+	BackupServiceController := openapi.NewBackupApiControllerWithErrorHandler(BackupService, api.ApiErrorHandler)
+
+	HistoryService := api.NewHistoryApiService(client, historyStore, historySeries, api.WithReadPlanner(readPlanner), api.WithPollers(pollers))
+	HistoryServiceController := openapi.NewHistoryApiControllerWithErrorHandler(HistoryService, api.ApiErrorHandler)
+	if historyStore != nil {
+		api.StartHistoryPoller(ctx, HistoryService, config.historyInterval)
+		slog.Info("Recording history", "dir", config.historyDir, "series", len(historySeries), "interval", config.historyInterval)
+	}
+
+	var handler http.Handler
+	var metricsHandler http.Handler
+	if config.authConfig != "" {
+		authConfig, err := auth.LoadConfig(config.authConfig)
+		if err != nil {
+			return err
+		}
+		authenticators, err := authConfig.Authenticators()
+		if err != nil {
+			return err
+		}
+		router := openapi.NewRouter(protectRouters(HealthServiceController, SystemServiceController, HeatingServiceController, AuditServiceController, BackupServiceController, HistoryServiceController)...)
+		router.Use(tracing.RouteMiddleware)
+		handler = auth.Middleware(audit.Middleware(router), authenticators...)
+		metricsHandler = auth.Middleware(auth.RequireRole(expvar.Handler(), auth.Viewer), authenticators...)
+		slog.Info("Authentication configured", "file", config.authConfig)
+	} else {
+		router := openapi.NewRouter(HealthServiceController, SystemServiceController, HeatingServiceController, AuditServiceController, BackupServiceController, HistoryServiceController)
+		router.Use(tracing.RouteMiddleware)
+		handler = audit.Middleware(router)
+		metricsHandler = expvar.Handler()
+		slog.Warn("Authentication disabled, everybody can write to the ECL310")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", metricsHandler)
+	mux.Handle("/", handler)
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.listenPort),
+		Handler: logging.Middleware(tracing.Middleware(etag.Middleware(mux))),
+	}
+
+	if config.tlsCert != "" || config.tlsKey != "" || config.tlsClientCa != "" {
+		if config.tlsCert == "" || config.tlsKey == "" {
+			return errors.New("both -tls-cert and -tls-key are required for HTTPS")
+		}
+		reloader, err := certs.NewReloader(config.tlsCert, config.tlsKey, config.tlsClientCa)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = reloader.TLSConfig()
+		if config.tlsClientCa != "" {
+			slog.Info("Requiring client certificates", "ca", config.tlsClientCa)
+		}
+		slog.Info("Listening to local port (HTTPS)", "port", config.listenPort)
+		return serveUntilDone(ctx, server, func() error { return server.ListenAndServeTLS("", "") })
+	}
+
+	slog.Info("Listening to local port", "port", config.listenPort)
+	return serveUntilDone(ctx, server, server.ListenAndServe)
+}
+
+// serveUntilDone shuts the server down gracefully once the context is done
+// protectRouters maps the roles onto the API's tags, the POSTs which only analyse their body are open to viewers
+func protectRouters(health, system, heating, auditLog, backup, history openapi.Router) []openapi.Router {
+	return []openapi.Router{
+		health,
+		auth.Protect(system, auth.Operator),
+		auth.Protect(heating, auth.Installer, "TuneHeatCurve"),
+		auth.Protect(auditLog, auth.Installer),
+		auth.Protect(backup, auth.Installer, "DiffBackup"),
+		auth.Protect(history, auth.Installer),
+	}
+}
+
+func serveUntilDone(ctx context.Context, server *http.Server, serve func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serve()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+func loadHistorySeries(path string) ([]api.HistorySeries, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	series, err := api.ReadHistorySeries(file)
+	if err != nil {
+		return nil, fmt.Errorf("history series %s: %w", path, err)
+	}
+	return series, nil
+}
 
-	log.Printf("Listening to local port %d\n", config.listenPort)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", config.listenPort), router))
+// publishMetrics exposes the modbus client's counters at /debug/vars
+func publishMetrics(client *wrapper.DedupClient, breaker *wrapper.Breaker, modbusStats *wrapper.StatsClient) {
+	expvar.Publish("modbus", expvar.Func(func() any {
+		stats := modbusStats.Stats()
+		metrics := map[string]any{
+			"savedReads":       client.SavedReads(),
+			"requests":         stats.Requests,
+			"errors":           stats.Errors,
+			"recentErrorRate":  stats.RecentErrorRate,
+			"averageLatencyMs": float64(stats.AverageLatency) / float64(time.Millisecond),
+		}
+		if breaker != nil {
+			status := breaker.Status()
+			metrics["breakerState"] = status.State.String()
+			metrics["breakerFailures"] = status.ConsecutiveFailures
+			metrics["breakerTrips"] = status.Trips
+		}
+		return metrics
+	}))
 }
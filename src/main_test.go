@@ -0,0 +1,59 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/treblada/ecl310-rest/auth"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/mocks"
+	api "github.com/treblada/ecl310-rest/services"
+	"gotest.tools/v3/assert"
+)
+
+type noRoutes struct{}
+
+func (noRoutes) Routes() openapi.Routes {
+	return openapi.Routes{}
+}
+
+func TestProtectRouters__tuningIsReadOnly(t *testing.T) {
+	// the controller is never reached by a rejected request, the accepted ones fail validation
+	heating := openapi.NewHeatingApiControllerWithErrorHandler(api.NewHeatingApiService(&mocks.ClientMock{}), api.ApiErrorHandler)
+	router := openapi.NewRouter(protectRouters(noRoutes{}, noRoutes{}, heating, noRoutes{}, noRoutes{}, noRoutes{})...)
+	handler := auth.Middleware(router, auth.NewApiKeyAuthenticator([]auth.ApiKey{
+		{Name: "dashboard", Key: "viewer-key", Role: auth.Viewer},
+		{Name: "installer", Key: "installer-key", Role: auth.Installer},
+	}))
+	post := func(path string, key string) int {
+		request := httptest.NewRequest(http.MethodPost, path, strings.NewReader("{}"))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(auth.ApiKeyHeader, key)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post("/heatcurve/1/tune", "viewer-key"))
+	assert.Equal(t, http.StatusBadRequest, post("/heatcurve/1/tune", "installer-key"))
+	assert.Equal(t, http.StatusForbidden, post("/heatcurve/1/slope", "viewer-key"))
+	assert.Equal(t, http.StatusUnauthorized, post("/heatcurve/1/tune", "wrong"))
+}
@@ -17,6 +17,8 @@ ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
 package mocks
 
 import (
+	"sync"
+
 	wrapper "github.com/treblada/ecl310-rest/modbus"
 )
 
@@ -32,9 +34,12 @@ type ClientMock struct {
 	ReadHoldingRegistersMock func(address, quantity uint16) (results []byte, err error)
 	WriteSingleRegisterMock  func(address, value uint16) (results []byte, err error)
 	Calls                    []Call
+	mu                       sync.Mutex
 }
 
 func (c *ClientMock) registerCall(funcName string, params ...Param) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Calls = append(c.Calls, Call{FuncName: funcName, Params: params})
 }
 
@@ -0,0 +1,159 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+var breakerStateNames = []string{"CLOSED", "OPEN", "HALF_OPEN"}
+
+func (s BreakerState) String() string {
+	return breakerStateNames[s]
+}
+
+// BreakerOpenError is returned without contacting the controller while the breaker is open
+type BreakerOpenError struct {
+	// the failure which opened the breaker
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("ECL310 unavailable, retry in %v: %v", e.RetryAfter.Round(time.Second), e.Err)
+}
+
+func (e *BreakerOpenError) Unwrap() error {
+	return e.Err
+}
+
+type BreakerStatus struct {
+	State               BreakerState
+	ConsecutiveFailures int
+	// number of times the breaker opened since the start
+	Trips     uint64
+	LastError error
+}
+
+/*
+The breaker fails fast while the controller is unreachable instead of letting every
+request wait for the TCP timeout. After threshold consecutive failures it opens and
+rejects all calls. Once the cooldown has passed a single call is let through as a probe
+(half-open): success closes the breaker, failure opens it for another cooldown.
+
+Modbus exceptions don't count as failures, the controller did respond.
+*/
+type Breaker struct {
+	interceptedClient
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	trips    uint64
+	lastErr  error
+	openedAt time.Time
+}
+
+func NewBreaker(c ZeroBasedAddressClientWrapper, threshold int, cooldown time.Duration) *Breaker {
+	b := &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+	b.interceptedClient = interceptedClient{client: c, intercept: b.call}
+	return b
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Trips:               b.trips,
+		LastError:           b.lastErr,
+	}
+}
+
+func (b *Breaker) before() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if elapsed := b.now().Sub(b.openedAt); elapsed < b.cooldown {
+			return &BreakerOpenError{Err: b.lastErr, RetryAfter: b.cooldown - elapsed}
+		}
+		slog.Info("Circuit breaker half-open, probing the ECL310")
+		b.state = BreakerHalfOpen
+		return nil
+	case BreakerHalfOpen:
+		// only the probe may pass
+		return &BreakerOpenError{Err: b.lastErr, RetryAfter: b.cooldown}
+	default:
+		return nil
+	}
+}
+
+func (b *Breaker) after(err error) {
+	var modbusErr *modbus.ModbusError
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil || errors.As(err, &modbusErr) {
+		if b.state != BreakerClosed {
+			slog.Info("Circuit breaker closed, ECL310 reachable again")
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		if b.state == BreakerClosed {
+			b.trips++
+		}
+		slog.Warn("Circuit breaker open", "cooldown", b.cooldown, "failures", b.failures, "error", err)
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+func (b *Breaker) call(request modbusRequest, f func() ([]byte, error)) ([]byte, error) {
+	if err := b.before(); err != nil {
+		return nil, err
+	}
+	results, err := f()
+	b.after(err)
+	return results, err
+}
@@ -0,0 +1,87 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/treblada/ecl310-rest/mocks"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
+	"gotest.tools/v3/assert"
+)
+
+func TestBreaker__opensAndProbes(t *testing.T) {
+	var failure error = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			if failure != nil {
+				return nil, failure
+			}
+			return []byte{0, 1}, nil
+		},
+	}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	breaker := wrapper.NewBreaker(mock, 3, 30*time.Second)
+	breaker.SetClock(func() time.Time { return now })
+
+	for i := 0; i < 3; i++ {
+		_, err := breaker.ReadHoldingRegisters(19, 1)
+		assert.Equal(t, failure, err)
+	}
+	assert.Equal(t, wrapper.BreakerOpen, breaker.Status().State)
+	assert.Equal(t, uint64(1), breaker.Status().Trips)
+
+	// fails fast without contacting the controller
+	now = now.Add(10 * time.Second)
+	_, err := breaker.ReadHoldingRegisters(19, 1)
+	var openErr *wrapper.BreakerOpenError
+	assert.Assert(t, errors.As(err, &openErr), "%T", err)
+	assert.Equal(t, 20*time.Second, openErr.RetryAfter)
+	assert.Equal(t, failure, openErr.Err)
+	assert.Equal(t, 3, len(mock.Calls))
+
+	// a failing probe opens it again
+	now = now.Add(20 * time.Second)
+	_, err = breaker.ReadHoldingRegisters(19, 1)
+	assert.Equal(t, failure, err)
+	assert.Equal(t, wrapper.BreakerOpen, breaker.Status().State)
+	assert.Equal(t, 4, len(mock.Calls))
+
+	// a successful probe closes it
+	failure = nil
+	now = now.Add(30 * time.Second)
+	_, err = breaker.ReadHoldingRegisters(19, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, wrapper.BreakerClosed, breaker.Status().State)
+	assert.Equal(t, 0, breaker.Status().ConsecutiveFailures)
+}
+
+func TestBreaker__ignoresModbusExceptions(t *testing.T) {
+	mock := &mocks.ClientMock{
+		WriteSingleRegisterMock: func(address, value uint16) ([]byte, error) {
+			return nil, &modbus.ModbusError{FunctionCode: 6, ExceptionCode: modbus.ExceptionCodeIllegalDataValue}
+		},
+	}
+	breaker := wrapper.NewBreaker(mock, 1, time.Minute)
+	_, err := breaker.WriteSingleRegister(11175, 200)
+	assert.ErrorContains(t, err, "illegal data value")
+	assert.Equal(t, wrapper.BreakerClosed, breaker.Status().State)
+}
@@ -0,0 +1,127 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ReadStats is implemented by clients counting the modbus transactions they saved
+type ReadStats interface {
+	SavedReads() uint64
+}
+
+type inFlightRead struct {
+	done   sync.WaitGroup
+	result []byte
+	err    error
+}
+
+/*
+The deduplicating client lets concurrent identical holding register reads share a single
+modbus transaction. A write drops the in-flight reads of all overlapping ranges when it
+starts and again when it finishes, so a read issued after a write never gets the result
+of a read issued before it.
+*/
+type DedupClient struct {
+	ZeroBasedAddressClientWrapper
+	mu       sync.Mutex
+	inFlight map[ReadRange]*inFlightRead
+	saved    uint64
+}
+
+func NewDedupClient(c ZeroBasedAddressClientWrapper) *DedupClient {
+	return &DedupClient{
+		ZeroBasedAddressClientWrapper: c,
+		inFlight:                      map[ReadRange]*inFlightRead{},
+	}
+}
+
+// SavedReads returns the number of reads served by another caller's transaction
+func (d *DedupClient) SavedReads() uint64 {
+	return atomic.LoadUint64(&d.saved)
+}
+
+func (d *DedupClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	key := ReadRange{Address: address, Quantity: quantity}
+	d.mu.Lock()
+	if call, ok := d.inFlight[key]; ok {
+		d.mu.Unlock()
+		atomic.AddUint64(&d.saved, 1)
+		call.done.Wait()
+		return call.copyResult(), call.err
+	}
+	call := &inFlightRead{}
+	call.done.Add(1)
+	d.inFlight[key] = call
+	d.mu.Unlock()
+
+	call.result, call.err = d.ZeroBasedAddressClientWrapper.ReadHoldingRegisters(address, quantity)
+
+	d.mu.Lock()
+	if d.inFlight[key] == call {
+		delete(d.inFlight, key)
+	}
+	d.mu.Unlock()
+	call.done.Done()
+	return call.copyResult(), call.err
+}
+
+// copyResult gives every caller, the one issuing the read too, a result of its own to change
+func (c *inFlightRead) copyResult() []byte {
+	if c.result == nil {
+		return nil
+	}
+	return append([]byte{}, c.result...)
+}
+
+func (d *DedupClient) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	d.forget(address, 1)
+	defer d.forget(address, 1)
+	return d.ZeroBasedAddressClientWrapper.WriteSingleRegister(address, value)
+}
+
+func (d *DedupClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	d.forget(address, quantity)
+	defer d.forget(address, quantity)
+	return d.ZeroBasedAddressClientWrapper.WriteMultipleRegisters(address, quantity, value)
+}
+
+func (d *DedupClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	d.forget(writeAddress, writeQuantity)
+	defer d.forget(writeAddress, writeQuantity)
+	return d.ZeroBasedAddressClientWrapper.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+}
+
+func (d *DedupClient) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	d.forget(address, 1)
+	defer d.forget(address, 1)
+	return d.ZeroBasedAddressClientWrapper.MaskWriteRegister(address, andMask, orMask)
+}
+
+// forget drops the in-flight reads overlapping the range, later reads start a transaction of their own
+func (d *DedupClient) forget(address, quantity uint16) {
+	written := ReadRange{Address: address, Quantity: quantity}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key := range d.inFlight {
+		if int(key.Address) < written.end() && int(written.Address) < key.end() {
+			delete(d.inFlight, key)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper_test
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/treblada/ecl310-rest/mocks"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
+	"gotest.tools/v3/assert"
+)
+
+// blockingMock holds reads until release is closed, started is signalled for every read
+func blockingMock(started chan<- uint16, release <-chan struct{}) *mocks.ClientMock {
+	var mu sync.Mutex
+	reads := uint16(0)
+	return &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			mu.Lock()
+			reads++
+			value := reads
+			mu.Unlock()
+			started <- address
+			<-release
+			return []byte{0, byte(value)}, nil
+		},
+		WriteSingleRegisterMock: func(address, value uint16) ([]byte, error) {
+			return []byte{}, nil
+		},
+	}
+}
+
+func TestDedupClient__sharesConcurrentReads(t *testing.T) {
+	started := make(chan uint16, 10)
+	release := make(chan struct{})
+	client := wrapper.NewDedupClient(blockingMock(started, release))
+
+	results := make(chan []byte, 5)
+	read := func() {
+		result, _ := client.ReadHoldingRegisters(4201, 1)
+		// the callers may change their results
+		result[1]++
+		results <- result
+	}
+	go read()
+	<-started
+	for i := 0; i < 4; i++ {
+		go read()
+	}
+	for client.SavedReads() < 4 {
+		// wait for all followers to join the in-flight read
+		runtime.Gosched()
+	}
+	close(release)
+	for i := 0; i < 5; i++ {
+		assert.DeepEqual(t, []byte{0, 2}, <-results)
+	}
+	assert.Equal(t, uint64(4), client.SavedReads())
+}
+
+func TestDedupClient__noSharingAcrossWrite(t *testing.T) {
+	started := make(chan uint16, 10)
+	release := make(chan struct{})
+	client := wrapper.NewDedupClient(blockingMock(started, release))
+
+	results := make(chan []byte, 2)
+	go func() {
+		result, _ := client.ReadHoldingRegisters(4201, 2)
+		results <- result
+	}()
+	<-started
+	_, err := client.WriteSingleRegister(4202, 1)
+	assert.NilError(t, err)
+	go func() {
+		result, _ := client.ReadHoldingRegisters(4201, 2)
+		results <- result
+	}()
+	// the second read must start a transaction of its own
+	<-started
+	close(release)
+	first, second := <-results, <-results
+	assert.Assert(t, first[1] != second[1])
+	assert.Equal(t, uint64(0), client.SavedReads())
+}
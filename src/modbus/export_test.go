@@ -0,0 +1,29 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper
+
+import "time"
+
+// SetClock replaces the breaker's clock in tests
+func (b *Breaker) SetClock(now func() time.Time) {
+	b.now = now
+}
+
+// SetClock replaces the stats client's clock in tests
+func (s *StatsClient) SetClock(now func() time.Time) {
+	s.now = now
+}
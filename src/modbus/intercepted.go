@@ -0,0 +1,99 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper
+
+/*
+The intercepted client passes every call to the wrapped client through the intercept
+function. Decorators embed it to act on all calls alike.
+*/
+type interceptedClient struct {
+	client    ZeroBasedAddressClientWrapper
+	intercept func(request modbusRequest, call func() ([]byte, error)) ([]byte, error)
+}
+
+// modbusRequest describes an intercepted call, quantity is 1 for single register or coil functions
+type modbusRequest struct {
+	function string
+	address  uint16
+	quantity uint16
+}
+
+func (c *interceptedClient) ReadCoils(address, quantity uint16) (results []byte, err error) {
+	return c.intercept(modbusRequest{"ReadCoils", address, quantity}, func() ([]byte, error) {
+		return c.client.ReadCoils(address, quantity)
+	})
+}
+
+func (c *interceptedClient) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
+	return c.intercept(modbusRequest{"ReadDiscreteInputs", address, quantity}, func() ([]byte, error) {
+		return c.client.ReadDiscreteInputs(address, quantity)
+	})
+}
+
+func (c *interceptedClient) WriteSingleCoil(address, value uint16) (results []byte, err error) {
+	return c.intercept(modbusRequest{"WriteSingleCoil", address, 1}, func() ([]byte, error) {
+		return c.client.WriteSingleCoil(address, value)
+	})
+}
+
+func (c *interceptedClient) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	return c.intercept(modbusRequest{"WriteMultipleCoils", address, quantity}, func() ([]byte, error) {
+		return c.client.WriteMultipleCoils(address, quantity, value)
+	})
+}
+
+func (c *interceptedClient) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	return c.intercept(modbusRequest{"ReadInputRegisters", address, quantity}, func() ([]byte, error) {
+		return c.client.ReadInputRegisters(address, quantity)
+	})
+}
+
+func (c *interceptedClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	return c.intercept(modbusRequest{"ReadHoldingRegisters", address, quantity}, func() ([]byte, error) {
+		return c.client.ReadHoldingRegisters(address, quantity)
+	})
+}
+
+func (c *interceptedClient) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	return c.intercept(modbusRequest{"WriteSingleRegister", address, 1}, func() ([]byte, error) {
+		return c.client.WriteSingleRegister(address, value)
+	})
+}
+
+func (c *interceptedClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	return c.intercept(modbusRequest{"WriteMultipleRegisters", address, quantity}, func() ([]byte, error) {
+		return c.client.WriteMultipleRegisters(address, quantity, value)
+	})
+}
+
+func (c *interceptedClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	return c.intercept(modbusRequest{"ReadWriteMultipleRegisters", writeAddress, writeQuantity}, func() ([]byte, error) {
+		return c.client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+	})
+}
+
+func (c *interceptedClient) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	return c.intercept(modbusRequest{"MaskWriteRegister", address, 1}, func() ([]byte, error) {
+		return c.client.MaskWriteRegister(address, andMask, orMask)
+	})
+}
+
+func (c *interceptedClient) ReadFIFOQueue(address uint16) (results []byte, err error) {
+	return c.intercept(modbusRequest{"ReadFIFOQueue", address, 1}, func() ([]byte, error) {
+		return c.client.ReadFIFOQueue(address)
+	})
+}
@@ -0,0 +1,55 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper
+
+import (
+	"log/slog"
+	"time"
+)
+
+type loggingClient struct {
+	interceptedClient
+	logger *slog.Logger
+}
+
+/*
+NewLoggingClient logs every call with the given logger, which usually carries the ID
+of the HTTP request the call is made for. Successful calls are logged at debug level,
+failures as warnings.
+*/
+func NewLoggingClient(c ZeroBasedAddressClientWrapper, logger *slog.Logger) ZeroBasedAddressClientWrapper {
+	l := &loggingClient{logger: logger}
+	l.interceptedClient = interceptedClient{client: c, intercept: l.log}
+	return l
+}
+
+func (l *loggingClient) log(request modbusRequest, call func() ([]byte, error)) ([]byte, error) {
+	start := time.Now()
+	results, err := call()
+	attrs := []any{
+		"function", request.function,
+		"pnu", request.address,
+		"quantity", request.quantity,
+		"duration", time.Since(start),
+	}
+	if err != nil {
+		l.logger.Warn("Modbus request failed", append(attrs, "error", err)...)
+	} else {
+		l.logger.Debug("Modbus request", attrs...)
+	}
+	return results, err
+}
@@ -0,0 +1,64 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/treblada/ecl310-rest/mocks"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
+	"gotest.tools/v3/assert"
+)
+
+func TestLoggingClient__logsRequests(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			return []byte{0, 1}, nil
+		},
+		WriteSingleRegisterMock: func(address, value uint16) ([]byte, error) {
+			return nil, errors.New("Mocked error")
+		},
+	}
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})).With("request_id", "abc-123")
+	client := wrapper.NewLoggingClient(mock, logger)
+
+	_, err := client.ReadHoldingRegisters(19, 1)
+	assert.NilError(t, err)
+	_, err = client.WriteSingleRegister(11175, 12)
+	assert.ErrorContains(t, err, "Mocked error")
+
+	decoder := json.NewDecoder(&out)
+	var read, write map[string]any
+	assert.NilError(t, decoder.Decode(&read))
+	assert.NilError(t, decoder.Decode(&write))
+
+	assert.Equal(t, "DEBUG", read["level"])
+	assert.Equal(t, "ReadHoldingRegisters", read["function"])
+	assert.Equal(t, float64(19), read["pnu"])
+	assert.Equal(t, "abc-123", read["request_id"])
+
+	assert.Equal(t, "WARN", write["level"])
+	assert.Equal(t, "WriteSingleRegister", write["function"])
+	assert.Equal(t, float64(11175), write["pnu"])
+	assert.Equal(t, "Mocked error", write["error"])
+	assert.Equal(t, "abc-123", write["request_id"])
+}
@@ -0,0 +1,232 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/goburrow/modbus"
+)
+
+// Maximum number of holding registers a single modbus request may read
+const MaxReadQuantity = 125
+
+// Maximum number of rejected blocks remembered, the planner forgets them all once exceeded
+const maxRejectedBlocks = 256
+
+// A read range covers quantity registers starting at address
+type ReadRange struct {
+	Address  uint16
+	Quantity uint16
+}
+
+func (r ReadRange) end() int {
+	return int(r.Address) + int(r.Quantity)
+}
+
+func (r ReadRange) String() string {
+	return fmt.Sprintf("PNU%d:%d", r.Address, r.Quantity)
+}
+
+// ReadError reports the range which could not be read
+type ReadError struct {
+	ReadRange
+	Err error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("reading %v: %v", e.ReadRange, e.Err)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+/*
+The read planner merges the ranges a handler needs into as few requests as possible.
+Ranges at most maxGap registers apart are read in one block of up to maxBlockSize
+registers. If the controller rejects a block, e.g. because the gap contains a PNU it
+does not know, the block is split in halves until the single ranges are read on their
+own. Blocks rejected for an illegal address or value are remembered and not requested
+again. Other exceptions, e.g. a busy controller, are transient: the block is split once
+and remembered no longer than the request.
+
+A nil planner reads every range with a request of its own.
+*/
+type ReadPlanner struct {
+	maxGap       uint16
+	maxBlockSize uint16
+	mu           sync.Mutex
+	rejected     map[ReadRange]bool
+}
+
+func NewReadPlanner(maxGap uint16, maxBlockSize uint16) *ReadPlanner {
+	if maxBlockSize > MaxReadQuantity {
+		maxBlockSize = MaxReadQuantity
+	}
+	return &ReadPlanner{
+		maxGap:       maxGap,
+		maxBlockSize: maxBlockSize,
+		rejected:     map[ReadRange]bool{},
+	}
+}
+
+type readBlock struct {
+	ReadRange
+	// indices of the requested ranges covered by the block
+	members []int
+}
+
+// Read returns the registers of the ranges in the order they were given
+func (p *ReadPlanner) Read(c ZeroBasedAddressClientWrapper, ranges ...ReadRange) ([][]byte, error) {
+	results := make([][]byte, len(ranges))
+	if p == nil {
+		for i, r := range ranges {
+			result, err := c.ReadHoldingRegisters(r.Address, r.Quantity)
+			if err != nil {
+				return nil, &ReadError{ReadRange: r, Err: err}
+			}
+			results[i] = result
+		}
+		return results, nil
+	}
+	for _, block := range p.plan(ranges) {
+		if err := p.readBlock(c, ranges, block, results, true); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (p *ReadPlanner) plan(ranges []ReadRange) []readBlock {
+	order := make([]int, len(ranges))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return ranges[order[a]].Address < ranges[order[b]].Address
+	})
+
+	blocks := []readBlock{}
+	for _, i := range order {
+		r := ranges[i]
+		if len(blocks) > 0 {
+			last := &blocks[len(blocks)-1]
+			end := last.end()
+			if r.end() > end {
+				end = r.end()
+			}
+			if int(r.Address) <= last.end()+int(p.maxGap) && end-int(last.Address) <= int(p.maxBlockSize) {
+				last.Quantity = uint16(end - int(last.Address))
+				last.members = append(last.members, i)
+				continue
+			}
+		}
+		blocks = append(blocks, readBlock{ReadRange: r, members: []int{i}})
+	}
+	return blocks
+}
+
+// readBlock reads the block, splitting it on exceptions; transient exceptions only split it if mayRetry
+func (p *ReadPlanner) readBlock(c ZeroBasedAddressClientWrapper, ranges []ReadRange, block readBlock, results [][]byte, mayRetry bool) error {
+	if len(block.members) > 1 && p.isRejected(block.ReadRange) {
+		return p.split(c, ranges, block, results, mayRetry)
+	}
+	result, err := c.ReadHoldingRegisters(block.Address, block.Quantity)
+	if err != nil {
+		var modbusErr *modbus.ModbusError
+		if len(block.members) > 1 && errors.As(err, &modbusErr) {
+			if isRejection(modbusErr) {
+				p.reject(block.ReadRange)
+				return p.split(c, ranges, block, results, mayRetry)
+			}
+			if mayRetry {
+				return p.split(c, ranges, block, results, false)
+			}
+		}
+		return &ReadError{ReadRange: block.ReadRange, Err: err}
+	}
+	if len(result) < int(block.Quantity)*2 {
+		return &ReadError{
+			ReadRange: block.ReadRange,
+			Err:       fmt.Errorf("short response, got %d of %d registers", len(result)/2, block.Quantity),
+		}
+	}
+	for _, i := range block.members {
+		offset := (int(ranges[i].Address) - int(block.Address)) * 2
+		results[i] = result[offset : offset+int(ranges[i].Quantity)*2]
+	}
+	return nil
+}
+
+// split reads each half of the block's ranges on its own
+func (p *ReadPlanner) split(c ZeroBasedAddressClientWrapper, ranges []ReadRange, block readBlock, results [][]byte, mayRetry bool) error {
+	half := len(block.members) / 2
+	for _, members := range [][]int{block.members[:half], block.members[half:]} {
+		if err := p.readBlock(c, ranges, newReadBlock(ranges, members), results, mayRetry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newReadBlock(ranges []ReadRange, members []int) readBlock {
+	start := int(ranges[members[0]].Address)
+	end := start
+	for _, i := range members {
+		if int(ranges[i].Address) < start {
+			start = int(ranges[i].Address)
+		}
+		if ranges[i].end() > end {
+			end = ranges[i].end()
+		}
+	}
+	return readBlock{ReadRange: ReadRange{Address: uint16(start), Quantity: uint16(end - start)}, members: members}
+}
+
+// isRejection tells if the controller refuses the block for good rather than for the moment
+func isRejection(err *modbus.ModbusError) bool {
+	return err.ExceptionCode == modbus.ExceptionCodeIllegalDataAddress || err.ExceptionCode == modbus.ExceptionCodeIllegalDataValue
+}
+
+// RejectedBlocks is the number of blocks remembered as rejected, 0 for a nil planner
+func (p *ReadPlanner) RejectedBlocks() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.rejected)
+}
+
+func (p *ReadPlanner) isRejected(r ReadRange) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rejected[r]
+}
+
+func (p *ReadPlanner) reject(r ReadRange) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.rejected) >= maxRejectedBlocks {
+		p.rejected = map[ReadRange]bool{}
+	}
+	p.rejected[r] = true
+}
@@ -0,0 +1,146 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/goburrow/modbus"
+	"github.com/treblada/ecl310-rest/mocks"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
+	"gotest.tools/v3/assert"
+)
+
+// registers returns the register numbers as values, failing for addresses in unknown
+func registers(unknown map[uint16]bool) func(address, quantity uint16) ([]byte, error) {
+	return func(address, quantity uint16) ([]byte, error) {
+		result := []byte{}
+		for pnu := address; pnu < address+quantity; pnu++ {
+			if unknown[pnu] {
+				return nil, &modbus.ModbusError{FunctionCode: 3, ExceptionCode: modbus.ExceptionCodeIllegalDataAddress}
+			}
+			result = append(result, byte(pnu>>8), byte(pnu))
+		}
+		return result, nil
+	}
+}
+
+func readCall(address, quantity uint16) mocks.Call {
+	return mocks.Call{FuncName: "ReadHoldingRegisters", Params: []mocks.Param{address, quantity}}
+}
+
+func TestReadPlanner__mergesNearbyRanges(t *testing.T) {
+	mock := &mocks.ClientMock{ReadHoldingRegistersMock: registers(nil)}
+	planner := wrapper.NewReadPlanner(16, 64)
+	results, err := planner.Read(mock,
+		wrapper.ReadRange{Address: 258, Quantity: 1},
+		wrapper.ReadRange{Address: 19, Quantity: 1},
+		wrapper.ReadRange{Address: 34, Quantity: 4},
+		wrapper.ReadRange{Address: 278, Quantity: 12},
+	)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, mock.Calls, []mocks.Call{readCall(19, 19), readCall(258, 1), readCall(278, 12)})
+	assert.DeepEqual(t, results[0], []byte{1, 2})
+	assert.DeepEqual(t, results[1], []byte{0, 19})
+	assert.DeepEqual(t, results[2], []byte{0, 34, 0, 35, 0, 36, 0, 37})
+	assert.Equal(t, 24, len(results[3]))
+}
+
+func TestReadPlanner__respectsMaxBlockSize(t *testing.T) {
+	mock := &mocks.ClientMock{ReadHoldingRegistersMock: registers(nil)}
+	planner := wrapper.NewReadPlanner(16, 8)
+	_, err := planner.Read(mock,
+		wrapper.ReadRange{Address: 10, Quantity: 4},
+		wrapper.ReadRange{Address: 14, Quantity: 4},
+		wrapper.ReadRange{Address: 18, Quantity: 2},
+	)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, mock.Calls, []mocks.Call{readCall(10, 8), readCall(18, 2)})
+}
+
+func TestReadPlanner__splitsRejectedBlock(t *testing.T) {
+	mock := &mocks.ClientMock{ReadHoldingRegistersMock: registers(map[uint16]bool{12: true})}
+	planner := wrapper.NewReadPlanner(4, 64)
+	ranges := []wrapper.ReadRange{{Address: 10, Quantity: 2}, {Address: 13, Quantity: 1}}
+	results, err := planner.Read(mock, ranges...)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, results, [][]byte{{0, 10, 0, 11}, {0, 13}})
+	assert.DeepEqual(t, mock.Calls, []mocks.Call{readCall(10, 4), readCall(10, 2), readCall(13, 1)})
+
+	// the rejected block is not requested again
+	mock.Calls = nil
+	_, err = planner.Read(mock, ranges...)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, mock.Calls, []mocks.Call{readCall(10, 2), readCall(13, 1)})
+}
+
+func TestReadPlanner__doesNotRememberBusyBlock(t *testing.T) {
+	busy := true
+	mock := &mocks.ClientMock{ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+		if busy && quantity > 2 {
+			return nil, &modbus.ModbusError{FunctionCode: 3, ExceptionCode: modbus.ExceptionCodeServerDeviceBusy}
+		}
+		return registers(nil)(address, quantity)
+	}}
+	planner := wrapper.NewReadPlanner(4, 64)
+	ranges := []wrapper.ReadRange{{Address: 10, Quantity: 2}, {Address: 13, Quantity: 1}}
+	results, err := planner.Read(mock, ranges...)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, results, [][]byte{{0, 10, 0, 11}, {0, 13}})
+	assert.DeepEqual(t, mock.Calls, []mocks.Call{readCall(10, 4), readCall(10, 2), readCall(13, 1)})
+
+	// the block is merged again once the controller isn't busy any more
+	busy = false
+	mock.Calls = nil
+	_, err = planner.Read(mock, ranges...)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, mock.Calls, []mocks.Call{readCall(10, 4)})
+}
+
+func TestReadPlanner__splitsBusyBlockOnce(t *testing.T) {
+	mock := &mocks.ClientMock{ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+		return nil, &modbus.ModbusError{FunctionCode: 3, ExceptionCode: modbus.ExceptionCodeServerDeviceBusy}
+	}}
+	planner := wrapper.NewReadPlanner(4, 64)
+	_, err := planner.Read(mock,
+		wrapper.ReadRange{Address: 10, Quantity: 1},
+		wrapper.ReadRange{Address: 12, Quantity: 1},
+		wrapper.ReadRange{Address: 14, Quantity: 1},
+		wrapper.ReadRange{Address: 16, Quantity: 1},
+	)
+	var modbusErr *modbus.ModbusError
+	assert.Assert(t, errors.As(err, &modbusErr), "%T", err)
+	assert.DeepEqual(t, mock.Calls, []mocks.Call{readCall(10, 7), readCall(10, 3)})
+}
+
+func TestReadPlanner__reportsFailedRange(t *testing.T) {
+	mock := &mocks.ClientMock{ReadHoldingRegistersMock: registers(map[uint16]bool{13: true})}
+	planner := wrapper.NewReadPlanner(4, 64)
+	_, err := planner.Read(mock, wrapper.ReadRange{Address: 10, Quantity: 2}, wrapper.ReadRange{Address: 13, Quantity: 1})
+	var readErr *wrapper.ReadError
+	assert.Assert(t, errors.As(err, &readErr), "%T", err)
+	assert.Equal(t, wrapper.ReadRange{Address: 13, Quantity: 1}, readErr.ReadRange)
+}
+
+func TestReadPlanner__nilReadsEachRange(t *testing.T) {
+	mock := &mocks.ClientMock{ReadHoldingRegistersMock: registers(nil)}
+	var planner *wrapper.ReadPlanner
+	_, err := planner.Read(mock, wrapper.ReadRange{Address: 11, Quantity: 1}, wrapper.ReadRange{Address: 10, Quantity: 1})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, mock.Calls, []mocks.Call{readCall(11, 1), readCall(10, 1)})
+}
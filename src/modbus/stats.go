@@ -0,0 +1,120 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of most recent requests the error rate and the average latency are computed of
+const statsWindow = 100
+
+type ClientStatsSnapshot struct {
+	Requests uint64
+	Errors   uint64
+	// share of failed requests among the most recent ones
+	RecentErrorRate float64
+	LastLatency     time.Duration
+	AverageLatency  time.Duration
+	// zero if there was no successful request yet
+	LastSuccess   time.Time
+	LastError     error
+	LastErrorTime time.Time
+}
+
+type requestOutcome struct {
+	latency time.Duration
+	failed  bool
+}
+
+// The stats client measures the round-trips of all requests to the controller
+type StatsClient struct {
+	interceptedClient
+	now func() time.Time
+
+	mu            sync.Mutex
+	requests      uint64
+	errors        uint64
+	recent        []requestOutcome
+	next          int
+	lastLatency   time.Duration
+	lastSuccess   time.Time
+	lastError     error
+	lastErrorTime time.Time
+}
+
+func NewStatsClient(c ZeroBasedAddressClientWrapper) *StatsClient {
+	s := &StatsClient{
+		now:    time.Now,
+		recent: make([]requestOutcome, 0, statsWindow),
+	}
+	s.interceptedClient = interceptedClient{client: c, intercept: s.measure}
+	return s
+}
+
+func (s *StatsClient) measure(request modbusRequest, call func() ([]byte, error)) ([]byte, error) {
+	start := s.now()
+	results, err := call()
+	end := s.now()
+	outcome := requestOutcome{latency: end.Sub(start), failed: err != nil}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.lastLatency = outcome.latency
+	if err != nil {
+		s.errors++
+		s.lastError = err
+		s.lastErrorTime = end
+	} else {
+		s.lastSuccess = end
+	}
+	if len(s.recent) < statsWindow {
+		s.recent = append(s.recent, outcome)
+	} else {
+		s.recent[s.next] = outcome
+	}
+	s.next = (s.next + 1) % statsWindow
+	return results, err
+}
+
+func (s *StatsClient) Stats() ClientStatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := ClientStatsSnapshot{
+		Requests:      s.requests,
+		Errors:        s.errors,
+		LastLatency:   s.lastLatency,
+		LastSuccess:   s.lastSuccess,
+		LastError:     s.lastError,
+		LastErrorTime: s.lastErrorTime,
+	}
+	if len(s.recent) > 0 {
+		var failed int
+		var latency time.Duration
+		for _, outcome := range s.recent {
+			if outcome.failed {
+				failed++
+			}
+			latency += outcome.latency
+		}
+		snapshot.RecentErrorRate = float64(failed) / float64(len(s.recent))
+		snapshot.AverageLatency = latency / time.Duration(len(s.recent))
+	}
+	return snapshot
+}
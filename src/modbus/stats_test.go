@@ -0,0 +1,64 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/treblada/ecl310-rest/mocks"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
+	"gotest.tools/v3/assert"
+)
+
+func TestStatsClient__measuresRequests(t *testing.T) {
+	fail := false
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			if fail {
+				return nil, errors.New("Mocked error")
+			}
+			return []byte{0, 1}, nil
+		},
+	}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	client := wrapper.NewStatsClient(mock)
+	// every request takes 10ms
+	client.SetClock(func() time.Time {
+		now = now.Add(10 * time.Millisecond)
+		return now
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := client.ReadHoldingRegisters(19, 1)
+		assert.NilError(t, err)
+	}
+	lastSuccess := now
+	fail = true
+	_, err := client.ReadHoldingRegisters(19, 1)
+	assert.ErrorContains(t, err, "Mocked error")
+
+	stats := client.Stats()
+	assert.Equal(t, uint64(4), stats.Requests)
+	assert.Equal(t, uint64(1), stats.Errors)
+	assert.Equal(t, 0.25, stats.RecentErrorRate)
+	assert.Equal(t, 10*time.Millisecond, stats.LastLatency)
+	assert.Equal(t, 10*time.Millisecond, stats.AverageLatency)
+	assert.Equal(t, lastSuccess, stats.LastSuccess)
+	assert.Equal(t, now, stats.LastErrorTime)
+}
@@ -0,0 +1,59 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper
+
+import (
+	"context"
+
+	"github.com/treblada/ecl310-rest/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracingClient struct {
+	interceptedClient
+	ctx context.Context
+}
+
+// NewTracingClient records every call as a child span of the current span of ctx
+func NewTracingClient(c ZeroBasedAddressClientWrapper, ctx context.Context) ZeroBasedAddressClientWrapper {
+	t := &tracingClient{ctx: ctx}
+	t.interceptedClient = interceptedClient{client: c, intercept: t.trace}
+	return t
+}
+
+func (t *tracingClient) trace(request modbusRequest, call func() ([]byte, error)) ([]byte, error) {
+	_, span := tracing.Tracer().Start(t.ctx, "modbus "+request.function,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("modbus.function", request.function),
+			attribute.Int("modbus.pnu", int(request.address)),
+			attribute.Int("modbus.quantity", int(request.quantity)),
+		),
+	)
+	defer span.End()
+	results, err := call()
+	if err != nil {
+		span.SetAttributes(attribute.String("modbus.outcome", "error"))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.String("modbus.outcome", "ok"), attribute.Int("modbus.bytes", len(results)))
+	}
+	return results, err
+}
@@ -0,0 +1,93 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package wrapper_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/treblada/ecl310-rest/mocks"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
+	"github.com/treblada/ecl310-rest/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"gotest.tools/v3/assert"
+)
+
+func attributes(keyValues []attribute.KeyValue) map[attribute.Key]any {
+	values := map[attribute.Key]any{}
+	for _, keyValue := range keyValues {
+		values[keyValue.Key] = keyValue.Value.AsInterface()
+	}
+	return values
+}
+
+func TestTracingClient__tracesRequests(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.Install(exporter, "ecl310-rest")
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			return []byte{0, 1, 0, 2}, nil
+		},
+		WriteSingleRegisterMock: func(address, value uint16) ([]byte, error) {
+			return nil, errors.New("Mocked error")
+		},
+	}
+	ctx, parent := tracing.Tracer().Start(context.Background(), "POST /system/datetime", trace.WithSpanKind(trace.SpanKindServer))
+	client := wrapper.NewTracingClient(mock, ctx)
+
+	_, err := client.ReadHoldingRegisters(64045, 2)
+	assert.NilError(t, err)
+	_, err = client.WriteSingleRegister(64047, 12)
+	assert.ErrorContains(t, err, "Mocked error")
+	parent.End()
+	assert.NilError(t, provider.ForceFlush(context.Background()))
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 3, len(spans))
+	read, write, server := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "modbus ReadHoldingRegisters", read.Name)
+	assert.Equal(t, trace.SpanKindClient, read.SpanKind)
+	assert.Equal(t, server.SpanContext.SpanID(), read.Parent.SpanID())
+	assert.DeepEqual(t, map[attribute.Key]any{
+		"modbus.function": "ReadHoldingRegisters",
+		"modbus.pnu":      int64(64045),
+		"modbus.quantity": int64(2),
+		"modbus.outcome":  "ok",
+		"modbus.bytes":    int64(4),
+	}, attributes(read.Attributes))
+	assert.Equal(t, codes.Unset, read.Status.Code)
+
+	assert.Equal(t, "modbus WriteSingleRegister", write.Name)
+	assert.Equal(t, server.SpanContext.SpanID(), write.Parent.SpanID())
+	assert.DeepEqual(t, map[attribute.Key]any{
+		"modbus.function": "WriteSingleRegister",
+		"modbus.pnu":      int64(64047),
+		"modbus.quantity": int64(1),
+		"modbus.outcome":  "error",
+	}, attributes(write.Attributes))
+	assert.Equal(t, codes.Error, write.Status.Code)
+	assert.Equal(t, "Mocked error", write.Status.Description)
+}
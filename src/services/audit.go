@@ -0,0 +1,98 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/treblada/ecl310-rest/audit"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+)
+
+type AuditApiService struct {
+	openapi.AuditApiService
+	auditLog audit.Log
+}
+
+func NewAuditApiService(auditLog audit.Log) openapi.AuditApiServicer {
+	return &AuditApiService{
+		auditLog: auditLog,
+	}
+}
+
+func (s *AuditApiService) GetAuditLog(ctx context.Context, from string, to string, circuitNo int32, pnu int32) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	if s.auditLog == nil {
+		panic(NewApiError(http.StatusNotFound, ErrFeatureDisabled, "Audit log is disabled", nil))
+	}
+
+	if circuitNo != 0 {
+		assertValidCircuit(circuitNo)
+	}
+	if pnu < 0 || pnu > 65535 {
+		panic(NewValidationError(ErrValueOutOfRange, "pnu", fmt.Sprintf("Invalid PNU %d, not in [1,65535]", pnu)))
+	}
+
+	filter := audit.Filter{
+		From:    parseTimestamp(from, "from"),
+		To:      parseTimestamp(to, "to"),
+		Circuit: circuitNo,
+		Pnu:     uint16(pnu),
+	}
+
+	entries, err := s.auditLog.Query(filter)
+	if err != nil {
+		panic(NewApiError(http.StatusInternalServerError, ErrInternal, "Error reading audit log", err))
+	}
+
+	body := make([]openapi.AuditEntry, len(entries))
+	for i, entry := range entries {
+		body[i] = openapi.AuditEntry{
+			Time:      entry.Time.Format(time.RFC3339),
+			Principal: entry.Principal,
+			Endpoint:  entry.Endpoint,
+			CircuitNo: entry.Circuit,
+			Pnu:       int32(entry.Pnu),
+			Label:     entry.Label,
+			OldValue:  int32(entry.OldValue),
+			NewValue:  int32(entry.NewValue),
+			Result:    string(entry.Result),
+			Error:     entry.Error,
+		}
+	}
+	return openapi.Response(http.StatusOK, body), nil
+}
+
+// parseTimestamp returns the zero time for an empty value
+func parseTimestamp(value string, id string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(NewValidationError(ErrInvalidFormat, id, fmt.Sprintf("Invalid timestamp %q for %s, expected RFC 3339", value, id)))
+	}
+	return timestamp
+}
@@ -0,0 +1,112 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treblada/ecl310-rest/audit"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/mocks"
+	api "github.com/treblada/ecl310-rest/services"
+	"gotest.tools/v3/assert"
+)
+
+func newAuditLog(t *testing.T) *audit.FileLog {
+	auditLog, err := audit.NewFileLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	assert.NilError(t, err)
+	t.Cleanup(func() { auditLog.Close() })
+	return auditLog
+}
+
+func TestGetAuditLog__recordsWrites(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			return []byte{0, 17, 0, 33, 0, 66, 0, 65, 0, 63, 0, 61}[0 : quantity*2], nil
+		},
+		WriteSingleRegisterMock: func(address, value uint16) ([]byte, error) {
+			if address == 11178 {
+				return nil, errors.New("Mocked write error")
+			}
+			return []byte{}, nil
+		},
+	}
+	auditLog := newAuditLog(t)
+	writeTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	heatingService := api.NewHeatingApiService(mock, api.WithAuditLog(auditLog), api.WithClock(func() time.Time { return writeTime }))
+	ctx := audit.WithEndpoint(audit.WithPrincipal(context.TODO(), "installer"), "POST /heatcurve/1/slope")
+	request := openapi.SetHeatCurveBySlopeRequest{
+		Slope:       -1.8,
+		MinFlowTemp: ptr[int32](30),
+		MaxFlowTemp: ptr[int32](70),
+	}
+	_, err := heatingService.SetHeatCurveBySlope(ctx, 1, request, false, "")
+	assert.ErrorContains(t, err, "max temp")
+
+	service := api.NewAuditApiService(auditLog)
+	response, err := service.GetAuditLog(context.TODO(), "", "", 1, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, http.StatusOK, response.Code)
+	body := response.Body.([]openapi.AuditEntry)
+	assert.Equal(t, 5, len(body))
+	expected := []struct {
+		pnu    int32
+		result string
+	}{{11175, "OK"}, {11177, "OK"}, {11178, "FAILED"}, {11177, "ROLLED_BACK"}, {11175, "ROLLED_BACK"}}
+	for i, entry := range body {
+		assert.Equal(t, "2024-01-15T12:00:00Z", entry.Time)
+		assert.Equal(t, "installer", entry.Principal)
+		assert.Equal(t, "POST /heatcurve/1/slope", entry.Endpoint)
+		assert.Equal(t, int32(1), entry.CircuitNo)
+		assert.Equal(t, expected[i].pnu, entry.Pnu)
+		assert.Equal(t, expected[i].result, entry.Result)
+	}
+	assert.Equal(t, "Mocked write error", body[2].Error)
+
+	response, err = service.GetAuditLog(context.TODO(), "", "", 2, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(response.Body.([]openapi.AuditEntry)))
+
+	response, err = service.GetAuditLog(context.TODO(), "", "", 0, 11177)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(response.Body.([]openapi.AuditEntry)))
+
+	response, err = service.GetAuditLog(context.TODO(), "2000-01-01T00:00:00Z", "2001-01-01T00:00:00Z", 0, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(response.Body.([]openapi.AuditEntry)))
+}
+
+func TestGetAuditLog__failInvalidTimestamp(t *testing.T) {
+	service := api.NewAuditApiService(newAuditLog(t))
+	_, err := service.GetAuditLog(context.TODO(), "yesterday", "", 0, 0)
+	apiErr, ok := err.(*api.ApiError)
+	assert.Assert(t, ok, "%T", err)
+	assert.Check(t, apiErr.Code == http.StatusBadRequest)
+}
+
+func TestGetAuditLog__failDisabled(t *testing.T) {
+	service := api.NewAuditApiService(nil)
+	_, err := service.GetAuditLog(context.TODO(), "", "", 0, 0)
+	apiErr, ok := err.(*api.ApiError)
+	assert.Assert(t, ok, "%T", err)
+	assert.Check(t, apiErr.Code == http.StatusNotFound)
+}
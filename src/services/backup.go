@@ -0,0 +1,230 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/treblada/ecl310-rest/codec"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/logging"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
+)
+
+// Version of the backup document format
+const backupVersion = 1
+
+// Writable parameters the backup doesn't read, their PNUs aren't known yet
+var backupNotIncluded = []string{"SCHEDULES"}
+
+/*
+The backup service reads and writes the parameters of the system and heating services, it
+uses their readers and update helpers on their client. A restore holds the write locks of
+both services, so it doesn't interleave with their writes or the clock sync.
+*/
+type BackupApiService struct {
+	openapi.BackupApiService
+	serviceOptions
+	client  wrapper.ZeroBasedAddressClientWrapper
+	system  *SystemApiService
+	heating *HeatingApiService
+}
+
+func NewBackupApiService(system openapi.SystemApiServicer, heating openapi.HeatingApiServicer, opts ...ServiceOption) openapi.BackupApiServicer {
+	systemService := system.(*SystemApiService)
+	return &BackupApiService{
+		serviceOptions: newServiceOptions(opts),
+		client:         systemService.client,
+		system:         systemService,
+		heating:        heating.(*HeatingApiService),
+	}
+}
+
+func (s *BackupApiService) GetBackup(ctx context.Context) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	return openapi.Response(http.StatusOK, s.readBackup(ctx)), nil
+}
+
+func (s *BackupApiService) readBackup(ctx context.Context) openapi.BackupDocument {
+	dst := readPnus(contextClient(ctx, s.client), s.readPlanner, wrapper.ReadRange{Address: pnuDst, Quantity: 1})[0]
+	backup := openapi.BackupDocument{
+		Version:            backupVersion,
+		CreatedAt:          s.now().UTC().Truncate(time.Second),
+		Controller:         s.system.readSystemInfo(ctx),
+		AutoDaylightSaving: dst.Uint16(0) == uint16(1),
+		Circuits:           []openapi.CircuitBackup{},
+		NotIncluded:        append([]string{}, backupNotIncluded...),
+	}
+	for circuitNo := int32(1); circuitNo <= 3; circuitNo++ {
+		if circuitNo == 3 && !s.hasCircuit(ctx, circuitNo) {
+			continue
+		}
+		backup.Circuits = append(backup.Circuits, s.readCircuit(ctx, circuitNo))
+	}
+	return backup
+}
+
+/*
+hasCircuit probes the circuit's mode, circuit 3 is missing on most controllers. Only a
+controller rejecting the PNU means there's no circuit, any other failure fails the backup.
+*/
+func (s *BackupApiService) hasCircuit(ctx context.Context, circuitNo int32) bool {
+	pnu := getCircuitModePnu(circuitNo)
+	_, err := contextClient(ctx, s.client).ReadHoldingRegisters(pnu, 1)
+	if failure, ok := classifyControllerFailure(err); ok && failure.errorCode == ErrPnuNotSupported {
+		logging.FromContext(ctx).Info("Circuit not backed up", "circuit", circuitNo, "error", err)
+		return false
+	} else if err != nil {
+		panic(NewReadError(pnu, 1, err))
+	}
+	return true
+}
+
+func (s *BackupApiService) readCircuit(ctx context.Context, circuitNo int32) openapi.CircuitBackup {
+	curve, _ := s.heating.readHeatCurve(ctx, circuitNo)
+	registers := readPnus(
+		contextClient(ctx, s.client),
+		s.readPlanner,
+		wrapper.ReadRange{Address: getCircuitModePnu(circuitNo), Quantity: 1},
+		wrapper.ReadRange{Address: getSetbackRoomTempPnu(circuitNo), Quantity: 2},
+	)
+	mode, roomTemps := registers[0], registers[1]
+	return openapi.CircuitBackup{
+		CircuitNo:            circuitNo,
+		Mode:                 GetCircuitMode(mode.Uint16(0)).String(),
+		ComfortRoomTemp:      int32(roomTemps.Int16(1)),
+		SetbackRoomTemp:      int32(roomTemps.Int16(0)),
+		Slope:                curve.Slope,
+		MinFlowTemp:          curve.MinFlowTemp,
+		MaxFlowTemp:          curve.MaxFlowTemp,
+		ParallelDisplacement: curve.ParallelDisplacement,
+		CurvePoints:          curve.CurvePoints,
+	}
+}
+
+/*
+RestoreBackup validates the whole backup before anything is read or written. The circuits'
+modes are written last, so a circuit only resumes its mode once its curve and setpoints
+are in place.
+*/
+func (s *BackupApiService) RestoreBackup(ctx context.Context, backup openapi.BackupDocument, dryRun bool) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	if backup.Version != backupVersion {
+		panic(newUnprocessableError(ErrIncompatibleBackup, "version", fmt.Sprintf("Unsupported backup version %d, expected %d", backup.Version, backupVersion)))
+	}
+	assertValidCircuitBackups(backup.Circuits)
+
+	// always in this order, no other service holds both locks
+	s.system.writeMu.Lock()
+	defer s.system.writeMu.Unlock()
+	s.heating.writeMu.Lock()
+	defer s.heating.writeMu.Unlock()
+	assertCompatibleBackup(backup.Controller, s.system.readSystemInfo(ctx))
+
+	tx := newPnuTransaction(ctx, s.client, s.serviceOptions, 0)
+	for _, circuit := range backup.Circuits {
+		tx.forCircuit(circuit.CircuitNo)
+		s.restoreCircuit(ctx, tx, circuit)
+	}
+	tx.forCircuit(0)
+	tx.update(pnuDst, codec.EncodeBool(backup.AutoDaylightSaving), "DST")
+	for _, circuit := range backup.Circuits {
+		tx.forCircuit(circuit.CircuitNo)
+		mode, _ := circuitModes.Value(circuit.Mode)
+		tx.update(getCircuitModePnu(circuit.CircuitNo), mode, "mode")
+	}
+
+	if dryRun {
+		return dryRunResponse(tx.diff()), nil
+	}
+	return dryRunResponse(tx.commit()), nil
+}
+
+func (s *BackupApiService) restoreCircuit(ctx context.Context, tx *pnuTransaction, circuit openapi.CircuitBackup) {
+	circuitNo := circuit.CircuitNo
+	// the min must not exceed the current max when it is written first
+	currentMax := readPnus(contextClient(ctx, s.client), s.readPlanner, wrapper.ReadRange{Address: getMinMaxPnu(circuitNo) + 1, Quantity: 1})[0]
+	if circuit.MinFlowTemp > int32(currentMax.Int16(0)) {
+		updateFlowTempLimits(tx, circuitNo, nil, &circuit.MaxFlowTemp)
+		updateFlowTempLimits(tx, circuitNo, &circuit.MinFlowTemp, nil)
+	} else {
+		updateFlowTempLimits(tx, circuitNo, &circuit.MinFlowTemp, &circuit.MaxFlowTemp)
+	}
+	// the controller recalculates the points for a new slope, the points written afterwards override them
+	updateSlope(tx, circuitNo, circuit.Slope)
+	updateCurvePoints(tx, circuitNo, circuit.CurvePoints)
+	updateParallelDisplacement(tx, circuitNo, &circuit.ParallelDisplacement)
+	tx.update(getSetbackRoomTempPnu(circuitNo), mustEncode(codec.EncodeInt16(int64(circuit.SetbackRoomTemp))), "setback room temp")
+	tx.update(getRoomTempPnu(circuitNo), mustEncode(codec.EncodeInt16(int64(circuit.ComfortRoomTemp))), "comfort room temp")
+}
+
+func assertValidCircuitBackups(circuits []openapi.CircuitBackup) {
+	seen := Int32Slice{}
+	for i, circuit := range circuits {
+		field := fmt.Sprintf("circuits[%d]", i)
+		if circuit.CircuitNo < 1 || circuit.CircuitNo > 3 {
+			panic(NewValidationError(ErrInvalidCircuit, field+".circuitNo", fmt.Sprintf("Invalid circuit number %d, not in [1,3]", circuit.CircuitNo)))
+		}
+		if seen.has(circuit.CircuitNo) {
+			panic(NewValidationError(ErrMalformedRequest, field+".circuitNo", fmt.Sprintf("Duplicate backup of circuit %d", circuit.CircuitNo)))
+		}
+		seen = append(seen, circuit.CircuitNo)
+		if _, ok := circuitModes.Value(circuit.Mode); !ok {
+			panic(NewValidationError(ErrValueOutOfRange, field+".mode", fmt.Sprintf("Invalid mode %q, not in %v", circuit.Mode, circuitModes)))
+		}
+		assertValidRoomTemp(circuit.ComfortRoomTemp, field+".comfortRoomTemp")
+		assertValidRoomTemp(circuit.SetbackRoomTemp, field+".setbackRoomTemp")
+		assertValidSlope(circuit.Slope, field+".slope")
+		assertValidFlowTemperatureRange(&circuit.MinFlowTemp, field+".minFlowTemp", "min flow temp")
+		assertValidFlowTemperatureRange(&circuit.MaxFlowTemp, field+".maxFlowTemp", "max flow temp")
+		if circuit.MinFlowTemp > circuit.MaxFlowTemp {
+			panic(NewValidationError(ErrValueOutOfRange, field+".maxFlowTemp", fmt.Sprintf("Max flow temp %d below min flow temp %d", circuit.MaxFlowTemp, circuit.MinFlowTemp)))
+		}
+		assertValidParallelDisplacement(&circuit.ParallelDisplacement, field+".parallelDisplacement")
+		assertValidCurvePoints(circuit.CurvePoints, field+".curvePoints")
+		assertCompleteCurvePoints(circuit.CurvePoints, field+".curvePoints")
+	}
+}
+
+func assertValidRoomTemp(roomTemp int32, field string) {
+	if roomTemp < 5 || roomTemp > 30 {
+		panic(NewValidationError(ErrValueOutOfRange, field, fmt.Sprintf("Invalid room temp %d, must be in [5, 30]", roomTemp)))
+	}
+}
+
+// assertCompatibleBackup accepts backups of the same application type and the same or an older software version
+func assertCompatibleBackup(backup openapi.GetSystemInfoResponse, controller openapi.GetSystemInfoResponse) {
+	if applicationType(backup.Application) != applicationType(controller.Application) {
+		panic(newUnprocessableError(ErrIncompatibleBackup, "controller.application", fmt.Sprintf("Backup of application %s doesn't fit the controller's application %s", backup.Application, controller.Application)))
+	}
+	if backup.SoftwareVersion > controller.SoftwareVersion {
+		panic(newUnprocessableError(ErrIncompatibleBackup, "controller.software_version", fmt.Sprintf("Backup of software version %d is newer than the controller's %d", backup.SoftwareVersion, controller.SoftwareVersion)))
+	}
+}
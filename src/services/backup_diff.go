@@ -0,0 +1,180 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/treblada/ecl310-rest/generated/openapi"
+)
+
+const (
+	DiffChanged     = "CHANGED"
+	DiffMissing     = "MISSING"
+	DiffUnsupported = "UNSUPPORTED"
+)
+
+// A parameter of a backup, with an empty value if it is missing in the backup
+type backupParameter struct {
+	name      string
+	pnu       uint16
+	unit      string
+	value     string
+	circuitNo int32
+}
+
+/*
+DiffBackup compares the first backup with the second one or, if there is none, with the
+controller's current configuration read like for a backup.
+*/
+func (s *BackupApiService) DiffBackup(ctx context.Context, request openapi.ConfigDiffRequest) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	if len(request.Backups) < 1 || len(request.Backups) > 2 {
+		panic(NewValidationError(ErrMalformedRequest, "backups", fmt.Sprintf("Invalid number of backups %d, must be in [1, 2]", len(request.Backups))))
+	}
+	from := request.Backups[0]
+	var to openapi.BackupDocument
+	if len(request.Backups) == 2 {
+		to = request.Backups[1]
+	} else {
+		to = s.readBackup(ctx)
+	}
+	return openapi.Response(http.StatusOK, DiffBackups(from, to)), nil
+}
+
+/*
+DiffBackups lists the parameters differing between two backups, in the order of the from
+backup followed by those only the to backup has. A parameter of a circuit missing in the
+other backup is UNSUPPORTED, any other parameter without a value on one side is MISSING.
+*/
+func DiffBackups(from, to openapi.BackupDocument) openapi.ConfigDiff {
+	fromParams, toParams := backupParameters(from), backupParameters(to)
+	fromCircuits, toCircuits := backupCircuits(from), backupCircuits(to)
+	toByName := make(map[string]backupParameter, len(toParams))
+	for _, param := range toParams {
+		toByName[param.name] = param
+	}
+
+	diff := openapi.ConfigDiff{Entries: []openapi.ParameterDiff{}}
+	seen := make(map[string]bool, len(fromParams))
+	for _, fromParam := range fromParams {
+		seen[fromParam.name] = true
+		toParam, ok := toByName[fromParam.name]
+		if !ok {
+			toParam = backupParameter{circuitNo: fromParam.circuitNo}
+		}
+		diff.Compared++
+		if entry, differs := diffParameter(fromParam, toParam, toCircuits); differs {
+			diff.Entries = append(diff.Entries, entry)
+		}
+	}
+	for _, toParam := range toParams {
+		if seen[toParam.name] {
+			continue
+		}
+		diff.Compared++
+		entry, _ := diffParameter(backupParameter{name: toParam.name, circuitNo: toParam.circuitNo}, toParam, fromCircuits)
+		diff.Entries = append(diff.Entries, entry)
+	}
+	return diff
+}
+
+// diffParameter compares the values of a parameter, otherCircuits are those of the side the parameter may be missing on
+func diffParameter(from, to backupParameter, otherCircuits Int32Slice) (openapi.ParameterDiff, bool) {
+	known := from
+	if known.value == "" {
+		known = to
+	}
+	entry := openapi.ParameterDiff{
+		Name:   known.name,
+		Pnu:    int32(known.pnu),
+		Unit:   known.unit,
+		Status: DiffChanged,
+		From:   from.value,
+		To:     to.value,
+	}
+	switch {
+	case from.value == to.value:
+		return entry, false
+	case known.circuitNo != 0 && !otherCircuits.has(known.circuitNo):
+		entry.Status = DiffUnsupported
+	case from.value == "" || to.value == "":
+		entry.Status = DiffMissing
+	}
+	return entry, true
+}
+
+func backupCircuits(backup openapi.BackupDocument) Int32Slice {
+	circuits := Int32Slice{}
+	for _, circuit := range backup.Circuits {
+		circuits = append(circuits, circuit.CircuitNo)
+	}
+	return circuits
+}
+
+// backupParameters lists the parameters of the backup by PNU, leaving out the controller's serial number and network settings
+func backupParameters(backup openapi.BackupDocument) []backupParameter {
+	params := []backupParameter{
+		{name: "controller.application", pnu: 2060, value: backup.Controller.Application},
+		{name: "controller.application_version", pnu: 2063, value: backup.Controller.ApplicationVersion},
+		{name: "controller.software_version", pnu: 35, value: formatNonZero(backup.Controller.SoftwareVersion)},
+		{name: "autoDaylightSaving", pnu: pnuDst, value: strconv.FormatBool(backup.AutoDaylightSaving)},
+	}
+	for _, circuit := range backup.Circuits {
+		circuitNo := circuit.CircuitNo
+		prefix := fmt.Sprintf("circuit%d.", circuitNo)
+		circuitParams := []backupParameter{
+			{name: prefix + "mode", pnu: getCircuitModePnu(circuitNo), value: circuit.Mode},
+			{name: prefix + "comfortRoomTemp", pnu: getRoomTempPnu(circuitNo), unit: "°C", value: formatNonZero(circuit.ComfortRoomTemp)},
+			{name: prefix + "setbackRoomTemp", pnu: getSetbackRoomTempPnu(circuitNo), unit: "°C", value: formatNonZero(circuit.SetbackRoomTemp)},
+			{name: prefix + "slope", pnu: getSlopePnu(circuitNo), value: strconv.FormatFloat(float64(circuit.Slope), 'f', -1, 32)},
+			{name: prefix + "minFlowTemp", pnu: getMinMaxPnu(circuitNo), unit: "°C", value: formatNonZero(circuit.MinFlowTemp)},
+			{name: prefix + "maxFlowTemp", pnu: getMinMaxPnu(circuitNo) + 1, unit: "°C", value: formatNonZero(circuit.MaxFlowTemp)},
+			{name: prefix + "parallelDisplacement", pnu: getParallelDisplacementPnu(circuitNo), unit: "K", value: strconv.Itoa(int(circuit.ParallelDisplacement))},
+		}
+		for i, outdoorTemp := range validOutdoorTemps {
+			param := backupParameter{name: fmt.Sprintf("%scurvePoints[%d]", prefix, outdoorTemp), pnu: getTempCurvePointsPnu(circuitNo) + uint16(i), unit: "°C"}
+			for _, point := range circuit.CurvePoints {
+				if point.OutdoorTemp == outdoorTemp {
+					param.value = strconv.Itoa(int(point.FlowTemp))
+				}
+			}
+			circuitParams = append(circuitParams, param)
+		}
+		for i := range circuitParams {
+			circuitParams[i].circuitNo = circuitNo
+		}
+		params = append(params, circuitParams...)
+	}
+	return params
+}
+
+// formatNonZero formats a value which is never 0 when present, so 0 stands for a missing value
+func formatNonZero(value int32) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(value))
+}
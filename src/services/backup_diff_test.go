@@ -0,0 +1,96 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	api "github.com/treblada/ecl310-rest/services"
+	"gotest.tools/v3/assert"
+)
+
+func TestDiffBackups__equalBackups(t *testing.T) {
+	backup := getBackup(t, backupRegisters())
+	diff := api.DiffBackups(backup, backup)
+	assert.Equal(t, 0, len(diff.Entries))
+	// identity and DST, 13 per circuit
+	assert.Equal(t, int32(4+2*13), diff.Compared)
+}
+
+func TestDiffBackups__changedMissingAndUnsupported(t *testing.T) {
+	from := getBackup(t, backupRegisters())
+	registers := backupRegisters()
+	registers[35] = 43
+	registers[37] = 5678
+	registers[11178] = 75
+	registers[12176] = 0xfffe
+	registers[4203] = 3
+	registers[13175] = 12
+	registers[13176] = 0
+	registers[13177] = 20
+	registers[13178] = 60
+	registers[13179] = 17
+	registers[13180] = 22
+	for i := uint16(0); i < 6; i++ {
+		registers[13400+i] = 50
+	}
+	to := getBackup(t, registers)
+	to.Circuits[0].CurvePoints = to.Circuits[0].CurvePoints[1:]
+
+	diff := api.DiffBackups(from, to)
+	assertDeepEqual(t, diff.Entries[:4], []openapi.ParameterDiff{
+		{Name: "controller.software_version", Pnu: 35, Status: api.DiffChanged, From: "42", To: "43"},
+		{Name: "circuit1.maxFlowTemp", Pnu: 11178, Unit: "°C", Status: api.DiffChanged, From: "70", To: "75"},
+		{Name: "circuit1.curvePoints[-30]", Pnu: 11400, Unit: "°C", Status: api.DiffMissing, From: "65"},
+		{Name: "circuit2.parallelDisplacement", Pnu: 12176, Unit: "K", Status: api.DiffChanged, From: "0", To: "-2"},
+	})
+	// all of circuit 3 only exists on the to controller
+	assert.Equal(t, 4+13, len(diff.Entries))
+	assertDeepEqual(t, diff.Entries[4], openapi.ParameterDiff{Name: "circuit3.mode", Pnu: 4203, Status: api.DiffUnsupported, To: "CONSTANT_SETBACK_TEMP"})
+	for _, entry := range diff.Entries[4:] {
+		assert.Equal(t, api.DiffUnsupported, entry.Status, entry.Name)
+	}
+	assert.Equal(t, int32(4+3*13), diff.Compared)
+}
+
+func TestDiffBackup__comparesWithController(t *testing.T) {
+	registers := backupRegisters()
+	backup := getBackup(t, registers)
+	registers[11180] = 23
+	service := backupService(controllerMock(registers))
+
+	response, err := service.DiffBackup(context.TODO(), openapi.ConfigDiffRequest{Backups: []openapi.BackupDocument{backup}})
+	assert.NilError(t, err)
+	assertDeepEqual(t, response.Body.(openapi.ConfigDiff).Entries, []openapi.ParameterDiff{
+		{Name: "circuit1.comfortRoomTemp", Pnu: 11180, Unit: "°C", Status: api.DiffChanged, From: "21", To: "23"},
+	})
+}
+
+func TestDiffBackup__rejectsMoreThanTwoBackups(t *testing.T) {
+	backup := getBackup(t, backupRegisters())
+	service := backupService(controllerMock(backupRegisters()))
+
+	_, err := service.DiffBackup(context.TODO(), openapi.ConfigDiffRequest{Backups: []openapi.BackupDocument{backup, backup, backup}})
+	apiErr, ok := err.(*api.ApiError)
+	assert.Assert(t, ok, "%T", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+	assert.Equal(t, api.ErrMalformedRequest, apiErr.ErrorCode)
+	assert.Equal(t, "backups", apiErr.Field)
+}
@@ -0,0 +1,277 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/treblada/ecl310-rest/audit"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/mocks"
+	api "github.com/treblada/ecl310-rest/services"
+	"gotest.tools/v3/assert"
+)
+
+// controllerMock serves reads and writes from the registers, missing registers fail
+func controllerMock(registers map[uint16]uint16) *mocks.ClientMock {
+	return &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			result := []byte{}
+			for pnu := address; pnu < address+quantity; pnu++ {
+				value, ok := registers[pnu]
+				if !ok {
+					return nil, fmt.Errorf("PNU %d not mocked", pnu)
+				}
+				result = append(result, byte(value>>8), byte(value))
+			}
+			return result, nil
+		},
+		WriteSingleRegisterMock: func(address, value uint16) ([]byte, error) {
+			if _, ok := registers[address]; !ok {
+				return nil, fmt.Errorf("PNU %d not mocked", address)
+			}
+			registers[address] = value
+			return []byte{}, nil
+		},
+	}
+}
+
+// backupRegisters is a controller with application A266.1, software version 42 and circuits 1 and 2
+func backupRegisters() map[uint16]uint16 {
+	registers := map[uint16]uint16{
+		19: 1, 34: 0, 35: 42, 36: 0, 37: 1234, 258: 0, 2060: 'A', 2061: 266, 2062: 1, 2063: 0, 2099: 0x1520,
+		10198: 1, 4201: 1, 4202: 2,
+	}
+	for pnu := uint16(278); pnu <= 289; pnu++ {
+		registers[pnu] = 1
+	}
+	for circuitNo := uint16(1); circuitNo <= 2; circuitNo++ {
+		base := 10000 + circuitNo*1000
+		registers[base+175] = 17 // slope
+		registers[base+176] = 0  // parallel displacement
+		registers[base+177] = 30 // min
+		registers[base+178] = 70 // max
+		registers[base+179] = 16 // setback room temp
+		registers[base+180] = 21 // comfort room temp
+		for i, flowTemp := range []uint16{65, 63, 61, 59, 57, 55} {
+			registers[base+400+uint16(i)] = flowTemp
+		}
+	}
+	return registers
+}
+
+func backupService(mock *mocks.ClientMock, opts ...api.ServiceOption) openapi.BackupApiServicer {
+	mock = withCircuit3Probe(mock, &modbus.ModbusError{FunctionCode: 3, ExceptionCode: modbus.ExceptionCodeIllegalDataAddress})
+	return api.NewBackupApiService(api.NewSystemApiService(mock), api.NewHeatingApiService(mock), opts...)
+}
+
+// withCircuit3Probe fails reading the mode of circuit 3 with err, unless it's mocked
+func withCircuit3Probe(mock *mocks.ClientMock, err error) *mocks.ClientMock {
+	read := mock.ReadHoldingRegistersMock
+	mock.ReadHoldingRegistersMock = func(address, quantity uint16) ([]byte, error) {
+		result, readErr := read(address, quantity)
+		if readErr != nil && address == 4203 {
+			return nil, err
+		}
+		return result, readErr
+	}
+	return mock
+}
+
+func getBackup(t *testing.T, registers map[uint16]uint16) openapi.BackupDocument {
+	response, err := backupService(controllerMock(registers)).GetBackup(context.TODO())
+	assert.NilError(t, err)
+	return response.Body.(openapi.BackupDocument)
+}
+
+func TestGetBackup__readsCircuitsAndIdentity(t *testing.T) {
+	createTime := time.Date(2024, 1, 15, 12, 0, 30, 500, time.UTC)
+	response, err := backupService(controllerMock(backupRegisters()), api.WithClock(func() time.Time { return createTime })).GetBackup(context.TODO())
+	assert.NilError(t, err)
+	backup := response.Body.(openapi.BackupDocument)
+	assert.Equal(t, createTime.Truncate(time.Second), backup.CreatedAt)
+	assert.Equal(t, 1, int(backup.Version))
+	assert.Equal(t, "A266.1", backup.Controller.Application)
+	assert.Equal(t, int32(42), backup.Controller.SoftwareVersion)
+	assert.Check(t, backup.AutoDaylightSaving)
+	assertDeepEqual(t, backup.NotIncluded, []string{"SCHEDULES"})
+	// circuit 3 doesn't exist
+	assert.Equal(t, 2, len(backup.Circuits))
+	assertDeepEqual(t, backup.Circuits[1], openapi.CircuitBackup{
+		CircuitNo:       2,
+		Mode:            "CONSTANT_COMFORT_TEMP",
+		ComfortRoomTemp: 21,
+		SetbackRoomTemp: 16,
+		Slope:           -1.7,
+		MinFlowTemp:     30,
+		MaxFlowTemp:     70,
+		CurvePoints: []openapi.FlowTempPoint{
+			{OutdoorTemp: -30, FlowTemp: 65},
+			{OutdoorTemp: -15, FlowTemp: 63},
+			{OutdoorTemp: -5, FlowTemp: 61},
+			{OutdoorTemp: 0, FlowTemp: 59},
+			{OutdoorTemp: 5, FlowTemp: 57},
+			{OutdoorTemp: 15, FlowTemp: 55},
+		},
+	})
+}
+
+func TestGetBackup__failsWhenCircuit3ProbeTimesOut(t *testing.T) {
+	mock := withCircuit3Probe(controllerMock(backupRegisters()), &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}})
+	service := api.NewBackupApiService(api.NewSystemApiService(mock), api.NewHeatingApiService(mock))
+	_, err := service.GetBackup(context.TODO())
+	var apiErr *api.ApiError
+	assert.Assert(t, errors.As(err, &apiErr), err)
+	assert.Equal(t, uint16(4203), apiErr.Pnu)
+	recorder, problem := handleError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Equal(t, "CONTROLLER_TIMEOUT", problem.Code)
+}
+
+func TestRestoreBackup__writesDifferencesInSafeOrder(t *testing.T) {
+	backup := getBackup(t, backupRegisters())
+	// the replacement controller has its factory settings
+	registers := backupRegisters()
+	registers[10198] = 0
+	registers[4201] = 4
+	registers[11177] = 10
+	registers[11178] = 25
+	registers[11175] = 10
+	registers[11405] = 40
+	registers[12180] = 22
+	mock := controllerMock(registers)
+	auditLog := newAuditLog(t)
+	service := backupService(mock, api.WithAuditLog(auditLog))
+
+	response, err := service.RestoreBackup(context.TODO(), backup, true)
+	assert.NilError(t, err)
+	changes := []openapi.PnuChange{
+		{Pnu: 11178, Label: "max temp", OldValue: 25, NewValue: 70},
+		{Pnu: 11177, Label: "min temp", OldValue: 10, NewValue: 30},
+		{Pnu: 11175, Label: "slope", OldValue: 10, NewValue: 17},
+		// the new slope recalculates the points
+		{Pnu: 11400, Label: "-30 outdoor temp", OldValue: 65, NewValue: 65},
+		{Pnu: 11401, Label: "-15 outdoor temp", OldValue: 63, NewValue: 63},
+		{Pnu: 11402, Label: "-5 outdoor temp", OldValue: 61, NewValue: 61},
+		{Pnu: 11403, Label: "0 outdoor temp", OldValue: 59, NewValue: 59},
+		{Pnu: 11404, Label: "5 outdoor temp", OldValue: 57, NewValue: 57},
+		{Pnu: 11405, Label: "15 outdoor temp", OldValue: 40, NewValue: 55},
+		{Pnu: 12180, Label: "comfort room temp", OldValue: 22, NewValue: 21},
+		{Pnu: 10198, Label: "DST", OldValue: 0, NewValue: 1},
+		{Pnu: 4201, Label: "mode", OldValue: 4, NewValue: 1},
+	}
+	assertDeepEqual(t, response.Body, openapi.DryRunResponse{Changes: changes})
+	assert.Equal(t, uint16(25), registers[11178])
+
+	response, err = service.RestoreBackup(context.TODO(), backup, false)
+	assert.NilError(t, err)
+	assertDeepEqual(t, response.Body, openapi.DryRunResponse{Changes: changes})
+	assertDeepEqual(t, registers, backupRegisters())
+
+	entries, err := auditLog.Query(audit.Filter{Circuit: 2})
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, uint16(12180), entries[0].Pnu)
+}
+
+func TestRestoreBackup__rewritesPointsRecalculatedBySlope(t *testing.T) {
+	backup := getBackup(t, backupRegisters())
+	registers := backupRegisters()
+	registers[12175] = 10
+	mock := controllerMock(registers)
+	write := mock.WriteSingleRegisterMock
+	mock.WriteSingleRegisterMock = func(address, value uint16) ([]byte, error) {
+		if address == 12175 {
+			// like the controller, a new slope replaces the points
+			for pnu := uint16(12400); pnu <= 12405; pnu++ {
+				registers[pnu] = 45
+			}
+		}
+		return write(address, value)
+	}
+
+	response, err := backupService(mock).RestoreBackup(context.TODO(), backup, false)
+	assert.NilError(t, err)
+	assert.Equal(t, 7, len(response.Body.(openapi.DryRunResponse).Changes))
+	assertDeepEqual(t, registers, backupRegisters())
+}
+
+func TestRestoreBackup__failIncompatible(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    func(backup *openapi.BackupDocument)
+		errorCode api.ErrorCode
+		field     string
+	}{
+		{"other application", func(backup *openapi.BackupDocument) { backup.Controller.Application = "A260.1" }, api.ErrIncompatibleBackup, "controller.application"},
+		{"newer software", func(backup *openapi.BackupDocument) { backup.Controller.SoftwareVersion = 43 }, api.ErrIncompatibleBackup, "controller.software_version"},
+		{"other version", func(backup *openapi.BackupDocument) { backup.Version = 2 }, api.ErrIncompatibleBackup, "version"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backup := getBackup(t, backupRegisters())
+			test.change(&backup)
+			mock := controllerMock(backupRegisters())
+			_, err := backupService(mock).RestoreBackup(context.TODO(), backup, false)
+			apiErr, ok := err.(*api.ApiError)
+			assert.Assert(t, ok, "%T", err)
+			assert.Equal(t, http.StatusUnprocessableEntity, apiErr.Code)
+			assert.Equal(t, test.errorCode, apiErr.ErrorCode)
+			assert.Equal(t, test.field, apiErr.Field)
+			for _, call := range mock.Calls {
+				assert.Check(t, call.FuncName == "ReadHoldingRegisters", "%v", call)
+			}
+		})
+	}
+}
+
+func TestRestoreBackup__failInvalidCircuits(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    func(circuits []openapi.CircuitBackup)
+		errorCode api.ErrorCode
+		field     string
+	}{
+		{"circuit", func(circuits []openapi.CircuitBackup) { circuits[1].CircuitNo = 4 }, api.ErrInvalidCircuit, "circuits[1].circuitNo"},
+		{"duplicate circuit", func(circuits []openapi.CircuitBackup) { circuits[1].CircuitNo = 1 }, api.ErrMalformedRequest, "circuits[1].circuitNo"},
+		{"unknown mode", func(circuits []openapi.CircuitBackup) { circuits[0].Mode = "UNKNOWN(7)" }, api.ErrValueOutOfRange, "circuits[0].mode"},
+		{"room temp", func(circuits []openapi.CircuitBackup) { circuits[0].SetbackRoomTemp = 4 }, api.ErrValueOutOfRange, "circuits[0].setbackRoomTemp"},
+		{"limits", func(circuits []openapi.CircuitBackup) { circuits[0].MinFlowTemp = 80 }, api.ErrValueOutOfRange, "circuits[0].maxFlowTemp"},
+		{"incomplete points", func(circuits []openapi.CircuitBackup) { circuits[1].CurvePoints = circuits[1].CurvePoints[1:] }, api.ErrMissingField, "circuits[1].curvePoints"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backup := getBackup(t, backupRegisters())
+			test.change(backup.Circuits)
+			mock := controllerMock(backupRegisters())
+			_, err := backupService(mock).RestoreBackup(context.TODO(), backup, false)
+			apiErr, ok := err.(*api.ApiError)
+			assert.Assert(t, ok, "%T", err)
+			assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+			assert.Equal(t, test.errorCode, apiErr.ErrorCode)
+			assert.Equal(t, test.field, apiErr.Field)
+			assert.Equal(t, 0, len(mock.Calls))
+		})
+	}
+}
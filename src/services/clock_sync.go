@@ -0,0 +1,185 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/treblada/ecl310-rest/audit"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/logging"
+)
+
+// Drift beyond which the background sync corrects the controller's clock by default
+const defaultClockSyncThreshold = 2 * time.Minute
+
+// Principal the background sync's writes are audited as
+const clockSyncPrincipal = "clock-sync"
+
+const (
+	ClockInSync           = "IN_SYNC"
+	ClockCorrected        = "CORRECTED"
+	ClockSkippedDstChange = "SKIPPED_DST_CHANGE"
+)
+
+// SyncSystemDateTime corrects any drift of the controller's clock of at least a minute
+func (s *SystemApiService) SyncSystemDateTime(ctx context.Context, dryRun bool) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return openapi.Response(http.StatusOK, s.syncClock(ctx, time.Minute, dryRun)), nil
+}
+
+/*
+StartClockSync compares the controller's clock with the host's every interval until the
+context is done, correcting a drift beyond the configured threshold. So the clock is set
+at most once per interval.
+*/
+func StartClockSync(ctx context.Context, service openapi.SystemApiServicer, interval time.Duration) {
+	s := service.(*SystemApiService)
+	ctx = audit.WithEndpoint(audit.WithPrincipal(ctx, clockSyncPrincipal), "clock sync")
+	job := s.pollers.register("clock sync", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.runClockSync(ctx, job)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *SystemApiService) runClockSync(ctx context.Context, job *poller) {
+	logger := logging.FromContext(ctx)
+	start := s.now()
+	defer func() {
+		panic := recover()
+		if panic != nil {
+			logger.Warn("Clock sync failed", "error", panic)
+		}
+		job.done(start, panic)
+	}()
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	result := s.syncClock(ctx, s.clockSyncThreshold, false)
+	if result.Status == ClockCorrected {
+		logger.Info("Corrected controller clock", "drift_minutes", result.DriftMinutes, "zone", result.TimeZone)
+	} else {
+		logger.Debug("Controller clock not corrected", "status", result.Status, "drift_minutes", result.DriftMinutes)
+	}
+}
+
+/*
+syncClock sets the controller's clock to the host's if they differ by at least the
+threshold. The caller holds the write lock.
+*/
+func (s *SystemApiService) syncClock(ctx context.Context, threshold time.Duration, dryRun bool) openapi.ClockSyncResult {
+	now := s.now().In(s.timeZone)
+	controllerTime, _ := s.getDateTime(ctx)
+	hostTime := toSystemDateTime(controllerClock(now, controllerTime.AutoDaylightSaving), controllerTime.AutoDaylightSaving)
+	drift := wallTime(controllerTime).Sub(wallTime(hostTime))
+	result := openapi.ClockSyncResult{
+		TimeZone:       s.timeZone.String(),
+		ControllerTime: controllerTime,
+		HostTime:       hostTime,
+		DriftMinutes:   int32(drift / time.Minute),
+		Status:         ClockInSync,
+		Changes:        []openapi.PnuChange{},
+	}
+
+	switch {
+	case drift.Abs() < threshold:
+	case controllerTime.AutoDaylightSaving && nearDstChange(now):
+		result.Status = ClockSkippedDstChange
+	default:
+		result.Status = ClockCorrected
+		tx := newPnuTransaction(ctx, s.client, s.serviceOptions, 0)
+		updateDateTime(tx, controllerTime, result.HostTime)
+		if dryRun {
+			result.Changes = toPnuChanges(tx.diff())
+		} else {
+			result.Changes = toPnuChanges(tx.commit())
+		}
+	}
+	return result
+}
+
+/*
+controllerClock is the time the controller should show. With automatic daylight saving the
+controller shows the local time, otherwise it stays at standard time in summer.
+*/
+func controllerClock(now time.Time, autoDaylightSaving bool) time.Time {
+	if autoDaylightSaving || !now.IsDST() {
+		return now
+	}
+	return now.In(standardZone(now))
+}
+
+// controllerInstant is the point in time the controller's clock shows in the time zone
+func controllerInstant(dateTime openapi.GetSystemDateTime, zone *time.Location) time.Time {
+	local := time.Date(int(dateTime.Year), time.Month(dateTime.Month), int(dateTime.Day), int(dateTime.Hour), int(dateTime.Minute), 0, 0, zone)
+	if dateTime.AutoDaylightSaving || !local.IsDST() {
+		return local
+	}
+	return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), 0, 0, standardZone(local))
+}
+
+// standardZone is the zone of the time without daylight saving, in the time's year
+func standardZone(t time.Time) *time.Location {
+	for _, month := range []time.Month{time.January, time.July} {
+		if probe := time.Date(t.Year(), month, 1, 0, 0, 0, 0, t.Location()); !probe.IsDST() {
+			return time.FixedZone(probe.Zone())
+		}
+	}
+	return t.Location()
+}
+
+// wallTime is the date and time in UTC, to compare them without any zone rules
+func wallTime(dateTime openapi.GetSystemDateTime) time.Time {
+	return time.Date(int(dateTime.Year), time.Month(dateTime.Month), int(dateTime.Day), int(dateTime.Hour), int(dateTime.Minute), 0, 0, time.UTC)
+}
+
+func toSystemDateTime(t time.Time, autoDaylightSaving bool) openapi.GetSystemDateTime {
+	return openapi.GetSystemDateTime{
+		Year:               int32(t.Year()),
+		Month:              int32(t.Month()),
+		Day:                int32(t.Day()),
+		Hour:               int32(t.Hour()),
+		Minute:             int32(t.Minute()),
+		AutoDaylightSaving: autoDaylightSaving,
+		Iso:                t.Truncate(time.Minute),
+	}
+}
+
+// nearDstChange tells if the zone's offset changes within an hour, the controller may switch at a slightly different time
+func nearDstChange(now time.Time) bool {
+	_, before := now.Add(-time.Hour).Zone()
+	_, after := now.Add(time.Hour).Zone()
+	return before != after
+}
@@ -0,0 +1,109 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	api "github.com/treblada/ecl310-rest/services"
+	"gotest.tools/v3/assert"
+)
+
+// clockRegisters is a controller showing the given time
+func clockRegisters(year, month, day, hour, minute uint16, autoDaylightSaving bool) map[uint16]uint16 {
+	registers := map[uint16]uint16{64045: hour, 64046: minute, 64047: day, 64048: month, 64049: year, 10198: 0}
+	if autoDaylightSaving {
+		registers[10198] = 1
+	}
+	return registers
+}
+
+func wallTime(dateTime openapi.GetSystemDateTime) string {
+	return fmt.Sprintf("%04d-%02d-%02d %02d:%02d", dateTime.Year, dateTime.Month, dateTime.Day, dateTime.Hour, dateTime.Minute)
+}
+
+func syncClock(t *testing.T, registers map[uint16]uint16, now string, dryRun bool) openapi.ClockSyncResult {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NilError(t, err)
+	hostTime, err := time.Parse(time.RFC3339, now)
+	assert.NilError(t, err)
+	service := api.NewSystemApiService(controllerMock(registers), api.WithTimeZone(berlin), api.WithClock(func() time.Time { return hostTime }))
+	response, err := service.SyncSystemDateTime(context.TODO(), dryRun)
+	assert.NilError(t, err)
+	return response.Body.(openapi.ClockSyncResult)
+}
+
+func TestSyncSystemDateTime__inSync(t *testing.T) {
+	registers := clockRegisters(2026, 3, 10, 10, 0, true)
+	result := syncClock(t, registers, "2026-03-10T09:00:45Z", false)
+	assert.Equal(t, api.ClockInSync, result.Status)
+	assert.Equal(t, int32(0), result.DriftMinutes)
+	assert.Equal(t, "Europe/Berlin", result.TimeZone)
+	assert.Equal(t, 0, len(result.Changes))
+}
+
+func TestSyncSystemDateTime__correctsDrift(t *testing.T) {
+	registers := clockRegisters(2026, 3, 10, 10, 5, true)
+	result := syncClock(t, registers, "2026-03-10T09:00:30Z", false)
+	assert.Equal(t, api.ClockCorrected, result.Status)
+	assert.Equal(t, int32(5), result.DriftMinutes)
+	assertDeepEqual(t, result.Changes, []openapi.PnuChange{{Pnu: 64046, Label: "minute", OldValue: 5, NewValue: 0}})
+	assert.Equal(t, uint16(0), registers[64046])
+	// the daylight saving flag is kept
+	assert.Equal(t, uint16(1), registers[10198])
+}
+
+func TestSyncSystemDateTime__dryRun(t *testing.T) {
+	registers := clockRegisters(2026, 2, 28, 23, 58, true)
+	result := syncClock(t, registers, "2026-02-28T23:01:00Z", true)
+	assert.Equal(t, api.ClockCorrected, result.Status)
+	assert.Equal(t, int32(-3), result.DriftMinutes)
+	assert.Equal(t, wallTime(result.HostTime), "2026-03-01 00:01")
+	assert.Equal(t, "2026-03-01T00:01:00+01:00", result.HostTime.Iso.Format(time.RFC3339))
+	assertDeepEqual(t, result.Changes, []openapi.PnuChange{
+		{Pnu: 64048, Label: "month", OldValue: 2, NewValue: 3},
+		{Pnu: 64047, Label: "day", OldValue: 28, NewValue: 1},
+		{Pnu: 64045, Label: "hour", OldValue: 23, NewValue: 0},
+		{Pnu: 64046, Label: "minute", OldValue: 58, NewValue: 1},
+	})
+	assert.Equal(t, uint16(58), registers[64046])
+}
+
+func TestSyncSystemDateTime__standardTimeWithoutAutoDaylightSaving(t *testing.T) {
+	// 12:00 CEST is 11:00 CET
+	result := syncClock(t, clockRegisters(2026, 7, 1, 11, 0, false), "2026-07-01T10:00:00Z", false)
+	assert.Equal(t, api.ClockInSync, result.Status)
+
+	result = syncClock(t, clockRegisters(2026, 7, 1, 11, 0, true), "2026-07-01T10:00:00Z", false)
+	assert.Equal(t, api.ClockCorrected, result.Status)
+	assert.Equal(t, int32(-60), result.DriftMinutes)
+	assert.Equal(t, int32(12), result.HostTime.Hour)
+}
+
+func TestSyncSystemDateTime__skipsDaylightSavingChange(t *testing.T) {
+	// summer time started at 01:00 UTC, the controller hasn't switched yet
+	registers := clockRegisters(2026, 3, 29, 2, 30, true)
+	result := syncClock(t, registers, "2026-03-29T01:30:00Z", false)
+	assert.Equal(t, api.ClockSkippedDstChange, result.Status)
+	assert.Equal(t, int32(-60), result.DriftMinutes)
+	assert.Equal(t, uint16(2), registers[64045])
+}
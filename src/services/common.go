@@ -17,15 +17,119 @@ ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
 package api
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"time"
 
+	"github.com/treblada/ecl310-rest/audit"
+	"github.com/treblada/ecl310-rest/codec"
+	"github.com/treblada/ecl310-rest/etag"
 	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/history"
+	"github.com/treblada/ecl310-rest/logging"
 	wrapper "github.com/treblada/ecl310-rest/modbus"
+	"github.com/treblada/ecl310-rest/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+type ServiceOption func(*serviceOptions)
+
+type serviceOptions struct {
+	auditLog    audit.Log
+	readPlanner *wrapper.ReadPlanner
+	breaker     *wrapper.Breaker
+	modbusStats *wrapper.StatsClient
+	// writes without an If-Match header are rejected
+	requireIfMatch bool
+	// the gateway's clock the controller's clock is compared with
+	timeZone           *time.Location
+	now                func() time.Time
+	clockSyncThreshold time.Duration
+	// background jobs report their state here
+	pollers *Pollers
+	// recorded values of the controller, nil if the history is disabled
+	historyStore history.Store
+}
+
+// WithAuditLog records every write to the controller in the given log
+func WithAuditLog(auditLog audit.Log) ServiceOption {
+	return func(o *serviceOptions) {
+		o.auditLog = auditLog
+	}
+}
+
+// WithReadPlanner merges the reads of a request into as few modbus requests as the planner allows
+func WithReadPlanner(planner *wrapper.ReadPlanner) ServiceOption {
+	return func(o *serviceOptions) {
+		o.readPlanner = planner
+	}
+}
+
+// WithBreaker reports the state of the circuit breaker around the modbus client
+func WithBreaker(breaker *wrapper.Breaker) ServiceOption {
+	return func(o *serviceOptions) {
+		o.breaker = breaker
+	}
+}
+
+// WithModbusStats reports the round-trip statistics of the modbus client
+func WithModbusStats(stats *wrapper.StatsClient) ServiceOption {
+	return func(o *serviceOptions) {
+		o.modbusStats = stats
+	}
+}
+
+// WithRequireIfMatch rejects writes which don't carry the ETag of the resource they change
+func WithRequireIfMatch(require bool) ServiceOption {
+	return func(o *serviceOptions) {
+		o.requireIfMatch = require
+	}
+}
+
+// WithTimeZone sets the time zone the controller's clock is kept in, the host's local time zone by default
+func WithTimeZone(location *time.Location) ServiceOption {
+	return func(o *serviceOptions) {
+		o.timeZone = location
+	}
+}
+
+// WithClock replaces the host's clock the controller's clock is compared with
+func WithClock(now func() time.Time) ServiceOption {
+	return func(o *serviceOptions) {
+		o.now = now
+	}
+}
+
+// WithClockSyncThreshold sets the drift beyond which the background clock sync corrects the controller's clock
+func WithClockSyncThreshold(threshold time.Duration) ServiceOption {
+	return func(o *serviceOptions) {
+		o.clockSyncThreshold = threshold
+	}
+}
+
+// WithHistoryStore makes the recorded values available, e.g. for tuning the heat curve
+func WithHistoryStore(store history.Store) ServiceOption {
+	return func(o *serviceOptions) {
+		o.historyStore = store
+	}
+}
+
+func newServiceOptions(opts []ServiceOption) serviceOptions {
+	options := serviceOptions{
+		timeZone:           time.Local,
+		now:                time.Now,
+		clockSyncThreshold: defaultClockSyncThreshold,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
 func handlePanic(panic any) (response openapi.ImplResponse, funcErr error) {
 	response = openapi.ImplResponse{}
 	if typedPanic, ok := panic.(error); ok {
@@ -36,20 +140,239 @@ func handlePanic(panic any) (response openapi.ImplResponse, funcErr error) {
 	return
 }
 
-func readPnu(c wrapper.ZeroBasedAddressClientWrapper, pnu uint16, quantity uint16) []byte {
-	if result, err := c.ReadHoldingRegisters(pnu, quantity); err == nil {
-		return result
-	} else {
-		panic(NewApiError(http.StatusBadGateway, fmt.Sprintf("Error reading PNU%d:%d", pnu, quantity), err))
+/*
+assertIfMatch fails with 412 if the ETag of the resource on the controller doesn't match
+the If-Match header. currentETag is only called if there is a header to compare to.
+*/
+func (o serviceOptions) assertIfMatch(ifMatch string, currentETag func() string) {
+	if ifMatch == "" {
+		if o.requireIfMatch {
+			panic(NewApiError(http.StatusPreconditionRequired, ErrPreconditionRequired, "If-Match header with the resource's ETag required", nil))
+		}
+		return
+	}
+	if current := currentETag(); !etag.Match(ifMatch, current) {
+		panic(NewApiError(http.StatusPreconditionFailed, ErrPreconditionFailed, fmt.Sprintf("The values on the controller changed, current ETag is %s", current), nil))
+	}
+}
+
+// contextClient logs and traces every modbus call made for the request of ctx
+func contextClient(ctx context.Context, c wrapper.ZeroBasedAddressClientWrapper) wrapper.ZeroBasedAddressClientWrapper {
+	return wrapper.NewTracingClient(wrapper.NewLoggingClient(c, logging.FromContext(ctx)), ctx)
+}
+
+// readPnus reads all ranges and returns their registers in the same order
+func readPnus(c wrapper.ZeroBasedAddressClientWrapper, planner *wrapper.ReadPlanner, ranges ...wrapper.ReadRange) []codec.Registers {
+	results, err := planner.Read(c, ranges...)
+	if err != nil {
+		var readErr *wrapper.ReadError
+		if errors.As(err, &readErr) {
+			panic(NewReadError(readErr.Address, readErr.Quantity, readErr.Err))
+		}
+		panic(err)
+	}
+	registers := make([]codec.Registers, len(ranges))
+	for i, r := range ranges {
+		registers[i] = toRegisters(r.Address, r.Quantity, results[i])
+	}
+	return registers
+}
+
+func toRegisters(pnu uint16, quantity uint16, result []byte) codec.Registers {
+	registers := codec.Registers(result)
+	if registers.Len() < int(quantity) {
+		err := NewApiError(http.StatusBadGateway, ErrInvalidControllerData, fmt.Sprintf("Short response reading PNU%d:%d, got %d registers", pnu, quantity, registers.Len()), nil)
+		err.Pnu = pnu
+		panic(err)
+	}
+	return registers
+}
+
+// mustEncode takes the result of a codec encoder for a value which has been validated before
+func mustEncode(value uint16, err error) uint16 {
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+/*
+A transaction groups the writes of a single operation. The original values of all
+touched PNUs are read before anything is written, so a failing write can be undone by
+restoring the snapshot of the PNUs written so far. Snapshot, commit and rollback are
+traced as spans of their own.
+*/
+type pnuTransaction struct {
+	ctx       context.Context
+	client    wrapper.ZeroBasedAddressClientWrapper
+	options   serviceOptions
+	circuitNo int32
+	updates   []PnuUpdate
+	// circuit of each queued PNU for the audit log
+	circuits map[uint16]int32
+	// PNUs the controller recalculates on a write of another PNU, by the PNU they depend on
+	recalculated map[uint16]uint16
+}
+
+// The circuit number is only used for the audit log, 0 if the PNUs don't belong to a circuit.
+func newPnuTransaction(ctx context.Context, c wrapper.ZeroBasedAddressClientWrapper, options serviceOptions, circuitNo int32) *pnuTransaction {
+	return &pnuTransaction{
+		ctx:          ctx,
+		client:       c,
+		options:      options,
+		circuitNo:    circuitNo,
+		circuits:     map[uint16]int32{},
+		recalculated: map[uint16]uint16{},
+	}
+}
+
+// forCircuit attributes the writes queued from now on to another circuit, 0 for none
+func (t *pnuTransaction) forCircuit(circuitNo int32) {
+	t.circuitNo = circuitNo
+}
+
+// update queues a write, the writes are applied in the order they were queued
+func (t *pnuTransaction) update(pnu uint16, newValue uint16, label string) {
+	t.updates = append(t.updates, PnuUpdate{Pnu: pnu, Label: label, NewValue: newValue})
+	t.circuits[pnu] = t.circuitNo
+}
+
+/*
+recalculatedBy declares that the controller recalculates the PNUs when the trigger PNU is
+written. Their queued writes are then applied even if they match the snapshot, which was
+taken before the trigger was written. They must be queued after the trigger.
+*/
+func (t *pnuTransaction) recalculatedBy(trigger uint16, pnus ...uint16) {
+	for _, pnu := range pnus {
+		t.recalculated[pnu] = trigger
+	}
+}
+
+func (t *pnuTransaction) snapshot() {
+	ranges := make([]wrapper.ReadRange, len(t.updates))
+	for i, u := range t.updates {
+		ranges[i] = wrapper.ReadRange{Address: u.Pnu, Quantity: 1}
+	}
+	ctx, span := tracing.Tracer().Start(t.ctx, "transaction.snapshot", trace.WithAttributes(attribute.Int("pnus", len(ranges))))
+	defer span.End()
+	for i, registers := range readPnus(contextClient(ctx, t.client), t.options.readPlanner, ranges...) {
+		t.updates[i].OldValue = registers.Uint16(0)
 	}
 }
 
-func updateSinglePnu(c wrapper.ZeroBasedAddressClientWrapper, pnu uint16, newValue uint16, label string) {
-	oldValue := binary.BigEndian.Uint16(readPnu(c, pnu, 1))
-	if oldValue != newValue {
-		log.Printf("Updating %s: PNU%d:1 %d -> %d\n", label, pnu, oldValue, newValue)
-		if _, err := c.WriteSingleRegister(pnu, newValue); err != nil {
-			panic(NewApiError(http.StatusBadGateway, fmt.Sprintf("Error writing %s PNU%d=%d", label, pnu, newValue), err))
+// diff reads the current values of all queued PNUs and returns the writes which would change them,
+// including the recalculated PNUs whose trigger changes
+func (t *pnuTransaction) diff() []PnuUpdate {
+	t.snapshot()
+	changes := []PnuUpdate{}
+	changed := map[uint16]bool{}
+	for _, u := range t.updates {
+		trigger, recalculated := t.recalculated[u.Pnu]
+		if u.OldValue != u.NewValue || recalculated && changed[trigger] {
+			changes = append(changes, u)
+			changed[u.Pnu] = true
 		}
 	}
+	return changes
+}
+
+// commit writes the changed PNUs and returns them
+func (t *pnuTransaction) commit() []PnuUpdate {
+	changes := t.diff()
+	ctx, span := tracing.Tracer().Start(t.ctx, "transaction.commit", trace.WithAttributes(attribute.Int("writes", len(changes))))
+	defer span.End()
+	client := contextClient(ctx, t.client)
+	applied := []PnuUpdate{}
+	for _, u := range changes {
+		logging.FromContext(t.ctx).Info("Updating PNU", "label", u.Label, "pnu", u.Pnu, "old", u.OldValue, "new", u.NewValue)
+		if _, err := client.WriteSingleRegister(u.Pnu, u.NewValue); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			t.record(u.Pnu, u.Label, u.OldValue, u.NewValue, audit.Failed, err)
+			panic(t.rollback(applied, u, err))
+		}
+		t.record(u.Pnu, u.Label, u.OldValue, u.NewValue, audit.Ok, nil)
+		applied = append(applied, u)
+	}
+	return applied
+}
+
+// rollback restores the original values of the applied writes in reverse order
+func (t *pnuTransaction) rollback(applied []PnuUpdate, failed PnuUpdate, cause error) *TransactionError {
+	ctx, span := tracing.Tracer().Start(t.ctx, "transaction.rollback", trace.WithAttributes(attribute.Int("writes", len(applied))))
+	defer span.End()
+	client := contextClient(ctx, t.client)
+	rolledBack := []PnuUpdate{}
+	inconsistent := []PnuUpdate{}
+	for i := len(applied) - 1; i >= 0; i-- {
+		u := applied[i]
+		logging.FromContext(t.ctx).Info("Rolling back PNU", "label", u.Label, "pnu", u.Pnu, "old", u.NewValue, "new", u.OldValue)
+		if _, err := client.WriteSingleRegister(u.Pnu, u.OldValue); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			logging.FromContext(t.ctx).Error("Error rolling back PNU", "label", u.Label, "pnu", u.Pnu, "value", u.OldValue, "error", err)
+			t.record(u.Pnu, u.Label, u.NewValue, u.OldValue, audit.RollbackFailed, err)
+			inconsistent = append(inconsistent, u)
+		} else {
+			t.record(u.Pnu, u.Label, u.NewValue, u.OldValue, audit.RolledBack, nil)
+			rolledBack = append(rolledBack, u)
+		}
+	}
+	return NewTransactionError(
+		fmt.Sprintf("Error writing %s PNU%d=%d", failed.Label, failed.Pnu, failed.NewValue),
+		failed,
+		applied,
+		rolledBack,
+		inconsistent,
+		cause,
+	)
+}
+
+func (t *pnuTransaction) record(pnu uint16, label string, oldValue uint16, newValue uint16, result audit.Result, err error) {
+	if t.options.auditLog == nil {
+		return
+	}
+	entry := audit.Entry{
+		Time:      t.options.now(),
+		Principal: audit.Principal(t.ctx),
+		Endpoint:  audit.Endpoint(t.ctx),
+		Circuit:   t.circuits[pnu],
+		Pnu:       pnu,
+		Label:     label,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		Result:    result,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if auditErr := t.options.auditLog.Append(entry); auditErr != nil {
+		logging.FromContext(t.ctx).Error("Error writing audit log entry", "entry", entry, "error", auditErr)
+	}
+}
+
+func toPnuChanges(updates []PnuUpdate) []openapi.PnuChange {
+	changes := make([]openapi.PnuChange, len(updates))
+	for i, update := range updates {
+		changes[i] = openapi.PnuChange{
+			Pnu:      int32(update.Pnu),
+			Label:    update.Label,
+			OldValue: int32(update.OldValue),
+			NewValue: int32(update.NewValue),
+		}
+	}
+	return changes
+}
+
+func dryRunResponse(changes []PnuUpdate) openapi.ImplResponse {
+	body := openapi.DryRunResponse{
+		Changes: toPnuChanges(changes),
+	}
+	return openapi.Response(http.StatusOK, body)
+}
+
+func assertValidCircuit(circuitNo int32) {
+	if circuitNo < 1 || circuitNo > 3 {
+		panic(NewValidationError(ErrInvalidCircuit, "circuitNo", fmt.Sprintf("Invalid circuit number %d, not in [1,3]", circuitNo)))
+	}
 }
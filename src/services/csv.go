@@ -0,0 +1,68 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+readCSVColumns returns the values of the columns named by the header line in the order of
+columns, together with the line number of each row. Other columns are ignored, the names
+are matched case-insensitive.
+*/
+func readCSVColumns(text string, columns ...string) (rows [][]string, lines []int, err error) {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid CSV header: %w", err)
+	}
+	indexes := make([]int, len(columns))
+	for i, column := range columns {
+		indexes[i] = -1
+		for j, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				indexes[i] = j
+			}
+		}
+		if indexes[i] < 0 {
+			return nil, nil, fmt.Errorf("CSV header without column %s", column)
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, lines, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		row := make([]string, len(columns))
+		for i, index := range indexes {
+			row[i] = strings.TrimSpace(record[index])
+		}
+		rows = append(rows, row)
+		lines = append(lines, line)
+	}
+}
@@ -16,6 +16,8 @@ ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
 
 package api
 
+import "github.com/treblada/ecl310-rest/codec"
+
 type CircuitMode uint16
 
 const (
@@ -26,12 +28,10 @@ const (
 	FrostProtection
 )
 
-var circuitModeNames = []string{"MANUAL", "SCHEDULED", "CONSTANT_COMFORT_TEMP", "CONSTANT_SETBACK_TEMP", "FROST_PROTECTION"}
-
-// var circuitModeEnums = []CircuitMode{Manual, Scheduled, ConstantComfortTemp, ConstantSetbackTemp, FrostProtection}
+var circuitModes = codec.Enum{"MANUAL", "SCHEDULED", "CONSTANT_COMFORT_TEMP", "CONSTANT_SETBACK_TEMP", "FROST_PROTECTION"}
 
 func (m CircuitMode) String() string {
-	return circuitModeNames[m]
+	return circuitModes.Name(uint16(m))
 }
 
 func GetCircuitMode(i uint16) CircuitMode {
@@ -47,10 +47,10 @@ const (
 	PreSetback
 )
 
-var circuitStateNames = []string{"SETBACK", "PRE_COMFORT", "COMFORT", "PRE_SETBACK"}
+var circuitStates = codec.Enum{"SETBACK", "PRE_COMFORT", "COMFORT", "PRE_SETBACK"}
 
 func (s CircuitState) String() string {
-	return circuitStateNames[s]
+	return circuitStates.Name(uint16(s))
 }
 
 func GetCircuitState(i uint16) CircuitState {
@@ -59,15 +59,16 @@ func GetCircuitState(i uint16) CircuitState {
 
 type AddressType uint16
 
+// PNU 258 holds 0 for an address assigned by DHCP
 const (
-	Static AddressType = iota
-	DHCP
+	DHCP AddressType = iota
+	Static
 )
 
-var addressTypeNames = []string{"STATIC", "DHCP"}
+var addressTypes = codec.Enum{"DHCP", "STATIC"}
 
 func (t AddressType) String() string {
-	return addressTypeNames[t]
+	return addressTypes.Name(uint16(t))
 }
 
 func GetAddressType(i uint16) AddressType {
@@ -17,25 +17,144 @@ ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
 package api
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 
 	"github.com/goburrow/modbus"
 	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/logging"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
 )
 
+const problemTypePrefix = "urn:ecl310-rest:error:"
+
+// Seconds a client should wait before retrying when the controller reported to be busy
+const controllerBusyRetryAfter = 5
+
+// ApiErrorHandler renders all errors as RFC 7807 problem details
 func ApiErrorHandler(w http.ResponseWriter, r *http.Request, err error, result *openapi.ImplResponse) {
-	if _, ok := err.(*net.OpError); ok {
-		// Network connection error
-		openapi.EncodeJSONResponse(err.Error(), func(i int) *int { return &i }(http.StatusBadGateway), w)
-	} else if _, ok := err.(*modbus.ModbusError); ok {
-		// Modbus communication error
-		openapi.EncodeJSONResponse(err.Error(), func(i int) *int { return &i }(http.StatusBadGateway), w)
+	problem := toProblem(err)
+	logger := logging.FromContext(r.Context())
+	if problem.Status >= http.StatusInternalServerError {
+		logger.Error("Request failed", "method", r.Method, "path", r.URL.Path, "code", problem.Code, "error", err)
+	} else {
+		logger.Warn("Request rejected", "method", r.Method, "path", r.URL.Path, "code", problem.Code, "error", err)
+	}
+	problem.Instance = r.URL.Path
+	if seconds, ok := retryAfter(err); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	WriteProblem(w, problem)
+}
+
+func toProblem(err error) openapi.Problem {
+	if typedErr, ok := err.(*TransactionError); ok {
+		problem := apiErrorProblem(&typedErr.ApiError)
+		problem.Applied = toPnuChanges(typedErr.Applied)
+		problem.RolledBack = toPnuChanges(typedErr.RolledBack)
+		problem.Inconsistent = toPnuChanges(typedErr.Inconsistent)
+		return problem
 	} else if typedErr, ok := err.(*ApiError); ok {
-		log.Printf("%v\n", err)
-		openapi.EncodeJSONResponse(typedErr.Message, &typedErr.Code, w)
+		return apiErrorProblem(typedErr)
+	} else if typedErr, ok := err.(*openapi.ParsingError); ok {
+		return newProblem(http.StatusBadRequest, ErrMalformedRequest, typedErr.Error(), nil)
+	} else if typedErr, ok := err.(*openapi.RequiredError); ok {
+		problem := newProblem(http.StatusUnprocessableEntity, ErrMissingField, typedErr.Error(), nil)
+		problem.Field = typedErr.Field
+		return problem
+	} else if failure, ok := classifyControllerFailure(err); ok {
+		return newProblem(failure.status, failure.errorCode, failure.detail, err)
 	} else {
-		openapi.DefaultErrorHandler(w, r, err, result)
+		return newProblem(http.StatusInternalServerError, ErrInternal, "Internal error", err)
+	}
+}
+
+func newProblem(status int, errorCode ErrorCode, detail string, cause error) openapi.Problem {
+	problem := openapi.Problem{
+		Type:   problemTypePrefix + string(errorCode),
+		Title:  http.StatusText(status),
+		Status: int32(status),
+		Detail: detail,
+		Code:   string(errorCode),
+	}
+	if cause != nil {
+		problem.Cause = cause.Error()
+	}
+	return problem
+}
+
+func apiErrorProblem(err *ApiError) openapi.Problem {
+	errorCode := err.ErrorCode
+	if errorCode == "" {
+		errorCode = ErrInternal
 	}
+	status := err.Code
+	// Failures of the controller more specific than a bad gateway take precedence
+	if failure, ok := classifyControllerFailure(err.Cause); ok && failure.status != http.StatusBadGateway {
+		status = failure.status
+		errorCode = failure.errorCode
+	}
+	problem := newProblem(status, errorCode, err.Message, err.Cause)
+	problem.Field = err.Field
+	problem.Pnu = int32(err.Pnu)
+	return problem
+}
+
+type controllerFailure struct {
+	status    int
+	errorCode ErrorCode
+	detail    string
+}
+
+/*
+classifyControllerFailure maps errors of the communication with the controller onto HTTP
+statuses, letting clients tell a request rejected by the ECL310 from an unavailable one.
+*/
+func classifyControllerFailure(err error) (controllerFailure, bool) {
+	var breakerErr *wrapper.BreakerOpenError
+	var modbusErr *modbus.ModbusError
+	var netErr net.Error
+	if errors.As(err, &breakerErr) {
+		return controllerFailure{http.StatusServiceUnavailable, ErrControllerUnavailable, "ECL310 unavailable after repeated failures"}, true
+	} else if errors.As(err, &modbusErr) {
+		switch modbusErr.ExceptionCode {
+		case modbus.ExceptionCodeIllegalDataAddress:
+			return controllerFailure{http.StatusNotImplemented, ErrPnuNotSupported, "PNU not supported by the ECL310"}, true
+		case modbus.ExceptionCodeIllegalDataValue:
+			return controllerFailure{http.StatusUnprocessableEntity, ErrValueRejected, "Value rejected by the ECL310"}, true
+		case modbus.ExceptionCodeServerDeviceBusy:
+			return controllerFailure{http.StatusServiceUnavailable, ErrControllerBusy, "ECL310 busy"}, true
+		case modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond:
+			return controllerFailure{http.StatusGatewayTimeout, ErrControllerTimeout, "ECL310 did not respond"}, true
+		default:
+			return controllerFailure{http.StatusBadGateway, ErrModbusException, "ECL310 rejected the request"}, true
+		}
+	} else if errors.As(err, &netErr) && netErr.Timeout() {
+		return controllerFailure{http.StatusGatewayTimeout, ErrControllerTimeout, "ECL310 did not respond in time"}, true
+	} else if errors.As(err, &netErr) {
+		return controllerFailure{http.StatusBadGateway, ErrControllerUnreachable, "ECL310 not reachable"}, true
+	}
+	return controllerFailure{}, false
+}
+
+// retryAfter returns the seconds a client should wait before retrying, if the failure is temporary
+func retryAfter(err error) (int, bool) {
+	var breakerErr *wrapper.BreakerOpenError
+	var modbusErr *modbus.ModbusError
+	if errors.As(err, &breakerErr) {
+		return int(math.Ceil(breakerErr.RetryAfter.Seconds())), true
+	} else if errors.As(err, &modbusErr) && modbusErr.ExceptionCode == modbus.ExceptionCodeServerDeviceBusy {
+		return controllerBusyRetryAfter, true
+	}
+	return 0, false
+}
+
+func WriteProblem(w http.ResponseWriter, problem openapi.Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(int(problem.Status))
+	json.NewEncoder(w).Encode(problem)
 }
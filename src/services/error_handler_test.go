@@ -0,0 +1,134 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
+	api "github.com/treblada/ecl310-rest/services"
+	"gotest.tools/v3/assert"
+)
+
+func handleError(t *testing.T, err error) (*httptest.ResponseRecorder, openapi.Problem) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/heatcurve/4/slope", nil)
+	api.ApiErrorHandler(recorder, request, err, nil)
+	var problem openapi.Problem
+	assert.NilError(t, json.NewDecoder(recorder.Body).Decode(&problem))
+	return recorder, problem
+}
+
+func TestApiErrorHandler__validationError(t *testing.T) {
+	recorder, problem := handleError(t, api.NewValidationError(api.ErrInvalidCircuit, "circuitNo", "Invalid circuit number 4"))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "application/problem+json", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "urn:ecl310-rest:error:INVALID_CIRCUIT", problem.Type)
+	assert.Equal(t, "INVALID_CIRCUIT", problem.Code)
+	assert.Equal(t, "circuitNo", problem.Field)
+	assert.Equal(t, "/heatcurve/4/slope", problem.Instance)
+}
+
+func TestApiErrorHandler__transactionError(t *testing.T) {
+	failed := api.PnuUpdate{Pnu: 11176, Label: "maxFlowTemp", OldValue: 90, NewValue: 80}
+	applied := []api.PnuUpdate{{Pnu: 11175, Label: "slope", OldValue: 12, NewValue: 15}}
+	err := api.NewTransactionError("Error writing PNU11176", failed, applied, applied, nil, errors.New("timeout"))
+	recorder, problem := handleError(t, err)
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	assert.Equal(t, "CONTROLLER_WRITE_FAILED", problem.Code)
+	assert.Equal(t, int32(11176), problem.Pnu)
+	assert.Equal(t, 1, len(problem.RolledBack))
+	assert.Equal(t, 0, len(problem.Inconsistent))
+}
+
+func TestApiErrorHandler__missingField(t *testing.T) {
+	recorder, problem := handleError(t, &openapi.RequiredError{Field: "slope"})
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	assert.Equal(t, "MISSING_FIELD", problem.Code)
+	assert.Equal(t, "slope", problem.Field)
+}
+
+func TestApiErrorHandler__unknownError(t *testing.T) {
+	recorder, problem := handleError(t, errors.New("boom"))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, "INTERNAL_ERROR", problem.Code)
+}
+
+func TestApiErrorHandler__modbusExceptions(t *testing.T) {
+	tests := []struct {
+		exceptionCode byte
+		status        int
+		code          string
+	}{
+		{modbus.ExceptionCodeIllegalDataAddress, http.StatusNotImplemented, "PNU_NOT_SUPPORTED"},
+		{modbus.ExceptionCodeIllegalDataValue, http.StatusUnprocessableEntity, "VALUE_REJECTED"},
+		{modbus.ExceptionCodeServerDeviceBusy, http.StatusServiceUnavailable, "CONTROLLER_BUSY"},
+		{modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond, http.StatusGatewayTimeout, "CONTROLLER_TIMEOUT"},
+		{modbus.ExceptionCodeServerDeviceFailure, http.StatusBadGateway, "CONTROLLER_READ_FAILED"},
+	}
+	for _, test := range tests {
+		cause := &modbus.ModbusError{FunctionCode: 3, ExceptionCode: test.exceptionCode}
+		recorder, problem := handleError(t, api.NewReadError(11175, 1, cause))
+		assert.Equal(t, test.status, recorder.Code)
+		assert.Equal(t, test.code, problem.Code)
+		assert.Equal(t, int32(11175), problem.Pnu)
+	}
+}
+
+func TestApiErrorHandler__retryAfterWhenBusy(t *testing.T) {
+	failed := api.PnuUpdate{Pnu: 11176, Label: "maxFlowTemp", OldValue: 90, NewValue: 80}
+	cause := &modbus.ModbusError{FunctionCode: 6, ExceptionCode: modbus.ExceptionCodeServerDeviceBusy}
+	recorder, problem := handleError(t, api.NewTransactionError("Error writing PNU11176", failed, nil, nil, nil, cause))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "CONTROLLER_BUSY", problem.Code)
+	assert.Equal(t, "5", recorder.Header().Get("Retry-After"))
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestApiErrorHandler__timeout(t *testing.T) {
+	cause := &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}
+	recorder, problem := handleError(t, api.NewReadError(258, 1, cause))
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Equal(t, "CONTROLLER_TIMEOUT", problem.Code)
+}
+
+func TestApiErrorHandler__unreachable(t *testing.T) {
+	recorder, problem := handleError(t, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	assert.Equal(t, "CONTROLLER_UNREACHABLE", problem.Code)
+}
+
+func TestApiErrorHandler__breakerOpen(t *testing.T) {
+	cause := &wrapper.BreakerOpenError{Err: errors.New("connection refused"), RetryAfter: 12500 * time.Millisecond}
+	recorder, problem := handleError(t, api.NewReadError(4201, 2, cause))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "CONTROLLER_UNAVAILABLE", problem.Code)
+	assert.Equal(t, "13", recorder.Header().Get("Retry-After"))
+}
@@ -16,13 +16,50 @@ ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
 
 package api
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+)
+
+// Stable machine-readable error codes, documented in the OpenAPI spec
+type ErrorCode string
+
+const (
+	ErrMalformedRequest      ErrorCode = "MALFORMED_REQUEST"
+	ErrMissingField          ErrorCode = "MISSING_FIELD"
+	ErrInvalidCircuit        ErrorCode = "INVALID_CIRCUIT"
+	ErrValueOutOfRange       ErrorCode = "VALUE_OUT_OF_RANGE"
+	ErrInvalidDate           ErrorCode = "INVALID_DATE"
+	ErrInvalidFormat         ErrorCode = "INVALID_FORMAT"
+	ErrFeatureDisabled       ErrorCode = "FEATURE_DISABLED"
+	ErrPreconditionFailed    ErrorCode = "PRECONDITION_FAILED"
+	ErrPreconditionRequired  ErrorCode = "PRECONDITION_REQUIRED"
+	ErrInsufficientData      ErrorCode = "INSUFFICIENT_DATA"
+	ErrIncompatibleCurve     ErrorCode = "INCOMPATIBLE_CURVE"
+	ErrIncompatibleBackup    ErrorCode = "INCOMPATIBLE_BACKUP"
+	ErrControllerUnreachable ErrorCode = "CONTROLLER_UNREACHABLE"
+	ErrControllerReadFailed  ErrorCode = "CONTROLLER_READ_FAILED"
+	ErrControllerWriteFailed ErrorCode = "CONTROLLER_WRITE_FAILED"
+	ErrModbusException       ErrorCode = "MODBUS_EXCEPTION"
+	ErrPnuNotSupported       ErrorCode = "PNU_NOT_SUPPORTED"
+	ErrValueRejected         ErrorCode = "VALUE_REJECTED"
+	ErrControllerBusy        ErrorCode = "CONTROLLER_BUSY"
+	ErrControllerTimeout     ErrorCode = "CONTROLLER_TIMEOUT"
+	ErrControllerUnavailable ErrorCode = "CONTROLLER_UNAVAILABLE"
+	ErrInvalidControllerData ErrorCode = "INVALID_CONTROLLER_DATA"
+	ErrInternal              ErrorCode = "INTERNAL_ERROR"
+)
 
 type ApiError struct {
 	error
-	Code    int
-	Message string
-	Cause   error
+	Code      int
+	ErrorCode ErrorCode
+	Message   string
+	// Request field the error refers to, if any
+	Field string
+	// PNU the error refers to, 0 if none
+	Pnu   uint16
+	Cause error
 }
 
 func (err *ApiError) Error() string {
@@ -33,6 +70,70 @@ func (err *ApiError) Error() string {
 	return fmt.Sprintf("HTTP %d; %s%s", err.Code, err.Message, causeMessage)
 }
 
-func NewApiError(code int, message string, cause error) *ApiError {
-	return &ApiError{Code: code, Message: message, Cause: cause}
+func (err *ApiError) Unwrap() error {
+	return err.Cause
+}
+
+func NewApiError(code int, errorCode ErrorCode, message string, cause error) *ApiError {
+	return &ApiError{Code: code, ErrorCode: errorCode, Message: message, Cause: cause}
+}
+
+// NewValidationError reports an invalid request field
+func NewValidationError(errorCode ErrorCode, field string, message string) *ApiError {
+	return &ApiError{Code: http.StatusBadRequest, ErrorCode: errorCode, Message: message, Field: field}
+}
+
+// newUnprocessableError reports a valid request which doesn't fit the controller
+func newUnprocessableError(errorCode ErrorCode, field string, message string) *ApiError {
+	return &ApiError{Code: http.StatusUnprocessableEntity, ErrorCode: errorCode, Message: message, Field: field}
+}
+
+func NewReadError(pnu uint16, quantity uint16, cause error) *ApiError {
+	return &ApiError{
+		Code:      http.StatusBadGateway,
+		ErrorCode: ErrControllerReadFailed,
+		Message:   fmt.Sprintf("Error reading PNU%d:%d", pnu, quantity),
+		Pnu:       pnu,
+		Cause:     cause,
+	}
+}
+
+type PnuUpdate struct {
+	Pnu      uint16
+	Label    string
+	OldValue uint16
+	NewValue uint16
+}
+
+/*
+A transaction error reports a failed multi-PNU write. Applied lists the writes that
+succeeded before the failure, RolledBack those which have been restored afterwards and
+Inconsistent those which could not be restored and still hold the new value.
+*/
+type TransactionError struct {
+	ApiError
+	Failed       PnuUpdate
+	Applied      []PnuUpdate
+	RolledBack   []PnuUpdate
+	Inconsistent []PnuUpdate
+}
+
+func (err *TransactionError) Error() string {
+	return fmt.Sprintf("%s; %d applied, %d rolled back, %d inconsistent", err.ApiError.Error(), len(err.Applied), len(err.RolledBack), len(err.Inconsistent))
+}
+
+func NewTransactionError(message string, failed PnuUpdate, applied, rolledBack, inconsistent []PnuUpdate, cause error) *TransactionError {
+	return &TransactionError{
+		ApiError: ApiError{
+			Code:      http.StatusBadGateway,
+			ErrorCode: ErrControllerWriteFailed,
+			Message:   message,
+			Pnu:       failed.Pnu,
+			Cause:     cause,
+		},
+		Failed:       failed,
+		Applied:      applied,
+		RolledBack:   rolledBack,
+		Inconsistent: inconsistent,
+	}
 }
@@ -18,38 +18,156 @@ package api
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/logging"
 	wrapper "github.com/treblada/ecl310-rest/modbus"
 )
 
 type HealthApiService struct {
 	openapi.HealthApiService
+	serviceOptions
 	client wrapper.ZeroBasedAddressClientWrapper
 }
 
-func NewHealthApiService(client wrapper.ZeroBasedAddressClientWrapper) openapi.HealthApiServicer {
+func NewHealthApiService(client wrapper.ZeroBasedAddressClientWrapper, opts ...ServiceOption) openapi.HealthApiServicer {
 	if client == nil {
 		panic("No modbus client provided for health API service")
 	}
 	return &HealthApiService{
-		client: client,
+		serviceOptions: newServiceOptions(opts),
+		client:         client,
 	}
 }
 
 func (s *HealthApiService) GetHealth(ctx context.Context) (openapi.ImplResponse, error) {
 	status := "OK"
-	_, err := s.client.ReadHoldingRegisters(278, 4)
+	_, err := contextClient(ctx, s.client).ReadHoldingRegisters(278, 4)
 
 	if err != nil {
-		log.Printf("ECL host not reachable: %v", err)
+		logging.FromContext(ctx).Warn("ECL host not reachable", "error", err)
 		status = "FAIL"
 	}
 
 	body := openapi.GetHealthResponse{
 		Status: status,
 	}
+	if stats, ok := s.client.(wrapper.ReadStats); ok {
+		body.SavedReads = int64(stats.SavedReads())
+	}
+	if s.breaker != nil {
+		breakerStatus := s.breaker.Status()
+		body.BreakerState = breakerStatus.State.String()
+		body.BreakerFailures = int32(breakerStatus.ConsecutiveFailures)
+		body.BreakerTrips = int64(breakerStatus.Trips)
+		if breakerStatus.LastError != nil {
+			body.BreakerLastError = breakerStatus.LastError.Error()
+		}
+	}
+	return openapi.Response(http.StatusOK, body), nil
+}
+
+func (s *HealthApiService) GetHealthLive(ctx context.Context) (openapi.ImplResponse, error) {
+	return openapi.Response(http.StatusOK, openapi.HealthStatus{Status: "OK"}), nil
+}
+
+func (s *HealthApiService) GetHealthReady(ctx context.Context) (openapi.ImplResponse, error) {
+	if _, err := contextClient(ctx, s.client).ReadHoldingRegisters(278, 4); err != nil {
+		logging.FromContext(ctx).Warn("Not ready, ECL host not reachable", "error", err)
+		return openapi.Response(http.StatusServiceUnavailable, openapi.HealthStatus{Status: "FAIL", Reason: err.Error()}), nil
+	}
+	return openapi.Response(http.StatusOK, openapi.HealthStatus{Status: "OK"}), nil
+}
+
+func (s *HealthApiService) GetHealthDetails(ctx context.Context) (openapi.ImplResponse, error) {
+	// read the clock first, so the statistics include this request
+	clock := s.clockDetails(ctx)
+	body := openapi.HealthDetails{
+		Status:  "OK",
+		Modbus:  s.modbusDetails(),
+		Breaker: s.breakerDetails(),
+		Clock:   clock,
+		Cache:   s.cacheDetails(),
+		Pollers: s.pollers.status(),
+	}
+	if body.Clock.Error != "" {
+		body.Status = "FAIL"
+	}
 	return openapi.Response(http.StatusOK, body), nil
 }
+
+func (s *HealthApiService) modbusDetails() openapi.ModbusStats {
+	details := openapi.ModbusStats{}
+	if stats, ok := s.client.(wrapper.ReadStats); ok {
+		details.SavedReads = int64(stats.SavedReads())
+	}
+	if s.modbusStats == nil {
+		return details
+	}
+	stats := s.modbusStats.Stats()
+	details.Requests = int64(stats.Requests)
+	details.Errors = int64(stats.Errors)
+	details.RecentErrorRate = stats.RecentErrorRate
+	details.LastLatencyMs = toMilliseconds(stats.LastLatency)
+	details.AverageLatencyMs = toMilliseconds(stats.AverageLatency)
+	details.LastSuccess = formatTime(stats.LastSuccess)
+	details.LastErrorTime = formatTime(stats.LastErrorTime)
+	if stats.LastError != nil {
+		details.LastError = stats.LastError.Error()
+	}
+	return details
+}
+
+func (s *HealthApiService) breakerDetails() openapi.BreakerStatus {
+	if s.breaker == nil {
+		return openapi.BreakerStatus{State: "DISABLED"}
+	}
+	status := s.breaker.Status()
+	details := openapi.BreakerStatus{
+		State:               status.State.String(),
+		ConsecutiveFailures: int32(status.ConsecutiveFailures),
+		Trips:               int64(status.Trips),
+	}
+	if status.LastError != nil {
+		details.LastError = status.LastError.Error()
+	}
+	return details
+}
+
+func (s *HealthApiService) cacheDetails() openapi.CacheStatus {
+	details := openapi.CacheStatus{RejectedBlocks: int32(s.readPlanner.RejectedBlocks())}
+	if stats, ok := s.client.(wrapper.ReadStats); ok {
+		details.SavedReads = int64(stats.SavedReads())
+	}
+	return details
+}
+
+// clockDetails compares the controller's clock with the host's like GET /system/datetime, the controller does not report seconds
+func (s *HealthApiService) clockDetails(ctx context.Context) (details openapi.ClockStatus) {
+	details.TimeZone = s.timeZone.String()
+	defer func() {
+		if panic := recover(); panic != nil {
+			details.Error = fmt.Sprint(panic)
+		}
+	}()
+	dateTime, _ := readDateTime(ctx, s.client, s.serviceOptions)
+	details.HostTime = formatTime(s.now().In(s.timeZone))
+	details.ControllerTime = formatTime(dateTime.Iso)
+	details.DriftSeconds = int64(dateTime.DriftSeconds)
+	return details
+}
+
+func toMilliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// formatTime returns an empty string for the zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
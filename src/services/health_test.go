@@ -20,12 +20,15 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"gotest.tools/v3/assert"
 
 	"github.com/treblada/ecl310-rest/generated/openapi"
 	"github.com/treblada/ecl310-rest/mocks"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
 	api "github.com/treblada/ecl310-rest/services"
 )
 
@@ -56,3 +59,116 @@ func TestHealth__error(t *testing.T) {
 	assert.Assert(t, ok)
 	assert.Equal(t, "FAIL", bodyContent.Status)
 }
+
+func TestHealth__savedReads(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			return make([]byte, quantity*2), nil
+		},
+	}
+	service := api.NewHealthApiService(wrapper.NewDedupClient(mock))
+	result, _ := service.GetHealth(context.TODO())
+	bodyContent, ok := result.Body.(openapi.GetHealthResponse)
+	assert.Assert(t, ok)
+	assert.Equal(t, "OK", bodyContent.Status)
+	assert.Equal(t, int64(0), bodyContent.SavedReads)
+}
+
+func TestHealth__breakerOpen(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			return nil, fmt.Errorf("Mocked error")
+		},
+	}
+	breaker := wrapper.NewBreaker(mock, 1, time.Minute)
+	service := api.NewHealthApiService(breaker, api.WithBreaker(breaker))
+	service.GetHealth(context.TODO())
+	result, _ := service.GetHealth(context.TODO())
+	bodyContent, ok := result.Body.(openapi.GetHealthResponse)
+	assert.Assert(t, ok)
+	assert.Equal(t, "FAIL", bodyContent.Status)
+	assert.Equal(t, "OPEN", bodyContent.BreakerState)
+	assert.Equal(t, int64(1), bodyContent.BreakerTrips)
+	assert.Equal(t, "Mocked error", bodyContent.BreakerLastError)
+	// the second check did not reach the controller
+	assert.Equal(t, 1, len(mock.Calls))
+}
+
+func TestHealthLive__success(t *testing.T) {
+	service := api.NewHealthApiService(&mocks.ClientMock{})
+	result, _ := service.GetHealthLive(context.TODO())
+	assert.Equal(t, http.StatusOK, result.Code)
+	// the controller is not contacted
+	assert.Equal(t, "OK", result.Body.(openapi.HealthStatus).Status)
+}
+
+func TestHealthReady__unreachable(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			return nil, fmt.Errorf("Mocked error")
+		},
+	}
+	service := api.NewHealthApiService(mock)
+	result, _ := service.GetHealthReady(context.TODO())
+	assert.Equal(t, http.StatusServiceUnavailable, result.Code)
+	body := result.Body.(openapi.HealthStatus)
+	assert.Equal(t, "FAIL", body.Status)
+	assert.Equal(t, "Mocked error", body.Reason)
+}
+
+func TestHealthDetails__success(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NilError(t, err)
+	hostTime := time.Date(2026, 7, 1, 10, 30, 20, 0, time.UTC)
+	// without automatic daylight saving the controller stays at standard time in summer
+	stats := wrapper.NewStatsClient(controllerMock(clockRegisters(2026, 7, 1, 11, 28, false)))
+	service := api.NewHealthApiService(stats, api.WithModbusStats(stats), api.WithTimeZone(berlin), api.WithClock(func() time.Time { return hostTime }))
+	result, _ := service.GetHealthDetails(context.TODO())
+	assert.Equal(t, http.StatusOK, result.Code)
+	body := result.Body.(openapi.HealthDetails)
+	assert.Equal(t, "OK", body.Status)
+	assert.Equal(t, "DISABLED", body.Breaker.State)
+	assert.Equal(t, int64(2), body.Modbus.Requests)
+	assert.Assert(t, body.Modbus.LastSuccess != "")
+	assert.Equal(t, "Europe/Berlin", body.Clock.TimeZone)
+	assert.Equal(t, "2026-07-01T11:28:00+01:00", body.Clock.ControllerTime)
+	assert.Equal(t, "2026-07-01T12:30:20+02:00", body.Clock.HostTime)
+	assert.Equal(t, int64(-140), body.Clock.DriftSeconds)
+	assert.Equal(t, 0, len(body.Pollers))
+}
+
+func TestHealthDetails__clockUnreadable(t *testing.T) {
+	service := api.NewHealthApiService(controllerMock(map[uint16]uint16{}))
+	result, _ := service.GetHealthDetails(context.TODO())
+	body := result.Body.(openapi.HealthDetails)
+	assert.Equal(t, "FAIL", body.Status)
+	assert.Assert(t, strings.Contains(body.Clock.Error, "PNU 64045 not mocked"), body.Clock.Error)
+}
+
+func TestHealthDetails__pollers(t *testing.T) {
+	registers := clockRegisters(2026, 3, 10, 10, 0, true)
+	hostTime := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NilError(t, err)
+	pollers := api.NewPollers()
+	options := []api.ServiceOption{api.WithTimeZone(berlin), api.WithClock(func() time.Time { return hostTime }), api.WithPollers(pollers)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api.StartClockSync(ctx, api.NewSystemApiService(controllerMock(registers), options...), time.Hour)
+
+	service := api.NewHealthApiService(controllerMock(registers), options...)
+	var body openapi.HealthDetails
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		result, _ := service.GetHealthDetails(context.TODO())
+		body = result.Body.(openapi.HealthDetails)
+		if len(body.Pollers) == 1 && body.Pollers[0].LastPoll != "" {
+			break
+		}
+	}
+	assertDeepEqual(t, body.Pollers, []openapi.PollerStatus{{
+		Name:            "clock sync",
+		IntervalSeconds: 3600,
+		LastPoll:        "2026-03-10T09:00:00Z",
+		LastSuccess:     "2026-03-10T09:00:00Z",
+	}})
+}
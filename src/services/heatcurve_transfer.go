@@ -0,0 +1,188 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/treblada/ecl310-rest/etag"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
+)
+
+// The only curve model the gateway knows, the one of getHeatCurve
+const heatCurveModel = "ECL310_6_POINTS"
+
+// Application types with weather compensated heating circuits, they use the curve of heatCurveModel
+var heatCurveApplications = []string{"A230", "A231", "A232", "A237", "A247", "A260", "A266", "A275", "A333", "A361", "A367", "A368", "A376", "A377"}
+
+func (s *HeatingApiService) ExportHeatCurve(ctx context.Context, circuitNo int32, format string) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	assertValidCircuit(circuitNo)
+	if format != "" && format != "json" && format != "csv" {
+		panic(NewValidationError(ErrValueOutOfRange, "format", fmt.Sprintf("Invalid format %q, must be json or csv", format)))
+	}
+
+	curve, tag := s.readHeatCurve(ctx, circuitNo)
+	body := openapi.HeatCurveDocument{
+		Model:                heatCurveModel,
+		Application:          s.readApplication(ctx),
+		CircuitNo:            circuitNo,
+		ExportedAt:           s.now().UTC().Truncate(time.Second),
+		MinFlowTemp:          &curve.MinFlowTemp,
+		MaxFlowTemp:          &curve.MaxFlowTemp,
+		ParallelDisplacement: &curve.ParallelDisplacement,
+	}
+	if format == "csv" {
+		body.Csv = formatCurvePointsCSV(curve.CurvePoints)
+	} else {
+		body.CurvePoints = curve.CurvePoints
+	}
+	etag.Set(ctx, tag)
+	return openapi.Response(http.StatusOK, body), nil
+}
+
+// ImportHeatCurve applies the document with the validation of SetHeatCurveByPoints
+func (s *HeatingApiService) ImportHeatCurve(ctx context.Context, circuitNo int32, document openapi.HeatCurveDocument, dryRun bool, ifMatch string) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	assertValidCircuit(circuitNo)
+	if document.Model != heatCurveModel {
+		panic(newUnprocessableError(ErrIncompatibleCurve, "model", fmt.Sprintf("Unsupported curve model %q, expected %s", document.Model, heatCurveModel)))
+	}
+	values := openapi.SetHeatCurveByPointsRequest{
+		MinFlowTemp:          document.MinFlowTemp,
+		MaxFlowTemp:          document.MaxFlowTemp,
+		ParallelDisplacement: document.ParallelDisplacement,
+		CurvePoints:          importedCurvePoints(document),
+	}
+	// the circuits and their curves depend on the application
+	application := s.readApplication(ctx)
+	assertSupportsHeatCurve(application)
+	if document.Application != "" && applicationType(application) != applicationType(document.Application) {
+		panic(newUnprocessableError(ErrIncompatibleCurve, "application", fmt.Sprintf("Curve of application %s doesn't fit the controller's application %s", document.Application, application)))
+	}
+	return s.SetHeatCurveByPoints(ctx, circuitNo, values, dryRun, ifMatch)
+}
+
+func (s *HeatingApiService) CopyHeatCurve(ctx context.Context, circuitNo int32, targetCircuitNo int32, dryRun bool, ifMatch string) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	assertValidCircuit(circuitNo)
+	if targetCircuitNo < 1 || targetCircuitNo > 3 {
+		panic(NewValidationError(ErrInvalidCircuit, "targetCircuitNo", fmt.Sprintf("Invalid circuit number %d, not in [1,3]", targetCircuitNo)))
+	}
+	if targetCircuitNo == circuitNo {
+		panic(NewValidationError(ErrInvalidCircuit, "targetCircuitNo", fmt.Sprintf("Can't copy the curve of circuit %d onto itself", circuitNo)))
+	}
+
+	assertSupportsHeatCurve(s.readApplication(ctx))
+	curve, _ := s.readHeatCurve(ctx, circuitNo)
+	values := openapi.SetHeatCurveByPointsRequest{
+		MinFlowTemp:          &curve.MinFlowTemp,
+		MaxFlowTemp:          &curve.MaxFlowTemp,
+		ParallelDisplacement: &curve.ParallelDisplacement,
+		CurvePoints:          curve.CurvePoints,
+	}
+	return s.SetHeatCurveByPoints(ctx, targetCircuitNo, values, dryRun, ifMatch)
+}
+
+func (s *HeatingApiService) readApplication(ctx context.Context) string {
+	registers := readPnus(contextClient(ctx, s.client), s.readPlanner, wrapper.ReadRange{Address: 2060, Quantity: 4})
+	return decodeApplicationName(registers[0])
+}
+
+func assertSupportsHeatCurve(application string) {
+	if !slices.Contains(heatCurveApplications, applicationType(application)) {
+		panic(newUnprocessableError(ErrIncompatibleCurve, "application", fmt.Sprintf("The controller's application %s doesn't support the curve model %s", application, heatCurveModel)))
+	}
+}
+
+// applicationType strips the subtype, e.g. A266 of A266.1
+func applicationType(application string) string {
+	applicationType, _, _ := strings.Cut(application, ".")
+	return applicationType
+}
+
+func importedCurvePoints(document openapi.HeatCurveDocument) []openapi.FlowTempPoint {
+	switch {
+	case document.CurvePoints != nil && document.Csv != "":
+		panic(NewValidationError(ErrMalformedRequest, "csv", "Either curvePoints or csv expected, not both"))
+	case document.Csv != "":
+		curvePoints, err := parseCurvePointsCSV(document.Csv)
+		if err != nil {
+			panic(NewValidationError(ErrMalformedRequest, "csv", err.Error()))
+		}
+		return curvePoints
+	case len(document.CurvePoints) > 0:
+		return document.CurvePoints
+	default:
+		panic(NewValidationError(ErrMissingField, "curvePoints", "Curve points expected, either as curvePoints or as csv"))
+	}
+}
+
+func formatCurvePointsCSV(curvePoints []openapi.FlowTempPoint) string {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Write([]string{"outdoorTemp", "flowTemp"})
+	for _, point := range curvePoints {
+		writer.Write([]string{strconv.Itoa(int(point.OutdoorTemp)), strconv.Itoa(int(point.FlowTemp))})
+	}
+	writer.Flush()
+	return buffer.String()
+}
+
+func parseCurvePointsCSV(text string) ([]openapi.FlowTempPoint, error) {
+	rows, lines, err := readCSVColumns(text, "outdoorTemp", "flowTemp")
+	if err != nil {
+		return nil, err
+	}
+	curvePoints := make([]openapi.FlowTempPoint, len(rows))
+	for i, row := range rows {
+		outdoorTemp, err := strconv.ParseInt(row[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid outdoorTemp %q in line %d", row[0], lines[i])
+		}
+		flowTemp, err := strconv.ParseInt(row[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid flowTemp %q in line %d", row[1], lines[i])
+		}
+		curvePoints[i] = openapi.FlowTempPoint{OutdoorTemp: int32(outdoorTemp), FlowTemp: int32(flowTemp)}
+	}
+	return curvePoints, nil
+}
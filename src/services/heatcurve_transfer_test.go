@@ -0,0 +1,201 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/mocks"
+	api "github.com/treblada/ecl310-rest/services"
+	"gotest.tools/v3/assert"
+)
+
+// transferMock is a controller with application A266.1, circuit 2 has all curve points at 50 °C
+func transferMock(t *testing.T) *mocks.ClientMock {
+	mock := heatCurveMock(t)
+	read := mock.ReadHoldingRegistersMock
+	mock.ReadHoldingRegistersMock = func(address, quantity uint16) ([]byte, error) {
+		switch {
+		case address == 2060:
+			return []byte{0, 'A', 1, 10, 0, 1, 0, 0}, nil
+		case address >= 11400 && address <= 11405:
+			points := []byte{0, 65, 0, 63, 0, 61, 0, 59, 0, 57, 0, 55}
+			offset := (address - 11400) * 2
+			return points[offset : offset+quantity*2], nil
+		case address >= 12400 && address <= 12405:
+			return bytes.Repeat([]byte{0, 50}, int(quantity)), nil
+		case address >= 12000:
+			return read(address-1000, quantity)
+		}
+		return read(address, quantity)
+	}
+	return mock
+}
+
+func TestExportHeatCurve__json(t *testing.T) {
+	exportTime := time.Date(2024, 1, 15, 12, 0, 30, 500, time.UTC)
+	service := api.NewHeatingApiService(transferMock(t), api.WithClock(func() time.Time { return exportTime }))
+	response, err := service.ExportHeatCurve(context.TODO(), 1, "")
+	assert.NilError(t, err)
+	body := response.Body.(openapi.HeatCurveDocument)
+	assert.Equal(t, "ECL310_6_POINTS", body.Model)
+	assert.Equal(t, exportTime.Truncate(time.Second), body.ExportedAt)
+	assert.Equal(t, "A266.1", body.Application)
+	assert.Equal(t, int32(1), body.CircuitNo)
+	assert.Equal(t, int32(33), *body.MinFlowTemp)
+	assert.Equal(t, int32(66), *body.MaxFlowTemp)
+	assert.Equal(t, int32(0), *body.ParallelDisplacement)
+	assert.Equal(t, 6, len(body.CurvePoints))
+	assert.Equal(t, "", body.Csv)
+}
+
+func TestExportHeatCurve__csv(t *testing.T) {
+	service := api.NewHeatingApiService(transferMock(t))
+	response, err := service.ExportHeatCurve(context.TODO(), 1, "csv")
+	assert.NilError(t, err)
+	body := response.Body.(openapi.HeatCurveDocument)
+	assert.Check(t, body.CurvePoints == nil)
+	assert.Equal(t, "outdoorTemp,flowTemp\n-30,65\n-15,63\n-5,61\n0,59\n5,57\n15,55\n", body.Csv)
+}
+
+func TestImportHeatCurve__csv(t *testing.T) {
+	service := api.NewHeatingApiService(transferMock(t))
+	document := openapi.HeatCurveDocument{
+		Model:       "ECL310_6_POINTS",
+		Application: "A266.2",
+		MaxFlowTemp: ptr[int32](70),
+		Csv:         "outdoorTemp,flowTemp\n-30,65\n-15,63\n-5,61\n0,59\n5,57\n15,40\n",
+	}
+	response, err := service.ImportHeatCurve(context.TODO(), 1, document, true, "")
+	assert.NilError(t, err)
+	body := response.Body.(openapi.DryRunResponse)
+	assertDeepEqual(t, body.Changes, []openapi.PnuChange{
+		{Pnu: 11178, Label: "max temp", OldValue: 66, NewValue: 70},
+		{Pnu: 11405, Label: "15 outdoor temp", OldValue: 55, NewValue: 40},
+	})
+}
+
+func TestImportHeatCurve__failInvalidDocuments(t *testing.T) {
+	points := []openapi.FlowTempPoint{{OutdoorTemp: 0, FlowTemp: 50}}
+	tests := []struct {
+		name      string
+		document  openapi.HeatCurveDocument
+		code      int
+		errorCode api.ErrorCode
+		field     string
+	}{
+		{"other model", openapi.HeatCurveDocument{Model: "ECL210", CurvePoints: points}, http.StatusUnprocessableEntity, api.ErrIncompatibleCurve, "model"},
+		{"other application", openapi.HeatCurveDocument{Model: "ECL310_6_POINTS", Application: "A260.1", CurvePoints: points}, http.StatusUnprocessableEntity, api.ErrIncompatibleCurve, "application"},
+		{"no points", openapi.HeatCurveDocument{Model: "ECL310_6_POINTS"}, http.StatusBadRequest, api.ErrMissingField, "curvePoints"},
+		{"points and csv", openapi.HeatCurveDocument{Model: "ECL310_6_POINTS", CurvePoints: points, Csv: "outdoorTemp,flowTemp\n0,50"}, http.StatusBadRequest, api.ErrMalformedRequest, "csv"},
+		{"invalid csv", openapi.HeatCurveDocument{Model: "ECL310_6_POINTS", Csv: "outdoorTemp,flowTemp\n0,warm"}, http.StatusBadRequest, api.ErrMalformedRequest, "csv"},
+		{"invalid point", openapi.HeatCurveDocument{Model: "ECL310_6_POINTS", Csv: "outdoorTemp,flowTemp\n0,50\n1,50"}, http.StatusBadRequest, api.ErrValueOutOfRange, "curvePoints[1].outdoorTemp"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mock := transferMock(t)
+			service := api.NewHeatingApiService(mock)
+			_, err := service.ImportHeatCurve(context.TODO(), 1, test.document, false, "")
+			apiErr, ok := err.(*api.ApiError)
+			assert.Assert(t, ok, "%T", err)
+			assert.Equal(t, test.code, apiErr.Code)
+			assert.Equal(t, test.errorCode, apiErr.ErrorCode)
+			assert.Equal(t, test.field, apiErr.Field)
+			for _, call := range mock.Calls {
+				assert.Check(t, call.FuncName == "ReadHoldingRegisters", "%v", call)
+			}
+		})
+	}
+}
+
+// withApplication replaces the mock's application, encoded like PNU 2060 to 2063
+func withApplication(mock *mocks.ClientMock, letter byte, number uint16) *mocks.ClientMock {
+	read := mock.ReadHoldingRegistersMock
+	mock.ReadHoldingRegistersMock = func(address, quantity uint16) ([]byte, error) {
+		if address == 2060 {
+			return []byte{0, letter, byte(number >> 8), byte(number), 0, 1, 0, 0}, nil
+		}
+		return read(address, quantity)
+	}
+	return mock
+}
+
+func assertIncompatibleApplication(t *testing.T, mock *mocks.ClientMock, err error) {
+	apiErr, ok := err.(*api.ApiError)
+	assert.Assert(t, ok, "%T", err)
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.Code)
+	assert.Equal(t, api.ErrIncompatibleCurve, apiErr.ErrorCode)
+	assert.Equal(t, "application", apiErr.Field)
+	for _, call := range mock.Calls {
+		assert.Check(t, call.FuncName == "ReadHoldingRegisters", "%v", call)
+	}
+}
+
+func TestImportHeatCurve__failUnsupportedApplication(t *testing.T) {
+	mock := withApplication(transferMock(t), 'A', 217)
+	service := api.NewHeatingApiService(mock)
+	document := openapi.HeatCurveDocument{Model: "ECL310_6_POINTS", CurvePoints: []openapi.FlowTempPoint{{OutdoorTemp: 0, FlowTemp: 50}}}
+	_, err := service.ImportHeatCurve(context.TODO(), 1, document, false, "")
+	assertIncompatibleApplication(t, mock, err)
+}
+
+func TestCopyHeatCurve__failUnsupportedApplication(t *testing.T) {
+	mock := withApplication(transferMock(t), 'P', 330)
+	service := api.NewHeatingApiService(mock)
+	_, err := service.CopyHeatCurve(context.TODO(), 1, 2, false, "")
+	assertIncompatibleApplication(t, mock, err)
+}
+
+func TestCopyHeatCurve__writesTargetCircuit(t *testing.T) {
+	mock := transferMock(t)
+	service := api.NewHeatingApiService(mock)
+	response, err := service.CopyHeatCurve(context.TODO(), 1, 2, false, "")
+	assert.NilError(t, err)
+	_, ok := response.Body.(openapi.GetHeatCurveResponse)
+	assert.Assert(t, ok, "%T", response.Body)
+	writes := []mocks.Call{}
+	for _, call := range mock.Calls {
+		if call.FuncName == "WriteSingleRegister" {
+			writes = append(writes, call)
+		}
+	}
+	// only the points differ
+	assertDeepEqual(t, writes, []mocks.Call{
+		{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(12400), uint16(65)}},
+		{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(12401), uint16(63)}},
+		{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(12402), uint16(61)}},
+		{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(12403), uint16(59)}},
+		{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(12404), uint16(57)}},
+		{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(12405), uint16(55)}},
+	})
+}
+
+func TestCopyHeatCurve__failSameCircuit(t *testing.T) {
+	mock := &mocks.ClientMock{}
+	service := api.NewHeatingApiService(mock)
+	_, err := service.CopyHeatCurve(context.TODO(), 2, 2, false, "")
+	apiErr, ok := err.(*api.ApiError)
+	assert.Assert(t, ok, "%T", err)
+	assert.Equal(t, api.ErrInvalidCircuit, apiErr.ErrorCode)
+	assert.Equal(t, "targetCircuitNo", apiErr.Field)
+	assert.Equal(t, 0, len(mock.Calls))
+}
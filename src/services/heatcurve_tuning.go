@@ -0,0 +1,281 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/history"
+)
+
+const (
+	// Samples with less difference between room and outdoor temperature show no heating demand
+	minHeatingDemand = 5
+	minTuningSamples = 10
+	maxTuningSamples = 10000
+	// defaults of the period taken from the history
+	defaultTuningPeriod = 7 * 24 * time.Hour
+	defaultTuningStep   = 15 * time.Minute
+)
+
+/*
+TuneHeatCurve proposes a heat curve for the target room temperature. The building is
+modelled by a single factor: the flow temperature's excess over the room temperature is
+proportional to the room temperature's excess over the outdoor temperature. The factor is
+fitted to the samples by least squares.
+*/
+func (s *HeatingApiService) TuneHeatCurve(ctx context.Context, circuitNo int32, request openapi.HeatCurveTuningRequest) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	assertValidCircuit(circuitNo)
+	if request.TargetRoomTemp != nil && !(*request.TargetRoomTemp >= 10 && *request.TargetRoomTemp <= 30) {
+		panic(NewValidationError(ErrValueOutOfRange, "targetRoomTemp", fmt.Sprintf("Invalid target room temp %v, must be in [10, 30]", *request.TargetRoomTemp)))
+	}
+	samples, field := s.tuningSamples(request)
+	model := fitHeatingModel(samples, field)
+
+	curve, _ := s.readHeatCurve(ctx, circuitNo)
+	roomTemp := s.readRoomTemp(ctx, circuitNo)
+	target := float64(roomTemp)
+	if request.TargetRoomTemp != nil {
+		target = float64(*request.TargetRoomTemp)
+	}
+	return openapi.Response(http.StatusOK, proposeHeatCurve(model, curve, roomTemp, target)), nil
+}
+
+// tuningSamples returns the validated samples and the request field they were taken from
+func (s *HeatingApiService) tuningSamples(request openapi.HeatCurveTuningRequest) ([]openapi.HeatingSample, string) {
+	fromHistory := request.History != openapi.HeatingHistorySource{}
+	switch {
+	case request.Samples != nil && request.Csv != "":
+		panic(NewValidationError(ErrMalformedRequest, "csv", "Either samples or csv expected, not both"))
+	case fromHistory && (request.Samples != nil || request.Csv != ""):
+		panic(NewValidationError(ErrMalformedRequest, "history", "Either samples from the request or from the history expected, not both"))
+	case fromHistory:
+		return s.historySamples(request.History), "history"
+	case request.Csv != "":
+		samples, err := parseSamplesCSV(request.Csv)
+		if err != nil {
+			panic(NewValidationError(ErrMalformedRequest, "csv", err.Error()))
+		}
+		assertSampleCount(samples, "csv")
+		return samples, "csv"
+	case len(request.Samples) > 0:
+		assertSampleCount(request.Samples, "samples")
+		for i, sample := range request.Samples {
+			if member, err := checkSample(sample); err != nil {
+				panic(NewValidationError(ErrValueOutOfRange, fmt.Sprintf("samples[%d].%s", i, member), err.Error()))
+			}
+		}
+		return request.Samples, "samples"
+	default:
+		panic(NewValidationError(ErrMissingField, "samples", "Samples expected, either as samples, as csv or from the history"))
+	}
+}
+
+/*
+historySamples averages the outdoor, flow and room temperature series per step. Steps
+missing one of the series or holding an implausible value, e.g. of a disconnected sensor,
+are left out.
+*/
+func (s *HeatingApiService) historySamples(source openapi.HeatingHistorySource) []openapi.HeatingSample {
+	if s.historyStore == nil {
+		panic(NewApiError(http.StatusNotFound, ErrFeatureDisabled, "History is disabled", nil))
+	}
+	series := []string{source.OutdoorSeries, source.FlowSeries, source.RoomSeries}
+	for i, name := range []string{"outdoorSeries", "flowSeries", "roomSeries"} {
+		if series[i] == "" {
+			panic(NewValidationError(ErrMissingField, "history."+name, fmt.Sprintf("History series for %s expected", name)))
+		}
+	}
+	query := history.Query{
+		Series: series,
+		From:   parseTimestamp(source.From, "history.from"),
+		To:     parseTimestamp(source.To, "history.to"),
+		Step:   defaultTuningStep,
+	}
+	if query.To.IsZero() {
+		query.To = s.now()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-defaultTuningPeriod)
+	}
+	if source.Step != "" {
+		step, err := time.ParseDuration(source.Step)
+		if err != nil || step < time.Minute {
+			panic(NewValidationError(ErrInvalidFormat, "history.step", fmt.Sprintf("Invalid step %q, expected a duration of at least 1m", source.Step)))
+		}
+		query.Step = step
+	}
+	if query.From.After(query.To) {
+		panic(NewValidationError(ErrInvalidFormat, "history.from", fmt.Sprintf("From %s after to %s", query.From.Format(time.RFC3339), query.To.Format(time.RFC3339))))
+	}
+	if steps := query.To.Sub(query.From) / query.Step; steps >= maxTuningSamples {
+		panic(NewValidationError(ErrValueOutOfRange, "history.step", fmt.Sprintf("Too many samples %d, at most %d, use a longer step", steps, maxTuningSamples)))
+	}
+
+	points, err := s.historyStore.Query(query)
+	if err != nil {
+		panic(NewApiError(http.StatusInternalServerError, ErrInternal, "Error reading history", err))
+	}
+	flowTemps, roomTemps := pointsByTime(points[source.FlowSeries]), pointsByTime(points[source.RoomSeries])
+	samples := []openapi.HeatingSample{}
+	for _, outdoor := range points[source.OutdoorSeries] {
+		flow, hasFlow := flowTemps[outdoor.Time.Unix()]
+		room, hasRoom := roomTemps[outdoor.Time.Unix()]
+		if !hasFlow || !hasRoom {
+			continue
+		}
+		sample := openapi.HeatingSample{OutdoorTemp: float32(outdoor.Avg()), FlowTemp: float32(flow.Avg()), RoomTemp: float32(room.Avg())}
+		if _, err := checkSample(sample); err == nil {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+func pointsByTime(points []history.Point) map[int64]history.Point {
+	byTime := make(map[int64]history.Point, len(points))
+	for _, point := range points {
+		byTime[point.Time.Unix()] = point
+	}
+	return byTime
+}
+
+func assertSampleCount(samples []openapi.HeatingSample, field string) {
+	if len(samples) > maxTuningSamples {
+		panic(NewValidationError(ErrValueOutOfRange, field, fmt.Sprintf("Too many samples %d, at most %d", len(samples), maxTuningSamples)))
+	}
+}
+
+// checkSample returns the member out of range, if any
+func checkSample(sample openapi.HeatingSample) (string, error) {
+	members := []struct {
+		name     string
+		value    float32
+		min, max float32
+	}{
+		{"outdoorTemp", sample.OutdoorTemp, -60, 60},
+		{"flowTemp", sample.FlowTemp, 0, 150},
+		{"roomTemp", sample.RoomTemp, 0, 40},
+	}
+	for _, m := range members {
+		// also rejects NaN
+		if !(m.value >= m.min && m.value <= m.max) {
+			return m.name, fmt.Errorf("Invalid %s %v, must be in [%v, %v]", m.name, m.value, m.min, m.max)
+		}
+	}
+	return "", nil
+}
+
+// parseSamplesCSV reads the columns named by the header line, other columns are ignored
+func parseSamplesCSV(text string) ([]openapi.HeatingSample, error) {
+	rows, lines, err := readCSVColumns(text, "outdoorTemp", "flowTemp", "roomTemp")
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]openapi.HeatingSample, len(rows))
+	for i, row := range rows {
+		var values [3]float32
+		for j, column := range []string{"outdoorTemp", "flowTemp", "roomTemp"} {
+			value, err := strconv.ParseFloat(row[j], 32)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s %q in line %d", column, row[j], lines[i])
+			}
+			values[j] = float32(value)
+		}
+		samples[i] = openapi.HeatingSample{OutdoorTemp: values[0], FlowTemp: values[1], RoomTemp: values[2]}
+		if _, err := checkSample(samples[i]); err != nil {
+			return nil, fmt.Errorf("%w in line %d", err, lines[i])
+		}
+	}
+	return samples, nil
+}
+
+type heatingModel struct {
+	// K of flow temperature above room temperature per K of room temperature above outdoor temperature
+	factor   float64
+	rmsError float64
+	used     int
+	ignored  int
+}
+
+func fitHeatingModel(samples []openapi.HeatingSample, field string) heatingModel {
+	var xs, ys []float64
+	for _, sample := range samples {
+		x := float64(sample.RoomTemp - sample.OutdoorTemp)
+		y := float64(sample.FlowTemp - sample.RoomTemp)
+		if x >= minHeatingDemand && y > 0 {
+			xs = append(xs, x)
+			ys = append(ys, y)
+		}
+	}
+	model := heatingModel{used: len(xs), ignored: len(samples) - len(xs)}
+	if model.used < minTuningSamples {
+		panic(NewValidationError(ErrInsufficientData, field, fmt.Sprintf("Only %d of %d samples show heating demand, at least %d needed", model.used, len(samples), minTuningSamples)))
+	}
+
+	var sxy, sxx float64
+	for i := range xs {
+		sxy += xs[i] * ys[i]
+		sxx += xs[i] * xs[i]
+	}
+	model.factor = sxy / sxx
+	var squares float64
+	for i := range xs {
+		deviation := ys[i] - model.factor*xs[i]
+		squares += deviation * deviation
+	}
+	model.rmsError = math.Sqrt(squares / float64(model.used))
+	return model
+}
+
+/*
+proposeHeatCurve calculates the flow temperatures reaching the target room temperature at
+the curve's outdoor temperatures. The controller raises the curve for its desired room
+temperature, the points are lowered by the same amount.
+*/
+func proposeHeatCurve(model heatingModel, curve openapi.GetHeatCurveResponse, roomTemp int32, target float64) openapi.HeatCurveTuning {
+	shift := float64(roomTemp-curveRoomTemp) * math.Abs(float64(curve.Slope)) * roomTempFactor
+	points := make([]openapi.FlowTempPoint, len(validOutdoorTemps))
+	for i, outdoor := range validOutdoorTemps {
+		flowTemp := target + model.factor*(target-float64(outdoor)) - shift
+		points[i] = openapi.FlowTempPoint{OutdoorTemp: outdoor, FlowTemp: int32(min(max(math.Round(flowTemp), 10), 150))}
+	}
+	displacement := int32(0)
+	return openapi.HeatCurveTuning{
+		TargetRoomTemp: float32(target),
+		Slope:          float32(min(max(-math.Round(model.factor*10)/10, -10), -0.1)),
+		Curve: openapi.SetHeatCurveByPointsRequest{
+			ParallelDisplacement: &displacement,
+			CurvePoints:          points,
+		},
+		SamplesUsed:    int32(model.used),
+		SamplesIgnored: int32(model.ignored),
+		RmsError:       float32(math.Round(model.rmsError*100) / 100),
+	}
+}
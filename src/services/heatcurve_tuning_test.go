@@ -0,0 +1,202 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/history"
+	api "github.com/treblada/ecl310-rest/services"
+	"gotest.tools/v3/assert"
+)
+
+// heatingSamples follow a building with a factor of 1.5 K flow per K outdoor temperature below 21 °C room temperature
+func heatingSamples() []openapi.HeatingSample {
+	samples := []openapi.HeatingSample{}
+	for outdoor := -10; outdoor <= 10; outdoor += 2 {
+		samples = append(samples, openapi.HeatingSample{
+			OutdoorTemp: float32(outdoor),
+			FlowTemp:    21 + 1.5*float32(21-outdoor),
+			RoomTemp:    21,
+		})
+	}
+	return samples
+}
+
+func TestTuneHeatCurve__proposesCurveForTarget(t *testing.T) {
+	mock := heatCurveMock(t)
+	service := api.NewHeatingApiService(mock)
+	// summer, no heating demand
+	samples := append(heatingSamples(), openapi.HeatingSample{OutdoorTemp: 19, FlowTemp: 25, RoomTemp: 22})
+	response, err := service.TuneHeatCurve(context.TODO(), 1, openapi.HeatCurveTuningRequest{Samples: samples})
+	assert.NilError(t, err)
+	assert.Equal(t, http.StatusOK, response.Code)
+	body := response.Body.(openapi.HeatCurveTuning)
+	// the target is the controller's desired room temperature
+	assert.Equal(t, float32(20), body.TargetRoomTemp)
+	assert.Equal(t, float32(-1.5), body.Slope)
+	assert.Equal(t, int32(11), body.SamplesUsed)
+	assert.Equal(t, int32(1), body.SamplesIgnored)
+	assert.Equal(t, float32(0), body.RmsError)
+	assert.Equal(t, int32(0), *body.Curve.ParallelDisplacement)
+	assertDeepEqual(t, body.Curve.CurvePoints, []openapi.FlowTempPoint{
+		{OutdoorTemp: -30, FlowTemp: 95},
+		{OutdoorTemp: -15, FlowTemp: 73},
+		{OutdoorTemp: -5, FlowTemp: 58},
+		{OutdoorTemp: 0, FlowTemp: 50},
+		{OutdoorTemp: 5, FlowTemp: 43},
+		{OutdoorTemp: 15, FlowTemp: 28},
+	})
+	for _, call := range mock.Calls {
+		assert.Check(t, call.FuncName == "ReadHoldingRegisters", "%v", call)
+	}
+}
+
+func TestTuneHeatCurve__compensatesRoomTempShift(t *testing.T) {
+	mock := heatCurveMock(t)
+	read := mock.ReadHoldingRegistersMock
+	mock.ReadHoldingRegistersMock = func(address, quantity uint16) ([]byte, error) {
+		if address == 11180 {
+			// 24 °C, the controller adds 4 * 1.7 * 2.5 = 17 K
+			return []byte{0, 24}, nil
+		}
+		return read(address, quantity)
+	}
+	service := api.NewHeatingApiService(mock)
+	response, err := service.TuneHeatCurve(context.TODO(), 1, openapi.HeatCurveTuningRequest{TargetRoomTemp: ptr[float32](20), Samples: heatingSamples()})
+	assert.NilError(t, err)
+	body := response.Body.(openapi.HeatCurveTuning)
+	assert.Equal(t, float32(20), body.TargetRoomTemp)
+	// 50 - 17
+	assert.Equal(t, openapi.FlowTempPoint{OutdoorTemp: 0, FlowTemp: 33}, body.Curve.CurvePoints[3])
+}
+
+func TestTuneHeatCurve__readsCSV(t *testing.T) {
+	lines := []string{"time,outdoorTemp,roomTemp,flowTemp"}
+	for i, sample := range heatingSamples() {
+		lines = append(lines, fmt.Sprintf("2024-01-01T%02d:00:00Z, %v, %v, %v", i, sample.OutdoorTemp, sample.RoomTemp, sample.FlowTemp))
+	}
+	service := api.NewHeatingApiService(heatCurveMock(t))
+	response, err := service.TuneHeatCurve(context.TODO(), 1, openapi.HeatCurveTuningRequest{Csv: strings.Join(lines, "\n")})
+	assert.NilError(t, err)
+	body := response.Body.(openapi.HeatCurveTuning)
+	assert.Equal(t, float32(-1.5), body.Slope)
+	assert.Equal(t, int32(11), body.SamplesUsed)
+}
+
+func TestTuneHeatCurve__readsHistory(t *testing.T) {
+	store, err := history.NewFileStore(t.TempDir(), history.Retention{})
+	assert.NilError(t, err)
+	defer store.Close()
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	for i, sample := range heatingSamples() {
+		// two polls per step, the second one 5 minutes later
+		for _, offset := range []time.Duration{0, 5 * time.Minute} {
+			assert.NilError(t, store.Append(history.Sample{Time: start.Add(time.Duration(i)*15*time.Minute + offset), Values: map[string]float64{
+				"sensor.S1": float64(sample.OutdoorTemp),
+				"sensor.S3": float64(sample.FlowTemp),
+				"sensor.S2": float64(sample.RoomTemp),
+			}}))
+		}
+	}
+	// a step without the room temperature and one with a disconnected sensor are left out
+	assert.NilError(t, store.Append(history.Sample{Time: start.Add(-15 * time.Minute), Values: map[string]float64{"sensor.S1": 0, "sensor.S3": 50}}))
+	assert.NilError(t, store.Append(history.Sample{Time: start.Add(-30 * time.Minute), Values: map[string]float64{"sensor.S1": 192, "sensor.S3": 50, "sensor.S2": 21}}))
+	now := start.Add(24 * time.Hour)
+	service := api.NewHeatingApiService(heatCurveMock(t), api.WithHistoryStore(store), api.WithClock(func() time.Time { return now }))
+
+	response, err := service.TuneHeatCurve(context.TODO(), 1, openapi.HeatCurveTuningRequest{History: openapi.HeatingHistorySource{
+		OutdoorSeries: "sensor.S1",
+		FlowSeries:    "sensor.S3",
+		RoomSeries:    "sensor.S2",
+	}})
+	assert.NilError(t, err)
+	body := response.Body.(openapi.HeatCurveTuning)
+	assert.Equal(t, float32(-1.5), body.Slope)
+	assert.Equal(t, int32(11), body.SamplesUsed)
+	assert.Equal(t, int32(0), body.SamplesIgnored)
+
+	// the period excludes the first samples
+	response, err = service.TuneHeatCurve(context.TODO(), 1, openapi.HeatCurveTuningRequest{History: openapi.HeatingHistorySource{
+		OutdoorSeries: "sensor.S1",
+		FlowSeries:    "sensor.S3",
+		RoomSeries:    "sensor.S2",
+		From:          "2024-01-15T00:30:00Z",
+		Step:          "30m",
+	}})
+	assert.ErrorContains(t, err, "Only 5 of 5 samples")
+}
+
+func TestTuneHeatCurve__historyDisabled(t *testing.T) {
+	service := api.NewHeatingApiService(heatCurveMock(t))
+	_, err := service.TuneHeatCurve(context.TODO(), 1, openapi.HeatCurveTuningRequest{History: openapi.HeatingHistorySource{
+		OutdoorSeries: "sensor.S1",
+		FlowSeries:    "sensor.S3",
+		RoomSeries:    "sensor.S2",
+	}})
+	apiErr, ok := err.(*api.ApiError)
+	assert.Assert(t, ok, "%T", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Code)
+	assert.Equal(t, api.ErrFeatureDisabled, apiErr.ErrorCode)
+}
+
+func TestTuneHeatCurve__failInvalidSamples(t *testing.T) {
+	outOfRange := heatingSamples()
+	outOfRange[3].RoomTemp = 41
+	tests := []struct {
+		name      string
+		request   openapi.HeatCurveTuningRequest
+		errorCode api.ErrorCode
+		field     string
+		message   string
+	}{
+		{"no samples", openapi.HeatCurveTuningRequest{}, api.ErrMissingField, "samples", "Samples expected"},
+		{"samples and csv", openapi.HeatCurveTuningRequest{Samples: heatingSamples(), Csv: "outdoorTemp"}, api.ErrMalformedRequest, "csv", "not both"},
+		{"target out of range", openapi.HeatCurveTuningRequest{TargetRoomTemp: ptr[float32](31), Samples: heatingSamples()}, api.ErrValueOutOfRange, "targetRoomTemp", "31"},
+		{"sample out of range", openapi.HeatCurveTuningRequest{Samples: outOfRange}, api.ErrValueOutOfRange, "samples[3].roomTemp", "41"},
+		{"too few samples", openapi.HeatCurveTuningRequest{Samples: heatingSamples()[:9]}, api.ErrInsufficientData, "samples", "9 of 9"},
+		{"csv column missing", openapi.HeatCurveTuningRequest{Csv: "outdoorTemp,flowTemp\n0,50"}, api.ErrMalformedRequest, "csv", "column roomTemp"},
+		{"csv value invalid", openapi.HeatCurveTuningRequest{Csv: "outdoorTemp,flowTemp,roomTemp\n0,50,20\n1,x,20"}, api.ErrMalformedRequest, "csv", "line 3"},
+		{"csv too few samples", openapi.HeatCurveTuningRequest{Csv: "outdoorTemp,flowTemp,roomTemp\n0,50,20"}, api.ErrInsufficientData, "csv", "1 of 1"},
+		{"samples and history", openapi.HeatCurveTuningRequest{Samples: heatingSamples(), History: openapi.HeatingHistorySource{OutdoorSeries: "sensor.S1"}}, api.ErrMalformedRequest, "history", "not both"},
+		{"history series missing", openapi.HeatCurveTuningRequest{History: openapi.HeatingHistorySource{OutdoorSeries: "sensor.S1", RoomSeries: "sensor.S2"}}, api.ErrMissingField, "history.flowSeries", "flowSeries"},
+		{"history step invalid", openapi.HeatCurveTuningRequest{History: openapi.HeatingHistorySource{OutdoorSeries: "a", FlowSeries: "b", RoomSeries: "c", Step: "10s"}}, api.ErrInvalidFormat, "history.step", "10s"},
+		{"history too many samples", openapi.HeatCurveTuningRequest{History: openapi.HeatingHistorySource{OutdoorSeries: "a", FlowSeries: "b", RoomSeries: "c", From: "2020-01-01T00:00:00Z", To: "2024-01-01T00:00:00Z"}}, api.ErrValueOutOfRange, "history.step", "longer step"},
+		{"history from after to", openapi.HeatCurveTuningRequest{History: openapi.HeatingHistorySource{OutdoorSeries: "a", FlowSeries: "b", RoomSeries: "c", From: "2024-01-02T00:00:00Z", To: "2024-01-01T00:00:00Z"}}, api.ErrInvalidFormat, "history.from", "after"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := history.NewFileStore(t.TempDir(), history.Retention{})
+			assert.NilError(t, err)
+			defer store.Close()
+			service := api.NewHeatingApiService(heatCurveMock(t), api.WithHistoryStore(store))
+			_, err = service.TuneHeatCurve(context.TODO(), 1, test.request)
+			apiErr, ok := err.(*api.ApiError)
+			assert.Assert(t, ok, "%T", err)
+			assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+			assert.Equal(t, test.errorCode, apiErr.ErrorCode)
+			assert.Equal(t, test.field, apiErr.Field)
+			assert.ErrorContains(t, err, test.message)
+		})
+	}
+}
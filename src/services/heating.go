@@ -18,18 +18,22 @@ package api
 
 import (
 	"context"
-	"encoding/binary"
 	"fmt"
 	"math"
-	"net/http"
+	"sync"
 
+	"github.com/treblada/ecl310-rest/codec"
+	"github.com/treblada/ecl310-rest/etag"
 	"github.com/treblada/ecl310-rest/generated/openapi"
 	wrapper "github.com/treblada/ecl310-rest/modbus"
 )
 
 type HeatingApiService struct {
 	openapi.HeatingApiService
+	serviceOptions
 	client wrapper.ZeroBasedAddressClientWrapper
+	// serializes the writes, so no other request changes the curve between the If-Match check and the commit
+	writeMu sync.Mutex
 }
 
 type Int32Slice []int32
@@ -49,10 +53,17 @@ func (s Int32Slice) has(x int32) bool {
 
 var validOutdoorTemps = Int32Slice{-30, -15, -5, 0, 5, 15}
 
+// The controller stores the magnitude of the (negative) slope in tenths
+const slopeDecimalPlaces = 1
+
 func getSlopePnu(circuitNo int32) uint16 {
 	return 10175 + uint16(circuitNo)*1000
 }
 
+func getParallelDisplacementPnu(circuitNo int32) uint16 {
+	return 10176 + uint16(circuitNo)*1000
+}
+
 func getMinMaxPnu(circuitNo int32) uint16 {
 	return 10177 + uint16(circuitNo)*1000
 }
@@ -61,12 +72,23 @@ func getTempCurvePointsPnu(circuitNo int32) uint16 {
 	return 10400 + uint16(circuitNo)*1000
 }
 
-func NewHeatingApiService(client wrapper.ZeroBasedAddressClientWrapper) openapi.HeatingApiServicer {
+// desired room temperature in setback mode
+func getSetbackRoomTempPnu(circuitNo int32) uint16 {
+	return 10179 + uint16(circuitNo)*1000
+}
+
+// desired room temperature in comfort mode
+func getRoomTempPnu(circuitNo int32) uint16 {
+	return 10180 + uint16(circuitNo)*1000
+}
+
+func NewHeatingApiService(client wrapper.ZeroBasedAddressClientWrapper, opts ...ServiceOption) openapi.HeatingApiServicer {
 	if client == nil {
 		panic("No modbus client provided for System API service")
 	}
 	return &HeatingApiService{
-		client: client,
+		serviceOptions: newServiceOptions(opts),
+		client:         client,
 	}
 }
 
@@ -77,117 +99,350 @@ func (s *HeatingApiService) GetHeatCurve(ctx context.Context, circuitNo int32) (
 		}
 	}()
 
-	if circuitNo < 1 || circuitNo > 3 {
-		panic(NewApiError(http.StatusBadRequest, fmt.Sprintf("Invalid circuit number %d, not in [1,3]", circuitNo), nil))
-	}
+	assertValidCircuit(circuitNo)
+
+	body, tag := s.readHeatCurve(ctx, circuitNo)
+	etag.Set(ctx, tag)
+	return openapi.Response(200, body), nil
+}
 
-	slope := readPnu(s.client, getSlopePnu(circuitNo), 1)
-	minMax := readPnu(s.client, getMinMaxPnu(circuitNo), 2)
-	tempCurvePoints := readPnu(s.client, getTempCurvePointsPnu(circuitNo), 6)
+// readHeatCurve returns the curve and the ETag of its registers
+func (s *HeatingApiService) readHeatCurve(ctx context.Context, circuitNo int32) (openapi.GetHeatCurveResponse, string) {
+	registers := readPnus(
+		contextClient(ctx, s.client),
+		s.readPlanner,
+		wrapper.ReadRange{Address: getSlopePnu(circuitNo), Quantity: 1},
+		wrapper.ReadRange{Address: getParallelDisplacementPnu(circuitNo), Quantity: 1},
+		wrapper.ReadRange{Address: getMinMaxPnu(circuitNo), Quantity: 2},
+		wrapper.ReadRange{Address: getTempCurvePointsPnu(circuitNo), Quantity: 6},
+	)
+	slope, displacement, minMax, tempCurvePoints := registers[0], registers[1], registers[2], registers[3]
 
 	var curvePoints [6]openapi.FlowTempPoint
 	for i := 0; i < len(validOutdoorTemps); i++ {
 		curvePoints[i] = openapi.FlowTempPoint{
 			OutdoorTemp: validOutdoorTemps[i],
-			FlowTemp:    int32(binary.BigEndian.Uint16(tempCurvePoints[i*2 : i*2+2])),
+			FlowTemp:    int32(tempCurvePoints.Int16(i)),
 		}
 	}
 
 	body := openapi.GetHeatCurveResponse{
-		Slope:       float32(binary.BigEndian.Uint16(slope)) / -10.0,
-		MinFlowTemp: int32(binary.BigEndian.Uint16(minMax[0:2])),
-		MaxFlowTemp: int32(binary.BigEndian.Uint16(minMax[2:4])),
-		CurvePoints: curvePoints[:],
+		Slope:                float32(-slope.Decimal(0, slopeDecimalPlaces)),
+		MinFlowTemp:          int32(minMax.Int16(0)),
+		MaxFlowTemp:          int32(minMax.Int16(1)),
+		ParallelDisplacement: int32(displacement.Int16(0)),
+		CurvePoints:          curvePoints[:],
 	}
 
-	return openapi.Response(200, body), nil
+	return body, etag.Compute(slope, displacement, minMax, tempCurvePoints)
 }
 
-func (s *HeatingApiService) SetHeatCurveBySlope(ctx context.Context, circuitNo int32, values openapi.SetHeatCurveBySlopeRequest) (response openapi.ImplResponse, funcErr error) {
+func (s *HeatingApiService) assertHeatCurveIfMatch(ctx context.Context, circuitNo int32, ifMatch string) {
+	s.assertIfMatch(ifMatch, func() string {
+		_, tag := s.readHeatCurve(ctx, circuitNo)
+		return tag
+	})
+}
+
+func (s *HeatingApiService) SetHeatCurveBySlope(ctx context.Context, circuitNo int32, values openapi.SetHeatCurveBySlopeRequest, dryRun bool, ifMatch string) (response openapi.ImplResponse, funcErr error) {
 	defer func() {
 		if panic := recover(); panic != nil {
 			response, funcErr = handlePanic(panic)
 		}
 	}()
 
-	if circuitNo < 1 || circuitNo > 3 {
-		panic(NewApiError(http.StatusBadRequest, fmt.Sprintf("Invalid circuit number %d, not in [1,3]", circuitNo), nil))
+	assertValidCircuit(circuitNo)
+	assertValidSlope(values.Slope, "slope")
+	assertValidFlowTemperatureRange(values.MinFlowTemp, "minFlowTemp", "min flow temp")
+	assertValidFlowTemperatureRange(values.MaxFlowTemp, "maxFlowTemp", "max flow temp")
+	assertValidParallelDisplacement(values.ParallelDisplacement, "parallelDisplacement")
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.assertHeatCurveIfMatch(ctx, circuitNo, ifMatch)
+
+	tx := newPnuTransaction(ctx, s.client, s.serviceOptions, circuitNo)
+	updateSlope(tx, circuitNo, values.Slope)
+	updateParallelDisplacement(tx, circuitNo, values.ParallelDisplacement)
+	updateFlowTempLimits(tx, circuitNo, values.MinFlowTemp, values.MaxFlowTemp)
+
+	if dryRun {
+		return dryRunResponse(tx.diff()), nil
 	}
 
-	if values.Slope > -0.1 || values.Slope < -10 {
-		panic(NewApiError(http.StatusBadRequest, fmt.Sprintf("Invalid slope value %f, must be in [-10, -0.1]", values.Slope), nil))
+	tx.commit()
+
+	return s.GetHeatCurve(ctx, circuitNo)
+}
+
+func (s *HeatingApiService) SetHeatCurveByPoints(ctx context.Context, circuitNo int32, values openapi.SetHeatCurveByPointsRequest, dryRun bool, ifMatch string) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	assertValidCircuit(circuitNo)
+	assertValidFlowTemperatureRange(values.MinFlowTemp, "minFlowTemp", "min flow temp")
+	assertValidFlowTemperatureRange(values.MaxFlowTemp, "maxFlowTemp", "max flow temp")
+	assertValidParallelDisplacement(values.ParallelDisplacement, "parallelDisplacement")
+	assertValidCurvePoints(values.CurvePoints, "curvePoints")
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.assertHeatCurveIfMatch(ctx, circuitNo, ifMatch)
+
+	tx := newPnuTransaction(ctx, s.client, s.serviceOptions, circuitNo)
+	updateParallelDisplacement(tx, circuitNo, values.ParallelDisplacement)
+	updateFlowTempLimits(tx, circuitNo, values.MinFlowTemp, values.MaxFlowTemp)
+	updateCurvePoints(tx, circuitNo, values.CurvePoints)
+
+	if dryRun {
+		return dryRunResponse(tx.diff()), nil
 	}
 
-	assertValidFlowTemperatureRange(values.MinFlowTemp, "min flow temp")
-	assertValidFlowTemperatureRange(values.MaxFlowTemp, "max flow temp")
+	tx.commit()
+
+	return s.GetHeatCurve(ctx, circuitNo)
+}
+
+/*
+PatchHeatCurve applies a JSON Merge Patch to the heat curve. Only the members present are
+written, null can't be told apart from a missing member and doesn't change anything
+either. The curve points replace all points, like any array in a merge patch.
+*/
+func (s *HeatingApiService) PatchHeatCurve(ctx context.Context, circuitNo int32, patch openapi.HeatCurvePatch, dryRun bool, ifMatch string) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
 
-	if values.Slope != 0 {
-		slopePnu := getSlopePnu(circuitNo)
-		newSlopeInt := uint16(math.Round(float64(values.Slope) * -10))
-		updateSinglePnu(s.client, slopePnu, newSlopeInt, "slope")
+	assertValidCircuit(circuitNo)
+	if patch.Slope != nil && patch.CurvePoints != nil {
+		panic(NewValidationError(ErrMalformedRequest, "curvePoints", "Slope and curve points both define the curve, only one of them can be changed"))
+	}
+	if patch.Slope != nil {
+		assertValidSlope(*patch.Slope, "slope")
+	}
+	assertValidFlowTemperatureRange(patch.MinFlowTemp, "minFlowTemp", "min flow temp")
+	assertValidFlowTemperatureRange(patch.MaxFlowTemp, "maxFlowTemp", "max flow temp")
+	assertValidParallelDisplacement(patch.ParallelDisplacement, "parallelDisplacement")
+	if patch.CurvePoints != nil {
+		assertValidCurvePoints(patch.CurvePoints, "curvePoints")
+		assertCompleteCurvePoints(patch.CurvePoints, "curvePoints")
 	}
 
-	minMaxPnu := getMinMaxPnu(circuitNo)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.assertHeatCurveIfMatch(ctx, circuitNo, ifMatch)
 
-	if values.MinFlowTemp != 0 {
-		newMinTempInt := uint16(values.MinFlowTemp)
-		updateSinglePnu(s.client, minMaxPnu, newMinTempInt, "min temp")
+	tx := newPnuTransaction(ctx, s.client, s.serviceOptions, circuitNo)
+	if patch.Slope != nil {
+		updateSlope(tx, circuitNo, *patch.Slope)
 	}
+	updateParallelDisplacement(tx, circuitNo, patch.ParallelDisplacement)
+	updateFlowTempLimits(tx, circuitNo, patch.MinFlowTemp, patch.MaxFlowTemp)
+	updateCurvePoints(tx, circuitNo, patch.CurvePoints)
 
-	if values.MaxFlowTemp != 0 {
-		newMaxTempInt := uint16(values.MaxFlowTemp)
-		updateSinglePnu(s.client, minMaxPnu+1, newMaxTempInt, "max temp")
+	if dryRun {
+		return dryRunResponse(tx.diff()), nil
 	}
 
+	tx.commit()
+
 	return s.GetHeatCurve(ctx, circuitNo)
 }
 
-func (s *HeatingApiService) SetHeatCurveByPoints(ctx context.Context, circuitNo int32, values openapi.SetHeatCurveByPointsRequest) (response openapi.ImplResponse, funcErr error) {
+func (s *HeatingApiService) EvaluateHeatCurve(ctx context.Context, circuitNo int32, outdoor float32) (response openapi.ImplResponse, funcErr error) {
 	defer func() {
 		if panic := recover(); panic != nil {
 			response, funcErr = handlePanic(panic)
 		}
 	}()
 
-	if circuitNo < 1 || circuitNo > 3 {
-		panic(NewApiError(http.StatusBadRequest, fmt.Sprintf("Invalid circuit number %d, not in [1,3]", circuitNo), nil))
+	assertValidCircuit(circuitNo)
+	assertValidEvaluationTemp(outdoor, "outdoor")
+
+	curve, _ := s.readHeatCurve(ctx, circuitNo)
+	roomTemp := s.readDesiredRoomTemp(ctx, circuitNo)
+	return openapi.Response(200, evaluateHeatCurve(curve, roomTemp, outdoor)), nil
+}
+
+func (s *HeatingApiService) EvaluateHeatCurveBatch(ctx context.Context, circuitNo int32, outdoor []float32) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	assertValidCircuit(circuitNo)
+	if len(outdoor) == 0 || len(outdoor) > maxEvaluations {
+		panic(NewValidationError(ErrValueOutOfRange, "outdoor", fmt.Sprintf("Invalid number of outdoor temps %d, must be in [1, %d]", len(outdoor), maxEvaluations)))
+	}
+	for i, temp := range outdoor {
+		assertValidEvaluationTemp(temp, fmt.Sprintf("outdoor[%d]", i))
 	}
 
-	assertValidFlowTemperatureRange(values.MinFlowTemp, "min flow temp")
-	assertValidFlowTemperatureRange(values.MaxFlowTemp, "max flow temp")
+	curve, _ := s.readHeatCurve(ctx, circuitNo)
+	roomTemp := s.readDesiredRoomTemp(ctx, circuitNo)
+	body := openapi.HeatCurveEvaluations{Evaluations: make([]openapi.HeatCurveEvaluation, len(outdoor))}
+	for i, temp := range outdoor {
+		body.Evaluations[i] = evaluateHeatCurve(curve, roomTemp, temp)
+	}
+	return openapi.Response(200, body), nil
+}
 
-	for i := 0; i < len(values.CurvePoints); i++ {
-		outTemp := values.CurvePoints[i].OutdoorTemp
-		if !validOutdoorTemps.has(outTemp) {
-			panic(NewApiError(http.StatusBadRequest, fmt.Sprintf("Invalid outdoor temp %d, not in %v", outTemp, validOutdoorTemps), nil))
+// Maximum number of outdoor temperatures evaluated by one batch request
+const maxEvaluations = 100
+
+// readRoomTemp reads the desired comfort room temperature
+func (s *HeatingApiService) readRoomTemp(ctx context.Context, circuitNo int32) int32 {
+	registers := readPnus(contextClient(ctx, s.client), s.readPlanner, wrapper.ReadRange{Address: getRoomTempPnu(circuitNo), Quantity: 1})
+	return int32(registers[0].Int16(0))
+}
+
+// readDesiredRoomTemp reads the room temperature the controller heats for in the circuit's current state
+func (s *HeatingApiService) readDesiredRoomTemp(ctx context.Context, circuitNo int32) int32 {
+	registers := readPnus(contextClient(ctx, s.client), s.readPlanner,
+		wrapper.ReadRange{Address: getCircuitStatePnu(circuitNo), Quantity: 1},
+		wrapper.ReadRange{Address: getSetbackRoomTempPnu(circuitNo), Quantity: 1},
+		wrapper.ReadRange{Address: getRoomTempPnu(circuitNo), Quantity: 1},
+	)
+	switch GetCircuitState(registers[0].Uint16(0)) {
+	case Setback, PreSetback:
+		return int32(registers[1].Int16(0))
+	default:
+		return int32(registers[2].Int16(0))
+	}
+}
+
+const (
+	// The curve points are defined for this room temperature
+	curveRoomTemp = 20
+	// Flow temperature change per K of desired room temperature and unit of slope
+	roomTempFactor = 2.5
+)
+
+func assertValidEvaluationTemp(outdoor float32, field string) {
+	// also rejects NaN
+	if !(outdoor >= -60 && outdoor <= 60) {
+		panic(NewValidationError(ErrValueOutOfRange, field, fmt.Sprintf("Invalid outdoor temp %v, must be in [-60, 60]", outdoor)))
+	}
+}
+
+/*
+evaluateHeatCurve interpolates linearly between the curve points, which are ordered by
+outdoor temperature. Like on the controller the curve is flat outside the points, it is
+shifted for the desired room temperature and by the parallel displacement. The result is
+limited to the min and max flow temperature.
+*/
+func evaluateHeatCurve(curve openapi.GetHeatCurveResponse, roomTemp int32, outdoor float32) openapi.HeatCurveEvaluation {
+	points := curve.CurvePoints
+	var flowTemp float64
+	switch last := len(points) - 1; {
+	case outdoor <= float32(points[0].OutdoorTemp):
+		flowTemp = float64(points[0].FlowTemp)
+	case outdoor >= float32(points[last].OutdoorTemp):
+		flowTemp = float64(points[last].FlowTemp)
+	default:
+		i := 0
+		for float32(points[i+1].OutdoorTemp) < outdoor {
+			i++
 		}
-		assertValidFlowTemperatureRange(values.CurvePoints[i].FlowTemp, fmt.Sprintf("flow temp for %d outside temp", outTemp))
+		lower, upper := points[i], points[i+1]
+		share := (float64(outdoor) - float64(lower.OutdoorTemp)) / float64(upper.OutdoorTemp-lower.OutdoorTemp)
+		flowTemp = float64(lower.FlowTemp) + share*float64(upper.FlowTemp-lower.FlowTemp)
 	}
 
-	minMaxPnu := getMinMaxPnu(circuitNo)
+	flowTemp += float64(roomTemp-curveRoomTemp) * math.Abs(float64(curve.Slope)) * roomTempFactor
+	flowTemp += float64(curve.ParallelDisplacement)
 
-	if values.MinFlowTemp != 0 {
-		newMinTempInt := uint16(values.MinFlowTemp)
-		updateSinglePnu(s.client, minMaxPnu, newMinTempInt, "min temp")
+	evaluation := openapi.HeatCurveEvaluation{OutdoorTemp: outdoor, RoomTemp: roomTemp, LimitedBy: "NONE"}
+	if flowTemp < float64(curve.MinFlowTemp) {
+		flowTemp = float64(curve.MinFlowTemp)
+		evaluation.LimitedBy = "MIN_FLOW_TEMP"
+	} else if flowTemp > float64(curve.MaxFlowTemp) {
+		flowTemp = float64(curve.MaxFlowTemp)
+		evaluation.LimitedBy = "MAX_FLOW_TEMP"
 	}
+	evaluation.FlowTemp = float32(math.Round(flowTemp*10) / 10)
+	return evaluation
+}
+
+func updateSlope(tx *pnuTransaction, circuitNo int32, slope float32) {
+	tx.update(getSlopePnu(circuitNo), mustEncode(codec.EncodeDecimal(-float64(slope), slopeDecimalPlaces)), "slope")
+}
+
+// updateParallelDisplacement leaves the displacement unchanged if it is nil
+func updateParallelDisplacement(tx *pnuTransaction, circuitNo int32, displacement *int32) {
+	if displacement != nil {
+		tx.update(getParallelDisplacementPnu(circuitNo), mustEncode(codec.EncodeInt16(int64(*displacement))), "parallel displacement")
+	}
+}
 
-	if values.MaxFlowTemp != 0 {
-		newMaxTempInt := uint16(values.MaxFlowTemp)
-		updateSinglePnu(s.client, minMaxPnu+1, newMaxTempInt, "max temp")
+// updateFlowTempLimits leaves a limit unchanged if it is nil
+func updateFlowTempLimits(tx *pnuTransaction, circuitNo int32, minFlowTemp *int32, maxFlowTemp *int32) {
+	minMaxPnu := getMinMaxPnu(circuitNo)
+	if minFlowTemp != nil {
+		tx.update(minMaxPnu, mustEncode(codec.EncodeInt16(int64(*minFlowTemp))), "min temp")
+	}
+	if maxFlowTemp != nil {
+		tx.update(minMaxPnu+1, mustEncode(codec.EncodeInt16(int64(*maxFlowTemp))), "max temp")
 	}
+}
 
+// updateCurvePoints must be called after updateSlope, the controller recalculates the points for a new slope
+func updateCurvePoints(tx *pnuTransaction, circuitNo int32, curvePoints []openapi.FlowTempPoint) {
 	tempCurvePointsPnu := getTempCurvePointsPnu(circuitNo)
+	for _, curvePoint := range curvePoints {
+		pnu := tempCurvePointsPnu + uint16(validOutdoorTemps.indexOf(curvePoint.OutdoorTemp))
+		tx.update(pnu, mustEncode(codec.EncodeInt16(int64(curvePoint.FlowTemp))), fmt.Sprintf("%d outdoor temp", curvePoint.OutdoorTemp))
+		tx.recalculatedBy(getSlopePnu(circuitNo), pnu)
+	}
+}
+
+func assertValidSlope(slope float32, field string) {
+	if slope > -0.1 || slope < -10 {
+		panic(NewValidationError(ErrValueOutOfRange, field, fmt.Sprintf("Invalid slope value %f, must be in [-10, -0.1]", slope)))
+	}
+}
 
-	for _, curvePoint := range values.CurvePoints {
-		i := validOutdoorTemps.indexOf(curvePoint.OutdoorTemp)
-		updateSinglePnu(s.client, tempCurvePointsPnu+uint16(i), uint16(curvePoint.FlowTemp), fmt.Sprintf("%d outdoor temp", curvePoint.OutdoorTemp))
+// assertValidParallelDisplacement accepts a missing value
+func assertValidParallelDisplacement(displacement *int32, field string) {
+	if displacement != nil && (*displacement < -9 || *displacement > 9) {
+		panic(NewValidationError(ErrValueOutOfRange, field, fmt.Sprintf("Invalid parallel displacement %d. Valid values: [-9, 9]", *displacement)))
 	}
+}
 
-	return s.GetHeatCurve(ctx, circuitNo)
+// assertValidFlowTemperatureRange accepts a missing value
+func assertValidFlowTemperatureRange(tempValue *int32, field string, id string) {
+	if tempValue != nil && (*tempValue < 10 || *tempValue > 150) {
+		panic(NewValidationError(ErrValueOutOfRange, field, fmt.Sprintf("Invalid value %d for %s. Valid values: [10, 150]", *tempValue, id)))
+	}
+}
+
+func assertValidCurvePoints(curvePoints []openapi.FlowTempPoint, field string) {
+	for i, curvePoint := range curvePoints {
+		outTemp := curvePoint.OutdoorTemp
+		if !validOutdoorTemps.has(outTemp) {
+			panic(NewValidationError(ErrValueOutOfRange, fmt.Sprintf("%s[%d].outdoorTemp", field, i), fmt.Sprintf("Invalid outdoor temp %d, not in %v", outTemp, validOutdoorTemps)))
+		}
+		flowTemp := curvePoint.FlowTemp
+		assertValidFlowTemperatureRange(&flowTemp, fmt.Sprintf("%s[%d].flowTemp", field, i), fmt.Sprintf("flow temp for %d outside temp", outTemp))
+	}
 }
 
-func assertValidFlowTemperatureRange(tempValue int32, id string) {
-	if tempValue != 0 && tempValue < 10 || tempValue > 150 {
-		panic(NewApiError(http.StatusBadRequest, fmt.Sprintf("Invalid value %d for %s. Valid values: [10, 150]", tempValue, id), nil))
+// assertCompleteCurvePoints requires exactly one point for each outdoor temperature
+func assertCompleteCurvePoints(curvePoints []openapi.FlowTempPoint, field string) {
+	seen := Int32Slice{}
+	for i, curvePoint := range curvePoints {
+		if seen.has(curvePoint.OutdoorTemp) {
+			panic(NewValidationError(ErrMalformedRequest, fmt.Sprintf("%s[%d].outdoorTemp", field, i), fmt.Sprintf("Duplicate curve point for outdoor temp %d", curvePoint.OutdoorTemp)))
+		}
+		seen = append(seen, curvePoint.OutdoorTemp)
+	}
+	if len(seen) != len(validOutdoorTemps) {
+		panic(NewValidationError(ErrMissingField, field, fmt.Sprintf("The curve points replace all points, expected one for each outdoor temp %v", validOutdoorTemps)))
 	}
 }
@@ -22,11 +22,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
+	"github.com/treblada/ecl310-rest/etag"
 	"github.com/treblada/ecl310-rest/generated/openapi"
 	"github.com/treblada/ecl310-rest/mocks"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
 	api "github.com/treblada/ecl310-rest/services"
 	"gotest.tools/v3/assert"
 )
@@ -38,6 +41,9 @@ func TestGetHeatCurve__success(t *testing.T) {
 			case 11175: // slope
 				assert.Equal(t, uint16(1), quantity)
 				return []byte{0, 17}, nil
+			case 11176: // parallel displacement
+				assert.Equal(t, uint16(1), quantity)
+				return []byte{0, 0}, nil
 			case 11177: // min/max
 				assert.Equal(t, uint16(2), quantity)
 				return []byte{0, 33, 0, 66}, nil
@@ -73,6 +79,34 @@ func TestGetHeatCurve__success(t *testing.T) {
 	assert.Check(t, body.CurvePoints[5].FlowTemp == 55)
 }
 
+func TestGetHeatCurve__coalescedReads(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			switch address {
+			case 11175: // slope, unused, min/max
+				assert.Equal(t, uint16(4), quantity)
+				return []byte{0, 17, 0, 0, 0, 33, 0, 66}, nil
+			case 11400: // temperatures: -30, -15, -5, 0, 5, 15
+				assert.Equal(t, uint16(6), quantity)
+				return []byte{0, 65, 0, 63, 0, 61, 0, 59, 0, 57, 0, 55}, nil
+			default:
+				t.Errorf("Unexpected address %d", address)
+				t.FailNow()
+				return nil, errors.New("Test failure")
+			}
+		},
+	}
+	service := api.NewHeatingApiService(mock, api.WithReadPlanner(wrapper.NewReadPlanner(16, 64)))
+	response, err := service.GetHeatCurve(context.TODO(), 1)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(mock.Calls))
+	body := response.Body.(openapi.GetHeatCurveResponse)
+	assert.Check(t, body.Slope == -1.7)
+	assert.Check(t, body.MinFlowTemp == 33)
+	assert.Check(t, body.MaxFlowTemp == 66)
+	assert.Check(t, body.CurvePoints[5].FlowTemp == 55)
+}
+
 func TestGetHeatCurve__failWithCircuit0(t *testing.T) {
 	mock := &mocks.ClientMock{}
 	service := api.NewHeatingApiService(mock)
@@ -97,10 +131,10 @@ func TestSetHeatCurveBySlope__failInvalidSlope(t *testing.T) {
 	service := api.NewHeatingApiService(mock)
 	values := openapi.SetHeatCurveBySlopeRequest{
 		Slope:       0,
-		MinFlowTemp: 10,
-		MaxFlowTemp: 150,
+		MinFlowTemp: ptr[int32](10),
+		MaxFlowTemp: ptr[int32](150),
 	}
-	_, err := service.SetHeatCurveBySlope(context.TODO(), 1, values)
+	_, err := service.SetHeatCurveBySlope(context.TODO(), 1, values, false, "")
 	assert.ErrorContains(t, err, "slope")
 	apiErr, ok := err.(*api.ApiError)
 	assert.Assert(t, ok, "%T", err)
@@ -112,10 +146,10 @@ func TestSetHeatCurveBySlope__failInvalidMinFlow(t *testing.T) {
 	service := api.NewHeatingApiService(mock)
 	values := openapi.SetHeatCurveBySlopeRequest{
 		Slope:       -1,
-		MinFlowTemp: 1,
-		MaxFlowTemp: 150,
+		MinFlowTemp: ptr[int32](1),
+		MaxFlowTemp: ptr[int32](150),
 	}
-	_, err := service.SetHeatCurveBySlope(context.TODO(), 1, values)
+	_, err := service.SetHeatCurveBySlope(context.TODO(), 1, values, false, "")
 	assert.ErrorContains(t, err, "min flow")
 	apiErr, ok := err.(*api.ApiError)
 	assert.Assert(t, ok, "%T", err)
@@ -127,10 +161,10 @@ func TestSetHeatCurveBySlope__failInvalidMaxFlow(t *testing.T) {
 	service := api.NewHeatingApiService(mock)
 	values := openapi.SetHeatCurveBySlopeRequest{
 		Slope:       -1,
-		MinFlowTemp: 10,
-		MaxFlowTemp: 200,
+		MinFlowTemp: ptr[int32](10),
+		MaxFlowTemp: ptr[int32](200),
 	}
-	_, err := service.SetHeatCurveBySlope(context.TODO(), 1, values)
+	_, err := service.SetHeatCurveBySlope(context.TODO(), 1, values, false, "")
 	assert.ErrorContains(t, err, "max flow")
 	apiErr, ok := err.(*api.ApiError)
 	assert.Assert(t, ok, "%T", err)
@@ -144,6 +178,9 @@ func TestSetHeatCurveBySlope__success(t *testing.T) {
 			case 11175: // slope
 				assert.Equal(t, uint16(1), quantity)
 				return []byte{0, 17}, nil
+			case 11176: // parallel displacement
+				assert.Equal(t, uint16(1), quantity)
+				return []byte{0, 0}, nil
 			case 11177: // min/max
 				assert.Check(t, quantity == 1 || quantity == 2)
 				return []byte{0, 33, 0, 66}[0 : quantity*2], nil
@@ -180,33 +217,71 @@ func TestSetHeatCurveBySlope__success(t *testing.T) {
 	service := api.NewHeatingApiService(mock)
 	request := openapi.SetHeatCurveBySlopeRequest{
 		Slope:       -1.8,
-		MinFlowTemp: 30,
-		MaxFlowTemp: 70,
+		MinFlowTemp: ptr[int32](30),
+		MaxFlowTemp: ptr[int32](70),
 	}
-	response, err := service.SetHeatCurveBySlope(context.TODO(), 1, request)
+	response, err := service.SetHeatCurveBySlope(context.TODO(), 1, request, false, "")
 	assert.NilError(t, err)
 	assert.Check(t, response.Code == http.StatusOK)
 	if body, ok := response.Body.(openapi.GetHeatCurveResponse); !ok {
 		t.Errorf("Unexpected return type %T\n", body)
 	}
-	assert.Equal(t, 9, len(mock.Calls))
+	assert.Equal(t, 10, len(mock.Calls))
 	for i, call := range mock.Calls {
 		fmt.Printf("%d: %v\n", i, call)
 	}
-	assertDeepEqual(t, mock.Calls[1], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11175), uint16(18)}})
-	assertDeepEqual(t, mock.Calls[3], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11177), uint16(30)}})
+	assertDeepEqual(t, mock.Calls[3], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11175), uint16(18)}})
+	assertDeepEqual(t, mock.Calls[4], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11177), uint16(30)}})
 	assertDeepEqual(t, mock.Calls[5], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11178), uint16(70)}})
 }
 
+func TestSetHeatCurveBySlope__dryRun(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			assert.Equal(t, uint16(1), quantity)
+			switch address {
+			case 11175: // slope
+				return []byte{0, 17}, nil
+			case 11177: // min
+				return []byte{0, 30}, nil
+			case 11178: // max
+				return []byte{0, 66}, nil
+			default:
+				t.Errorf("Unexpected address %d", address)
+				t.FailNow()
+				return nil, errors.New("Test failure")
+			}
+		},
+	}
+	service := api.NewHeatingApiService(mock)
+	request := openapi.SetHeatCurveBySlopeRequest{
+		Slope:       -1.8,
+		MinFlowTemp: ptr[int32](30),
+		MaxFlowTemp: ptr[int32](70),
+	}
+	response, err := service.SetHeatCurveBySlope(context.TODO(), 1, request, true, "")
+	assert.NilError(t, err)
+	assert.Check(t, response.Code == http.StatusOK)
+	body, ok := response.Body.(openapi.DryRunResponse)
+	assert.Assert(t, ok, "%T", response.Body)
+	assertDeepEqual(t, body.Changes, []openapi.PnuChange{
+		{Pnu: 11175, Label: "slope", OldValue: 17, NewValue: 18},
+		{Pnu: 11178, Label: "max temp", OldValue: 66, NewValue: 70},
+	})
+	for _, call := range mock.Calls {
+		assert.Check(t, call.FuncName == "ReadHoldingRegisters", "%v", call)
+	}
+}
+
 func TestSetHeatCurveByPoints__failInvalidMinFlow(t *testing.T) {
 	mock := &mocks.ClientMock{}
 	service := api.NewHeatingApiService(mock)
 	values := openapi.SetHeatCurveByPointsRequest{
-		MinFlowTemp: 1,
-		MaxFlowTemp: 150,
+		MinFlowTemp: ptr[int32](1),
+		MaxFlowTemp: ptr[int32](150),
 		CurvePoints: []openapi.FlowTempPoint{},
 	}
-	_, err := service.SetHeatCurveByPoints(context.TODO(), 1, values)
+	_, err := service.SetHeatCurveByPoints(context.TODO(), 1, values, false, "")
 	assert.ErrorContains(t, err, "min flow")
 	apiErr, ok := err.(*api.ApiError)
 	assert.Assert(t, ok, "%T", err)
@@ -217,11 +292,11 @@ func TestSetHeatCurveByPoints__failInvalidMaxFlow(t *testing.T) {
 	mock := &mocks.ClientMock{}
 	service := api.NewHeatingApiService(mock)
 	values := openapi.SetHeatCurveByPointsRequest{
-		MinFlowTemp: 10,
-		MaxFlowTemp: 151,
+		MinFlowTemp: ptr[int32](10),
+		MaxFlowTemp: ptr[int32](151),
 		CurvePoints: []openapi.FlowTempPoint{},
 	}
-	_, err := service.SetHeatCurveByPoints(context.TODO(), 1, values)
+	_, err := service.SetHeatCurveByPoints(context.TODO(), 1, values, false, "")
 	assert.ErrorContains(t, err, "max flow")
 	apiErr, ok := err.(*api.ApiError)
 	assert.Assert(t, ok, "%T", err)
@@ -236,7 +311,7 @@ func TestSetHeatCurveByPoints__failInvalidCurvePointOutdoorTemp(t *testing.T) {
 			{OutdoorTemp: -7, FlowTemp: 10},
 		},
 	}
-	_, err := service.SetHeatCurveByPoints(context.TODO(), 1, values)
+	_, err := service.SetHeatCurveByPoints(context.TODO(), 1, values, false, "")
 	assert.ErrorContains(t, err, "outdoor temp -7")
 	apiErr, ok := err.(*api.ApiError)
 	assert.Assert(t, ok, "%T", err)
@@ -251,7 +326,7 @@ func TestSetHeatCurveByPoints__failInvalidCurvePointFlowTemp(t *testing.T) {
 			{OutdoorTemp: 0, FlowTemp: 9},
 		},
 	}
-	_, err := service.SetHeatCurveByPoints(context.TODO(), 1, values)
+	_, err := service.SetHeatCurveByPoints(context.TODO(), 1, values, false, "")
 	assert.ErrorContains(t, err, "9 for flow temp")
 	apiErr, ok := err.(*api.ApiError)
 	assert.Assert(t, ok, "%T", err)
@@ -265,6 +340,9 @@ func TestSetHeatCurveByPoints__success(t *testing.T) {
 			case 11175: // slope
 				assert.Equal(t, uint16(1), quantity)
 				return []byte{0, 17}, nil
+			case 11176: // parallel displacement
+				assert.Equal(t, uint16(1), quantity)
+				return []byte{0, 0}, nil
 			case 11177: // min/max
 				assert.Check(t, quantity == 1 || quantity == 2)
 				return []byte{0, 33, 0, 66}[0 : quantity*2], nil
@@ -330,8 +408,8 @@ func TestSetHeatCurveByPoints__success(t *testing.T) {
 	}
 	service := api.NewHeatingApiService(mock)
 	request := openapi.SetHeatCurveByPointsRequest{
-		MinFlowTemp: 30,
-		MaxFlowTemp: 70,
+		MinFlowTemp: ptr[int32](30),
+		MaxFlowTemp: ptr[int32](70),
 		CurvePoints: []openapi.FlowTempPoint{
 			{OutdoorTemp: -30, FlowTemp: 10},
 			{OutdoorTemp: -15, FlowTemp: 11},
@@ -341,26 +419,390 @@ func TestSetHeatCurveByPoints__success(t *testing.T) {
 			{OutdoorTemp: 15, FlowTemp: 15},
 		},
 	}
-	response, err := service.SetHeatCurveByPoints(context.TODO(), 1, request)
+	response, err := service.SetHeatCurveByPoints(context.TODO(), 1, request, false, "")
 	assert.NilError(t, err)
 	assert.Check(t, response.Code == http.StatusOK)
 	if body, ok := response.Body.(openapi.GetHeatCurveResponse); !ok {
 		t.Errorf("Unexpected return type %T\n", body)
 	}
-	assert.Equal(t, 19, len(mock.Calls))
+	assert.Equal(t, 20, len(mock.Calls))
 	for i, call := range mock.Calls {
 		fmt.Printf("%d: %v\n", i, call)
 	}
-	assertDeepEqual(t, mock.Calls[1], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11177), uint16(30)}})
-	assertDeepEqual(t, mock.Calls[3], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11178), uint16(70)}})
-	assertDeepEqual(t, mock.Calls[5], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11400), uint16(10)}})
-	assertDeepEqual(t, mock.Calls[7], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11401), uint16(11)}})
-	assertDeepEqual(t, mock.Calls[9], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11402), uint16(12)}})
-	assertDeepEqual(t, mock.Calls[11], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11403), uint16(13)}})
-	assertDeepEqual(t, mock.Calls[13], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11404), uint16(14)}})
+	assertDeepEqual(t, mock.Calls[8], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11177), uint16(30)}})
+	assertDeepEqual(t, mock.Calls[9], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11178), uint16(70)}})
+	assertDeepEqual(t, mock.Calls[10], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11400), uint16(10)}})
+	assertDeepEqual(t, mock.Calls[11], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11401), uint16(11)}})
+	assertDeepEqual(t, mock.Calls[12], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11402), uint16(12)}})
+	assertDeepEqual(t, mock.Calls[13], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11403), uint16(13)}})
+	assertDeepEqual(t, mock.Calls[14], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11404), uint16(14)}})
 	assertDeepEqual(t, mock.Calls[15], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11405), uint16(15)}})
 }
 
+func TestSetHeatCurveByPoints__rollbackOnWriteFailure(t *testing.T) {
+	writes := map[uint16]int{}
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			assert.Equal(t, uint16(1), quantity)
+			switch address {
+			case 11400, 11401, 11402, 11403:
+				return []byte{0, byte(address - 11400 + 50)}, nil
+			default:
+				t.Errorf("Unexpected address %d", address)
+				t.FailNow()
+				return nil, errors.New("Test failure")
+			}
+		},
+		WriteSingleRegisterMock: func(address, value uint16) ([]byte, error) {
+			writes[address]++
+			switch {
+			case address == 11400:
+				return []byte{}, nil
+			case address == 11401 && writes[address] == 1:
+				return []byte{}, nil
+			case address == 11401 || address == 11402:
+				// the write of 11402 and the restore of 11401 fail
+				return nil, errors.New("Mocked write error")
+			default:
+				t.Errorf("Unexpected address %d", address)
+				t.FailNow()
+				return nil, errors.New("Test failure")
+			}
+		},
+	}
+	service := api.NewHeatingApiService(mock)
+	request := openapi.SetHeatCurveByPointsRequest{
+		CurvePoints: []openapi.FlowTempPoint{
+			{OutdoorTemp: -30, FlowTemp: 10},
+			{OutdoorTemp: -15, FlowTemp: 11},
+			{OutdoorTemp: -5, FlowTemp: 12},
+			{OutdoorTemp: -0, FlowTemp: 13},
+		},
+	}
+	_, err := service.SetHeatCurveByPoints(context.TODO(), 1, request, false, "")
+	txErr, ok := err.(*api.TransactionError)
+	assert.Assert(t, ok, "%T", err)
+	assert.Equal(t, http.StatusBadGateway, txErr.Code)
+	assert.Equal(t, uint16(11402), txErr.Failed.Pnu)
+	assert.Equal(t, 2, len(txErr.Applied))
+	assertDeepEqual(t, txErr.RolledBack, []api.PnuUpdate{{Pnu: 11400, Label: "-30 outdoor temp", OldValue: 50, NewValue: 10}})
+	assertDeepEqual(t, txErr.Inconsistent, []api.PnuUpdate{{Pnu: 11401, Label: "-15 outdoor temp", OldValue: 51, NewValue: 11}})
+	// 4 snapshot reads, 3 writes, 2 restore writes
+	assert.Equal(t, 9, len(mock.Calls))
+	assertDeepEqual(t, mock.Calls[7], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11401), uint16(51)}})
+	assertDeepEqual(t, mock.Calls[8], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11400), uint16(50)}})
+}
+
+func TestSetHeatCurveBySlope__keepsMissingLimits(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			assert.Equal(t, uint16(11175), address)
+			return []byte{0, 17}, nil
+		},
+	}
+	service := api.NewHeatingApiService(mock)
+	response, err := service.SetHeatCurveBySlope(context.TODO(), 1, openapi.SetHeatCurveBySlopeRequest{Slope: -1.8}, true, "")
+	assert.NilError(t, err)
+	body := response.Body.(openapi.DryRunResponse)
+	assertDeepEqual(t, body.Changes, []openapi.PnuChange{{Pnu: 11175, Label: "slope", OldValue: 17, NewValue: 18}})
+}
+
+func TestPatchHeatCurve__changesOnlyGivenMembers(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			switch address {
+			case 11175: // slope
+				return []byte{0, 17}, nil
+			case 11176: // parallel displacement
+				return []byte{0, 0}, nil
+			case 11177: // min/max
+				return []byte{0, 33, 0, 66}, nil
+			case 11178: // max
+				return []byte{0, 66}, nil
+			case 11400: // temperatures: -30, -15, -5, 0, 5, 15
+				return []byte{0, 65, 0, 63, 0, 61, 0, 59, 0, 57, 0, 55}, nil
+			default:
+				t.Errorf("Unexpected address %d", address)
+				t.FailNow()
+				return nil, errors.New("Test failure")
+			}
+		},
+		WriteSingleRegisterMock: func(address, value uint16) ([]byte, error) {
+			return []byte{}, nil
+		},
+	}
+	service := api.NewHeatingApiService(mock)
+	response, err := service.PatchHeatCurve(context.TODO(), 1, openapi.HeatCurvePatch{MaxFlowTemp: ptr[int32](70)}, false, "")
+	assert.NilError(t, err)
+	_, ok := response.Body.(openapi.GetHeatCurveResponse)
+	assert.Assert(t, ok, "%T", response.Body)
+	// snapshot, write, read back the curve
+	assert.Equal(t, 6, len(mock.Calls))
+	assertDeepEqual(t, mock.Calls[0], mocks.Call{FuncName: "ReadHoldingRegisters", Params: []mocks.Param{uint16(11178), uint16(1)}})
+	assertDeepEqual(t, mock.Calls[1], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11178), uint16(70)}})
+}
+
+func TestPatchHeatCurve__writesParallelDisplacement(t *testing.T) {
+	mock := heatCurveMock(t)
+	service := api.NewHeatingApiService(mock)
+	response, err := service.PatchHeatCurve(context.TODO(), 1, openapi.HeatCurvePatch{ParallelDisplacement: ptr[int32](-3)}, true, "")
+	assert.NilError(t, err)
+	body := response.Body.(openapi.DryRunResponse)
+	assertDeepEqual(t, body.Changes, []openapi.PnuChange{{Pnu: 11176, Label: "parallel displacement", OldValue: 0, NewValue: 0xfffd}})
+}
+
+func TestPatchHeatCurve__replacesAllCurvePoints(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			assert.Equal(t, uint16(1), quantity)
+			return []byte{0, 50}, nil
+		},
+	}
+	service := api.NewHeatingApiService(mock)
+	patch := openapi.HeatCurvePatch{
+		CurvePoints: []openapi.FlowTempPoint{
+			{OutdoorTemp: 15, FlowTemp: 35},
+			{OutdoorTemp: 5, FlowTemp: 40},
+			{OutdoorTemp: 0, FlowTemp: 50},
+			{OutdoorTemp: -5, FlowTemp: 50},
+			{OutdoorTemp: -15, FlowTemp: 60},
+			{OutdoorTemp: -30, FlowTemp: 70},
+		},
+	}
+	response, err := service.PatchHeatCurve(context.TODO(), 1, patch, true, "")
+	assert.NilError(t, err)
+	body := response.Body.(openapi.DryRunResponse)
+	assertDeepEqual(t, body.Changes, []openapi.PnuChange{
+		{Pnu: 11405, Label: "15 outdoor temp", OldValue: 50, NewValue: 35},
+		{Pnu: 11404, Label: "5 outdoor temp", OldValue: 50, NewValue: 40},
+		{Pnu: 11401, Label: "-15 outdoor temp", OldValue: 50, NewValue: 60},
+		{Pnu: 11400, Label: "-30 outdoor temp", OldValue: 50, NewValue: 70},
+	})
+}
+
+func TestPatchHeatCurve__failInvalidPatches(t *testing.T) {
+	complete := []openapi.FlowTempPoint{
+		{OutdoorTemp: -30, FlowTemp: 70},
+		{OutdoorTemp: -15, FlowTemp: 60},
+		{OutdoorTemp: -5, FlowTemp: 50},
+		{OutdoorTemp: 0, FlowTemp: 50},
+		{OutdoorTemp: 5, FlowTemp: 40},
+		{OutdoorTemp: 15, FlowTemp: 35},
+	}
+	duplicate := append([]openapi.FlowTempPoint{}, complete...)
+	duplicate[5].OutdoorTemp = 5
+	tests := []struct {
+		name      string
+		patch     openapi.HeatCurvePatch
+		errorCode api.ErrorCode
+		field     string
+	}{
+		{"zero is not missing", openapi.HeatCurvePatch{MinFlowTemp: ptr[int32](0)}, api.ErrValueOutOfRange, "minFlowTemp"},
+		{"slope out of range", openapi.HeatCurvePatch{Slope: ptr[float32](0)}, api.ErrValueOutOfRange, "slope"},
+		{"slope and points", openapi.HeatCurvePatch{Slope: ptr[float32](-1), CurvePoints: complete}, api.ErrMalformedRequest, "curvePoints"},
+		{"incomplete points", openapi.HeatCurvePatch{CurvePoints: complete[1:]}, api.ErrMissingField, "curvePoints"},
+		{"empty points", openapi.HeatCurvePatch{CurvePoints: []openapi.FlowTempPoint{}}, api.ErrMissingField, "curvePoints"},
+		{"duplicate points", openapi.HeatCurvePatch{CurvePoints: duplicate}, api.ErrMalformedRequest, "curvePoints[5].outdoorTemp"},
+		{"displacement out of range", openapi.HeatCurvePatch{ParallelDisplacement: ptr[int32](10)}, api.ErrValueOutOfRange, "parallelDisplacement"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mock := &mocks.ClientMock{}
+			service := api.NewHeatingApiService(mock)
+			_, err := service.PatchHeatCurve(context.TODO(), 1, test.patch, false, "")
+			apiErr, ok := err.(*api.ApiError)
+			assert.Assert(t, ok, "%T", err)
+			assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+			assert.Equal(t, test.errorCode, apiErr.ErrorCode)
+			assert.Equal(t, test.field, apiErr.Field)
+			assert.Equal(t, 0, len(mock.Calls))
+		})
+	}
+}
+
+func heatCurveMock(t *testing.T) *mocks.ClientMock {
+	return &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			switch address {
+			case 11175: // slope
+				return []byte{0, 17}, nil
+			case 11176: // parallel displacement
+				return []byte{0, 0}, nil
+			case 11177: // min/max
+				return []byte{0, 33, 0, 66}, nil
+			case 11178: // max
+				return []byte{0, 66}, nil
+			case 11179: // setback room temperature
+				return []byte{0, 16}, nil
+			case 11180: // desired room temperature
+				return []byte{0, 20}, nil
+			case 4211: // state comfort
+				return []byte{0, 2}, nil
+			case 11400: // temperatures: -30, -15, -5, 0, 5, 15
+				return []byte{0, 65, 0, 63, 0, 61, 0, 59, 0, 57, 0, 55}, nil
+			default:
+				t.Errorf("Unexpected address %d", address)
+				t.FailNow()
+				return nil, errors.New("Test failure")
+			}
+		},
+		WriteSingleRegisterMock: func(address, value uint16) ([]byte, error) {
+			return []byte{}, nil
+		},
+	}
+}
+
+func getHeatCurveETag(t *testing.T, service openapi.HeatingApiServicer) string {
+	handler := etag.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, err := service.GetHeatCurve(r.Context(), 1)
+		assert.NilError(t, err)
+		w.WriteHeader(response.Code)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/heatcurve/1", nil))
+	return w.Header().Get(etag.Header)
+}
+
+func TestPatchHeatCurve__writesIfMatch(t *testing.T) {
+	mock := heatCurveMock(t)
+	service := api.NewHeatingApiService(mock)
+	tag := getHeatCurveETag(t, service)
+	assert.Assert(t, tag != "")
+	mock.Calls = nil
+
+	_, err := service.PatchHeatCurve(context.TODO(), 1, openapi.HeatCurvePatch{MaxFlowTemp: ptr[int32](70)}, false, tag)
+	assert.NilError(t, err)
+	// curve for the ETag, snapshot, write, read back the curve
+	assert.Equal(t, 10, len(mock.Calls))
+	assertDeepEqual(t, mock.Calls[5], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(11178), uint16(70)}})
+}
+
+func TestPatchHeatCurve__failIfChanged(t *testing.T) {
+	mock := heatCurveMock(t)
+	service := api.NewHeatingApiService(mock)
+	tag := getHeatCurveETag(t, service)
+	read := mock.ReadHoldingRegistersMock
+	mock.ReadHoldingRegistersMock = func(address, quantity uint16) ([]byte, error) {
+		if address == 11175 {
+			// another client changed the slope
+			return []byte{0, 18}, nil
+		}
+		return read(address, quantity)
+	}
+
+	_, err := service.PatchHeatCurve(context.TODO(), 1, openapi.HeatCurvePatch{MaxFlowTemp: ptr[int32](70)}, false, tag)
+	apiErr, ok := err.(*api.ApiError)
+	assert.Assert(t, ok, "%T", err)
+	assert.Equal(t, http.StatusPreconditionFailed, apiErr.Code)
+	assert.Equal(t, api.ErrPreconditionFailed, apiErr.ErrorCode)
+	for _, call := range mock.Calls {
+		assert.Check(t, call.FuncName == "ReadHoldingRegisters", "%v", call)
+	}
+}
+
+func TestSetHeatCurveBySlope__requireIfMatch(t *testing.T) {
+	mock := heatCurveMock(t)
+	service := api.NewHeatingApiService(mock, api.WithRequireIfMatch(true))
+
+	_, err := service.SetHeatCurveBySlope(context.TODO(), 1, openapi.SetHeatCurveBySlopeRequest{Slope: -1.8}, false, "")
+	apiErr, ok := err.(*api.ApiError)
+	assert.Assert(t, ok, "%T", err)
+	assert.Equal(t, http.StatusPreconditionRequired, apiErr.Code)
+	assert.Equal(t, api.ErrPreconditionRequired, apiErr.ErrorCode)
+	assert.Equal(t, 0, len(mock.Calls))
+
+	_, err = service.SetHeatCurveBySlope(context.TODO(), 1, openapi.SetHeatCurveBySlopeRequest{Slope: -1.8}, false, "*")
+	assert.NilError(t, err)
+}
+
+func TestEvaluateHeatCurve__interpolates(t *testing.T) {
+	service := api.NewHeatingApiService(heatCurveMock(t))
+	response, err := service.EvaluateHeatCurve(context.TODO(), 1, -7)
+	assert.NilError(t, err)
+	assertDeepEqual(t, response.Body, openapi.HeatCurveEvaluation{OutdoorTemp: -7, FlowTemp: 61.4, RoomTemp: 20, LimitedBy: "NONE"})
+}
+
+func TestEvaluateHeatCurveBatch__extrapolatesAndLimits(t *testing.T) {
+	mock := heatCurveMock(t)
+	read := mock.ReadHoldingRegistersMock
+	mock.ReadHoldingRegistersMock = func(address, quantity uint16) ([]byte, error) {
+		if address == 11177 {
+			// min 58, max 62
+			return []byte{0, 58, 0, 62}, nil
+		}
+		return read(address, quantity)
+	}
+	service := api.NewHeatingApiService(mock)
+	response, err := service.EvaluateHeatCurveBatch(context.TODO(), 1, []float32{-40, -30, -7, 0, 2.5, 20})
+	assert.NilError(t, err)
+	assertDeepEqual(t, response.Body, openapi.HeatCurveEvaluations{Evaluations: []openapi.HeatCurveEvaluation{
+		{OutdoorTemp: -40, FlowTemp: 62, RoomTemp: 20, LimitedBy: "MAX_FLOW_TEMP"},
+		{OutdoorTemp: -30, FlowTemp: 62, RoomTemp: 20, LimitedBy: "MAX_FLOW_TEMP"},
+		{OutdoorTemp: -7, FlowTemp: 61.4, RoomTemp: 20, LimitedBy: "NONE"},
+		{OutdoorTemp: 0, FlowTemp: 59, RoomTemp: 20, LimitedBy: "NONE"},
+		{OutdoorTemp: 2.5, FlowTemp: 58, RoomTemp: 20, LimitedBy: "NONE"},
+		{OutdoorTemp: 20, FlowTemp: 58, RoomTemp: 20, LimitedBy: "MIN_FLOW_TEMP"},
+	}})
+	// the curve, the state and the room temperatures are read once
+	assert.Equal(t, 7, len(mock.Calls))
+}
+
+func TestEvaluateHeatCurve__shiftsForRoomTempAndDisplacement(t *testing.T) {
+	mock := heatCurveMock(t)
+	read := mock.ReadHoldingRegistersMock
+	mock.ReadHoldingRegistersMock = func(address, quantity uint16) ([]byte, error) {
+		switch address {
+		case 11176: // displacement -5
+			return []byte{0xff, 0xfb}, nil
+		case 11180: // 22 °C
+			return []byte{0, 22}, nil
+		}
+		return read(address, quantity)
+	}
+	service := api.NewHeatingApiService(mock)
+	response, err := service.EvaluateHeatCurve(context.TODO(), 1, -7)
+	assert.NilError(t, err)
+	// 61.4 + (22 - 20) * 1.7 * 2.5 - 5
+	assertDeepEqual(t, response.Body, openapi.HeatCurveEvaluation{OutdoorTemp: -7, FlowTemp: 64.9, RoomTemp: 22, LimitedBy: "NONE"})
+}
+
+func TestEvaluateHeatCurve__setbackRoomTempInSetback(t *testing.T) {
+	for _, state := range []byte{0, 3} {
+		mock := heatCurveMock(t)
+		read := mock.ReadHoldingRegistersMock
+		mock.ReadHoldingRegistersMock = func(address, quantity uint16) ([]byte, error) {
+			if address == 4211 {
+				return []byte{0, state}, nil
+			}
+			return read(address, quantity)
+		}
+		service := api.NewHeatingApiService(mock)
+		response, err := service.EvaluateHeatCurve(context.TODO(), 1, -7)
+		assert.NilError(t, err)
+		// 61.4 + (16 - 20) * 1.7 * 2.5
+		assertDeepEqual(t, response.Body, openapi.HeatCurveEvaluation{OutdoorTemp: -7, FlowTemp: 44.4, RoomTemp: 16, LimitedBy: "NONE"})
+	}
+}
+
+func TestEvaluateHeatCurveBatch__failInvalidOutdoorTemps(t *testing.T) {
+	service := api.NewHeatingApiService(&mocks.ClientMock{})
+	tests := map[string][]float32{
+		"outdoor":    {},
+		"outdoor[1]": {0, -61},
+	}
+	for field, outdoor := range tests {
+		_, err := service.EvaluateHeatCurveBatch(context.TODO(), 1, outdoor)
+		apiErr, ok := err.(*api.ApiError)
+		assert.Assert(t, ok, "%T", err)
+		assert.Equal(t, api.ErrValueOutOfRange, apiErr.ErrorCode)
+		assert.Equal(t, field, apiErr.Field)
+	}
+}
+
 func assertDeepEqual(t *testing.T, first any, second any) {
 	assert.Check(t, reflect.DeepEqual(first, second), "%v != %v\n", first, second)
 }
+
+func ptr[T any](value T) *T {
+	return &value
+}
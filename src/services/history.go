@@ -0,0 +1,294 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/treblada/ecl310-rest/codec"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/history"
+	"github.com/treblada/ecl310-rest/logging"
+	wrapper "github.com/treblada/ecl310-rest/modbus"
+)
+
+// HistorySeries is a controller value recorded in the history, the register times the factor
+type HistorySeries struct {
+	Name   string  `json:"name"`
+	Pnu    uint16  `json:"pnu"`
+	Unit   string  `json:"unit,omitempty"`
+	Factor float64 `json:"factor,omitempty"`
+	Signed bool    `json:"signed,omitempty"`
+}
+
+func (s HistorySeries) value(registers codec.Registers) float64 {
+	factor := s.Factor
+	if factor == 0 {
+		factor = 1
+	}
+	raw := float64(registers.Uint16(0))
+	if s.Signed {
+		raw = float64(registers.Int16(0))
+	}
+	// drops the rounding noise of decimal factors, e.g. 1.7000000000000002
+	return math.Round(raw*factor*1e6) / 1e6
+}
+
+/*
+DefaultHistorySeries records the sensors S1 to S6 in tenths of °C, which of them are the
+outdoor, flow or return temperature depends on the application, and of circuits 1 and 2
+the mode, the state and the parameters of the heat curve.
+*/
+func DefaultHistorySeries() []HistorySeries {
+	series := []HistorySeries{}
+	for sensor := uint16(1); sensor <= 6; sensor++ {
+		series = append(series, HistorySeries{Name: fmt.Sprintf("sensor.S%d", sensor), Pnu: 11199 + sensor, Unit: "°C", Factor: 0.1, Signed: true})
+	}
+	for circuitNo := int32(1); circuitNo <= 2; circuitNo++ {
+		prefix := fmt.Sprintf("circuit%d.", circuitNo)
+		series = append(series,
+			HistorySeries{Name: prefix + "mode", Pnu: getCircuitModePnu(circuitNo)},
+			HistorySeries{Name: prefix + "state", Pnu: 4210 + uint16(circuitNo)},
+			HistorySeries{Name: prefix + "slope", Pnu: getSlopePnu(circuitNo), Factor: -0.1},
+			HistorySeries{Name: prefix + "parallelDisplacement", Pnu: getParallelDisplacementPnu(circuitNo), Unit: "K", Signed: true},
+			HistorySeries{Name: prefix + "minFlowTemp", Pnu: getMinMaxPnu(circuitNo), Unit: "°C", Signed: true},
+			HistorySeries{Name: prefix + "maxFlowTemp", Pnu: getMinMaxPnu(circuitNo) + 1, Unit: "°C", Signed: true},
+			HistorySeries{Name: prefix + "setbackRoomTemp", Pnu: getSetbackRoomTempPnu(circuitNo), Unit: "°C", Signed: true},
+			HistorySeries{Name: prefix + "comfortRoomTemp", Pnu: getRoomTempPnu(circuitNo), Unit: "°C", Signed: true},
+		)
+	}
+	return series
+}
+
+// ReadHistorySeries reads a JSON array of series
+func ReadHistorySeries(r io.Reader) ([]HistorySeries, error) {
+	series := []HistorySeries{}
+	if err := json.NewDecoder(r).Decode(&series); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for i, s := range series {
+		if s.Name == "" || s.Pnu == 0 {
+			return nil, fmt.Errorf("series %d: name and pnu required", i)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("series %d: duplicate name %s", i, s.Name)
+		}
+		names[s.Name] = true
+	}
+	return series, nil
+}
+
+type HistoryApiService struct {
+	openapi.HistoryApiService
+	serviceOptions
+	client wrapper.ZeroBasedAddressClientWrapper
+	store  history.Store
+	series []HistorySeries
+}
+
+// NewHistoryApiService answers with FEATURE_DISABLED without a store
+func NewHistoryApiService(client wrapper.ZeroBasedAddressClientWrapper, store history.Store, series []HistorySeries, opts ...ServiceOption) openapi.HistoryApiServicer {
+	if client == nil {
+		panic("No modbus client provided for history API service")
+	}
+	return &HistoryApiService{
+		serviceOptions: newServiceOptions(opts),
+		client:         client,
+		store:          store,
+		series:         series,
+	}
+}
+
+// Maximum number of steps returned for a series
+const maxHistorySteps = 10000
+
+// Default steps, the shortest one giving at most defaultHistorySteps steps is used
+var historySteps = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+const defaultHistorySteps = 1000
+
+func (s *HistoryApiService) GetHistory(ctx context.Context, series []string, from string, to string, step string) (response openapi.ImplResponse, funcErr error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			response, funcErr = handlePanic(panic)
+		}
+	}()
+
+	if s.store == nil {
+		panic(NewApiError(http.StatusNotFound, ErrFeatureDisabled, "History is disabled", nil))
+	}
+
+	selected := s.selectSeries(series)
+	query := history.Query{From: parseTimestamp(from, "from"), To: parseTimestamp(to, "to")}
+	if query.To.IsZero() {
+		query.To = s.now()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-24 * time.Hour)
+	}
+	if query.From.After(query.To) {
+		panic(NewValidationError(ErrValueOutOfRange, "from", fmt.Sprintf("From %s after to %s", from, query.To.Format(time.RFC3339))))
+	}
+	query.Step = historyStep(step, query.To.Sub(query.From))
+	for _, s := range selected {
+		query.Series = append(query.Series, s.Name)
+	}
+
+	points, err := s.store.Query(query)
+	if err != nil {
+		panic(NewApiError(http.StatusInternalServerError, ErrInternal, "Error reading history", err))
+	}
+
+	body := openapi.HistoryResponse{From: query.From, To: query.To, Step: query.Step.String(), Series: make([]openapi.HistorySeries, len(selected))}
+	for i, s := range selected {
+		body.Series[i] = openapi.HistorySeries{Name: s.Name, Pnu: int32(s.Pnu), Unit: s.Unit, Points: make([]openapi.HistoryPoint, len(points[s.Name]))}
+		for j, point := range points[s.Name] {
+			body.Series[i].Points[j] = openapi.HistoryPoint{
+				Time:  point.Time,
+				Min:   float32(point.Min),
+				Max:   float32(point.Max),
+				Avg:   float32(point.Avg()),
+				Count: int32(point.Count),
+			}
+		}
+	}
+	return openapi.Response(http.StatusOK, body), nil
+}
+
+// selectSeries returns the configured series by name, all of them if no names are given
+func (s *HistoryApiService) selectSeries(names []string) []HistorySeries {
+	if len(names) == 0 {
+		return s.series
+	}
+	selected := make([]HistorySeries, len(names))
+	for i, name := range names {
+		found := false
+		for _, series := range s.series {
+			if series.Name == name {
+				selected[i], found = series, true
+			}
+		}
+		if !found {
+			panic(NewValidationError(ErrValueOutOfRange, "series", fmt.Sprintf("Unknown series %q", name)))
+		}
+	}
+	return selected
+}
+
+func historyStep(value string, period time.Duration) time.Duration {
+	if value == "" {
+		for _, step := range historySteps {
+			if period/step < defaultHistorySteps {
+				return step
+			}
+		}
+		return historySteps[len(historySteps)-1]
+	}
+	step, err := time.ParseDuration(value)
+	if err != nil {
+		panic(NewValidationError(ErrInvalidFormat, "step", fmt.Sprintf("Invalid step %q, expected a duration like 15m", value)))
+	}
+	if step < time.Second || period/step >= maxHistorySteps {
+		panic(NewValidationError(ErrValueOutOfRange, "step", fmt.Sprintf("Invalid step %v, must be at least 1s and give at most %d steps", step, maxHistorySteps)))
+	}
+	return step
+}
+
+// StartHistoryPoller records the configured series every interval until the context is done
+func StartHistoryPoller(ctx context.Context, service openapi.HistoryApiServicer, interval time.Duration) {
+	s := service.(*HistoryApiService)
+	job := s.pollers.register("history", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.poll(ctx, job)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// poll records the values read, even if reading some of them failed
+func (s *HistoryApiService) poll(ctx context.Context, job *poller) {
+	start := s.now()
+	values, skipped, err := s.readSeries(ctx)
+	job.skipping(skipped)
+	if len(values) > 0 {
+		if appendErr := s.store.Append(history.Sample{Time: start.UTC().Truncate(time.Second), Values: values}); appendErr != nil {
+			logging.FromContext(ctx).Warn("Error recording history", "error", appendErr)
+			err = appendErr
+		}
+	}
+	job.done(start, err)
+}
+
+/*
+readSeries reads all series at once. If that fails, they are read one by one, so a PNU the
+controller doesn't support only loses its own series, which is returned as skipped. Nothing
+is read while the controller is unavailable.
+*/
+func (s *HistoryApiService) readSeries(ctx context.Context) (values map[string]float64, skipped []string, err error) {
+	logger := logging.FromContext(ctx)
+	ranges := make([]wrapper.ReadRange, len(s.series))
+	for i, series := range s.series {
+		ranges[i] = wrapper.ReadRange{Address: series.Pnu, Quantity: 1}
+	}
+	values = make(map[string]float64, len(s.series))
+	if registers, err := s.tryRead(ctx, ranges...); err == nil {
+		for i, series := range s.series {
+			values[series.Name] = series.value(registers[i])
+		}
+		return values, nil, nil
+	}
+	for i, series := range s.series {
+		registers, err := s.tryRead(ctx, ranges[i])
+		if failure, ok := classifyControllerFailure(err); ok && failure.errorCode == ErrPnuNotSupported {
+			logger.Debug("History series not supported", "series", series.Name, "pnu", series.Pnu)
+			skipped = append(skipped, series.Name)
+			continue
+		} else if err != nil {
+			logger.Warn("Error polling history", "series", series.Name, "pnu", series.Pnu, "error", err)
+			return values, skipped, err
+		}
+		values[series.Name] = series.value(registers[0])
+	}
+	return values, skipped, nil
+}
+
+func (s *HistoryApiService) tryRead(ctx context.Context, ranges ...wrapper.ReadRange) (registers []codec.Registers, err error) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			if panicErr, ok := panic.(error); ok {
+				err = panicErr
+			} else {
+				err = fmt.Errorf("%v", panic)
+			}
+		}
+	}()
+	return readPnus(contextClient(ctx, s.client), s.readPlanner, ranges...), nil
+}
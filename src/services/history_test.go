@@ -0,0 +1,213 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/history"
+	api "github.com/treblada/ecl310-rest/services"
+	"gotest.tools/v3/assert"
+)
+
+// recordingStore signals every appended sample
+type recordingStore struct {
+	mu       sync.Mutex
+	samples  []history.Sample
+	appended chan struct{}
+}
+
+func (s *recordingStore) Append(sample history.Sample) error {
+	s.mu.Lock()
+	s.samples = append(s.samples, sample)
+	s.mu.Unlock()
+	s.appended <- struct{}{}
+	return nil
+}
+
+func (s *recordingStore) Query(query history.Query) (map[string][]history.Point, error) {
+	return nil, nil
+}
+
+var testHistorySeries = []api.HistorySeries{
+	{Name: "sensor.S1", Pnu: 11200, Unit: "°C", Factor: 0.1, Signed: true},
+	{Name: "sensor.S9", Pnu: 11208, Unit: "°C", Factor: 0.1, Signed: true},
+	{Name: "circuit1.slope", Pnu: 11175, Factor: -0.1},
+}
+
+func TestHistoryPoller__recordsSupportedSeries(t *testing.T) {
+	mock := controllerMock(map[uint16]uint16{11200: 0xffce, 11175: 17})
+	readRegisters := mock.ReadHoldingRegistersMock
+	mock.ReadHoldingRegistersMock = func(address, quantity uint16) ([]byte, error) {
+		if address == 11208 {
+			return nil, &modbus.ModbusError{FunctionCode: 3, ExceptionCode: modbus.ExceptionCodeIllegalDataAddress}
+		}
+		return readRegisters(address, quantity)
+	}
+	store := &recordingStore{appended: make(chan struct{}, 1)}
+	hostTime := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	pollers := api.NewPollers()
+	service := api.NewHistoryApiService(mock, store, testHistorySeries, api.WithClock(func() time.Time { return hostTime }), api.WithPollers(pollers))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	api.StartHistoryPoller(ctx, service, time.Hour)
+	select {
+	case <-store.appended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No sample recorded")
+	}
+	store.mu.Lock()
+	assert.DeepEqual(t, store.samples[0].Values, map[string]float64{"sensor.S1": -5, "circuit1.slope": -1.7})
+	store.mu.Unlock()
+
+	health := api.NewHealthApiService(controllerMock(nil), api.WithPollers(pollers))
+	var body openapi.HealthDetails
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		result, _ := health.GetHealthDetails(context.TODO())
+		body = result.Body.(openapi.HealthDetails)
+		if body.Pollers[0].LastPoll != "" {
+			break
+		}
+	}
+	assertDeepEqual(t, body.Pollers, []openapi.PollerStatus{{
+		Name:            "history",
+		IntervalSeconds: 3600,
+		LastPoll:        "2026-03-10T09:00:00Z",
+		LastSuccess:     "2026-03-10T09:00:00Z",
+		Skipped:         []string{"sensor.S9"},
+	}})
+}
+
+func TestHistoryPoller__reportsFailure(t *testing.T) {
+	mock := controllerMock(map[uint16]uint16{11175: 17})
+	store := &recordingStore{appended: make(chan struct{}, 1)}
+	pollers := api.NewPollers()
+	service := api.NewHistoryApiService(mock, store, testHistorySeries, api.WithPollers(pollers))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	api.StartHistoryPoller(ctx, service, time.Hour)
+	health := api.NewHealthApiService(controllerMock(nil), api.WithPollers(pollers))
+	var status openapi.PollerStatus
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		result, _ := health.GetHealthDetails(context.TODO())
+		if status = result.Body.(openapi.HealthDetails).Pollers[0]; status.LastPoll != "" {
+			break
+		}
+	}
+	assert.Equal(t, "", status.LastSuccess)
+	assert.Assert(t, strings.Contains(status.LastError, "PNU 11200 not mocked"), status.LastError)
+	assert.Equal(t, 0, len(store.samples))
+}
+
+func TestGetHistory__downsamples(t *testing.T) {
+	store, err := history.NewFileStore(t.TempDir(), history.Retention{})
+	assert.NilError(t, err)
+	defer store.Close()
+	start := time.Date(2024, 1, 15, 6, 0, 0, 0, time.UTC)
+	for i, value := range []float64{40, 42, 50, 54} {
+		assert.NilError(t, store.Append(history.Sample{Time: start.Add(time.Duration(i) * 10 * time.Minute), Values: map[string]float64{"sensor.S1": value}}))
+	}
+	service := api.NewHistoryApiService(controllerMock(nil), store, testHistorySeries)
+
+	response, err := service.GetHistory(context.TODO(), []string{"sensor.S1", "circuit1.slope"}, "2024-01-15T06:00:00Z", "2024-01-15T07:00:00Z", "20m")
+	assert.NilError(t, err)
+	body := response.Body.(openapi.HistoryResponse)
+	assert.Equal(t, "20m0s", body.Step)
+	assert.Equal(t, 2, len(body.Series))
+	assertDeepEqual(t, body.Series[0], openapi.HistorySeries{
+		Name: "sensor.S1",
+		Pnu:  11200,
+		Unit: "°C",
+		Points: []openapi.HistoryPoint{
+			{Time: start, Min: 40, Max: 42, Avg: 41, Count: 2},
+			{Time: start.Add(20 * time.Minute), Min: 50, Max: 54, Avg: 52, Count: 2},
+		},
+	})
+	assertDeepEqual(t, body.Series[1], openapi.HistorySeries{Name: "circuit1.slope", Pnu: 11175, Points: []openapi.HistoryPoint{}})
+}
+
+func TestGetHistory__defaultRangeAndStep(t *testing.T) {
+	store, err := history.NewFileStore(t.TempDir(), history.Retention{})
+	assert.NilError(t, err)
+	defer store.Close()
+	now := time.Date(2024, 1, 15, 6, 0, 0, 0, time.UTC)
+	service := api.NewHistoryApiService(controllerMock(nil), store, testHistorySeries, api.WithClock(func() time.Time { return now }))
+
+	response, err := service.GetHistory(context.TODO(), nil, "", "", "")
+	assert.NilError(t, err)
+	body := response.Body.(openapi.HistoryResponse)
+	assert.Equal(t, now.Add(-24*time.Hour), body.From)
+	assert.Equal(t, now, body.To)
+	// 1440 steps of a minute are too many
+	assert.Equal(t, "5m0s", body.Step)
+	assert.Equal(t, len(testHistorySeries), len(body.Series))
+}
+
+func TestGetHistory__validation(t *testing.T) {
+	tests := []struct {
+		name      string
+		series    []string
+		from      string
+		step      string
+		errorCode api.ErrorCode
+		field     string
+	}{
+		{"unknown series", []string{"sensor.S2"}, "", "", api.ErrValueOutOfRange, "series"},
+		{"invalid timestamp", nil, "yesterday", "", api.ErrInvalidFormat, "from"},
+		{"from after to", nil, "2024-01-16T00:00:00Z", "", api.ErrValueOutOfRange, "from"},
+		{"invalid step", nil, "", "5 minutes", api.ErrInvalidFormat, "step"},
+		{"too many steps", nil, "", "1s", api.ErrValueOutOfRange, "step"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := api.NewHistoryApiService(controllerMock(nil), &recordingStore{}, testHistorySeries)
+			_, err := service.GetHistory(context.TODO(), test.series, test.from, "2024-01-15T00:00:00Z", test.step)
+			apiErr, ok := err.(*api.ApiError)
+			assert.Assert(t, ok, "%T", err)
+			assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+			assert.Equal(t, test.errorCode, apiErr.ErrorCode)
+			assert.Equal(t, test.field, apiErr.Field)
+		})
+	}
+}
+
+func TestGetHistory__disabled(t *testing.T) {
+	service := api.NewHistoryApiService(controllerMock(nil), nil, testHistorySeries)
+	_, err := service.GetHistory(context.TODO(), nil, "", "", "")
+	apiErr, ok := err.(*api.ApiError)
+	assert.Assert(t, ok, "%T", err)
+	assert.Equal(t, http.StatusNotFound, apiErr.Code)
+	assert.Equal(t, api.ErrFeatureDisabled, apiErr.ErrorCode)
+}
+
+func TestReadHistorySeries(t *testing.T) {
+	series, err := api.ReadHistorySeries(strings.NewReader(`[{"name": "flow", "pnu": 11202, "unit": "°C", "factor": 0.1, "signed": true}]`))
+	assert.NilError(t, err)
+	assertDeepEqual(t, series, []api.HistorySeries{{Name: "flow", Pnu: 11202, Unit: "°C", Factor: 0.1, Signed: true}})
+
+	_, err = api.ReadHistorySeries(strings.NewReader(`[{"name": "flow", "pnu": 11202}, {"name": "flow", "pnu": 11203}]`))
+	assert.ErrorContains(t, err, "duplicate name flow")
+}
@@ -0,0 +1,121 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/treblada/ecl310-rest/generated/openapi"
+)
+
+/*
+Pollers collects the state of the background jobs polling the controller, like the clock
+sync, for the health details. A nil Pollers doesn't report the jobs.
+*/
+type Pollers struct {
+	mu      sync.Mutex
+	pollers []*poller
+}
+
+func NewPollers() *Pollers {
+	return &Pollers{}
+}
+
+// WithPollers reports the state of the background jobs started for the service
+func WithPollers(pollers *Pollers) ServiceOption {
+	return func(o *serviceOptions) {
+		o.pollers = pollers
+	}
+}
+
+// register adds a job polling every interval
+func (p *Pollers) register(name string, interval time.Duration) *poller {
+	job := &poller{name: name, interval: interval}
+	if p == nil {
+		return job
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pollers = append(p.pollers, job)
+	return job
+}
+
+func (p *Pollers) status() []openapi.PollerStatus {
+	if p == nil {
+		return []openapi.PollerStatus{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make([]openapi.PollerStatus, len(p.pollers))
+	for i, job := range p.pollers {
+		status[i] = job.status()
+	}
+	return status
+}
+
+type poller struct {
+	name     string
+	interval time.Duration
+	mu       sync.Mutex
+	lastPoll time.Time
+	// zero if the last poll succeeded
+	lastErrorTime time.Time
+	lastError     error
+	lastSuccess   time.Time
+	skipped       []string
+}
+
+// done records the outcome of a poll, a panic of the poll is recorded as its error
+func (p *poller) done(at time.Time, outcome any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastPoll = at
+	switch err := outcome.(type) {
+	case nil:
+		p.lastSuccess = at
+	case error:
+		p.lastError, p.lastErrorTime = err, at
+	default:
+		p.lastError, p.lastErrorTime = fmt.Errorf("%v", err), at
+	}
+}
+
+// skipping records what the current poll leaves out, nil for nothing
+func (p *poller) skipping(skipped []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.skipped = skipped
+}
+
+func (p *poller) status() openapi.PollerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := openapi.PollerStatus{
+		Name:            p.name,
+		IntervalSeconds: int64(p.interval / time.Second),
+		LastPoll:        formatTime(p.lastPoll),
+		LastSuccess:     formatTime(p.lastSuccess),
+		LastErrorTime:   formatTime(p.lastErrorTime),
+		Skipped:         p.skipped,
+	}
+	if p.lastError != nil {
+		status.LastError = p.lastError.Error()
+	}
+	return status
+}
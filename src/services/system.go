@@ -18,26 +18,33 @@ package api
 
 import (
 	"context"
-	"encoding/binary"
 	"fmt"
-	"log"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/treblada/ecl310-rest/codec"
+	"github.com/treblada/ecl310-rest/etag"
 	"github.com/treblada/ecl310-rest/generated/openapi"
+	"github.com/treblada/ecl310-rest/logging"
 	wrapper "github.com/treblada/ecl310-rest/modbus"
 )
 
 type SystemApiService struct {
 	openapi.SystemApiService
+	serviceOptions
 	client wrapper.ZeroBasedAddressClientWrapper
+	// serializes the writes, so no other request changes the clock between the If-Match check and the commit
+	writeMu sync.Mutex
 }
 
-func NewSystemApiService(client wrapper.ZeroBasedAddressClientWrapper) openapi.SystemApiServicer {
+func NewSystemApiService(client wrapper.ZeroBasedAddressClientWrapper, opts ...ServiceOption) openapi.SystemApiServicer {
 	if client == nil {
 		panic("No modbus client provided for System API service")
 	}
 	return &SystemApiService{
-		client: client,
+		serviceOptions: newServiceOptions(opts),
+		client:         client,
 	}
 }
 
@@ -48,83 +55,51 @@ func (s *SystemApiService) GetSystemInfo(ctx context.Context) (response openapi.
 		}
 	}()
 
-	var pnu19 []byte
-	var pnu34_37 []byte
-	var pnu258 []byte
-	var pnu278_289 []byte
-	var pnu2060_2063 []byte
-	var pnu2099 []byte
-	var err error
-
-	if pnu19, err = s.client.ReadHoldingRegisters(19, 1); err != nil {
-		panic(NewApiError(http.StatusBadGateway, "PNU19", err))
-	}
-	if pnu34_37, err = s.client.ReadHoldingRegisters(34, 4); err != nil {
-		panic(NewApiError(http.StatusBadGateway, "PNU34+4", err))
-	}
-	if pnu258, err = s.client.ReadHoldingRegisters(258, 1); err != nil {
-		panic(NewApiError(http.StatusBadGateway, "PNU258", err))
-	}
-	if pnu278_289, err = s.client.ReadHoldingRegisters(278, 12); err != nil {
-		panic(NewApiError(http.StatusBadGateway, "PNU278+12", err))
-	}
-	if pnu2060_2063, err = s.client.ReadHoldingRegisters(2060, 4); err != nil {
-		panic(NewApiError(http.StatusBadGateway, "PNU2060+4", err))
-	}
-	if pnu2099, err = s.client.ReadHoldingRegisters(2099, 1); err != nil {
-		panic(NewApiError(http.StatusBadGateway, "PNU2099", err))
-	}
+	return openapi.Response(http.StatusOK, s.readSystemInfo(ctx)), nil
+}
 
-	body := openapi.GetSystemInfoResponse{
-		HardwareRevision: fmt.Sprintf("087H%d", binary.BigEndian.Uint16(pnu19)),
-		SoftwareVersion:  int32(binary.BigEndian.Uint16(pnu34_37[2:4])),
-		SerialNumber:     int64(binary.BigEndian.Uint32(pnu34_37[4:8])),
-		AddressType:      decodeAddressType(pnu258),
-		IpAddress: fmt.Sprintf(
-			"%d.%d.%d.%d",
-			binary.BigEndian.Uint16(pnu278_289[0:2]),
-			binary.BigEndian.Uint16(pnu278_289[2:4]),
-			binary.BigEndian.Uint16(pnu278_289[4:6]),
-			binary.BigEndian.Uint16(pnu278_289[6:8]),
-		),
-		Netmask: fmt.Sprintf(
-			"%d.%d.%d.%d",
-			binary.BigEndian.Uint16(pnu278_289[16:18]),
-			binary.BigEndian.Uint16(pnu278_289[18:20]),
-			binary.BigEndian.Uint16(pnu278_289[20:22]),
-			binary.BigEndian.Uint16(pnu278_289[22:24]),
-		),
-		Gateway: fmt.Sprintf(
-			"%d.%d.%d.%d",
-			binary.BigEndian.Uint16(pnu278_289[8:10]),
-			binary.BigEndian.Uint16(pnu278_289[10:12]),
-			binary.BigEndian.Uint16(pnu278_289[12:14]),
-			binary.BigEndian.Uint16(pnu278_289[14:16]),
-		),
+func (s *SystemApiService) readSystemInfo(ctx context.Context) openapi.GetSystemInfoResponse {
+	registers := readPnus(
+		contextClient(ctx, s.client),
+		s.readPlanner,
+		wrapper.ReadRange{Address: 19, Quantity: 1},
+		wrapper.ReadRange{Address: 34, Quantity: 4},
+		wrapper.ReadRange{Address: 258, Quantity: 1},
+		wrapper.ReadRange{Address: 278, Quantity: 12},
+		wrapper.ReadRange{Address: 2060, Quantity: 4},
+		wrapper.ReadRange{Address: 2099, Quantity: 1},
+	)
+	pnu19, pnu34_37, pnu258, pnu278_289, pnu2060_2063, pnu2099 := registers[0], registers[1], registers[2], registers[3], registers[4], registers[5]
+
+	appMajor, appMinor := pnu2060_2063.Bytes(3)
+	productionYear, productionWeek := pnu2099.Bytes(0)
+
+	return openapi.GetSystemInfoResponse{
+		HardwareRevision:   fmt.Sprintf("087H%d", pnu19.Uint16(0)),
+		SoftwareVersion:    int32(pnu34_37.Uint16(1)),
+		SerialNumber:       int64(pnu34_37.Uint32(2)),
+		AddressType:        decodeAddressType(pnu258),
+		IpAddress:          pnu278_289.IPv4(0),
+		Netmask:            pnu278_289.IPv4(8),
+		Gateway:            pnu278_289.IPv4(4),
 		Application:        decodeApplicationName(pnu2060_2063),
-		ApplicationVersion: fmt.Sprintf("%d.%d", pnu2060_2063[6], pnu2060_2063[7]),
-		ProductionYear:     2000 + int32(pnu2099[0]),
-		ProductionWeek:     int32(pnu2099[1]),
+		ApplicationVersion: fmt.Sprintf("%d.%d", appMajor, appMinor),
+		ProductionYear:     2000 + int32(productionYear),
+		ProductionWeek:     int32(productionWeek),
 	}
-	return openapi.Response(http.StatusOK, body), nil
 }
 
-func decodeAddressType(pnu258 []byte) string {
-	switch dhcpFlag := binary.BigEndian.Uint16(pnu258); dhcpFlag {
-	case 0:
-		return "DHCP"
-	case 1:
-		return "STATIC"
-	default:
-		panic(fmt.Errorf("invalid address type %d on PNU 258", dhcpFlag))
+func decodeAddressType(pnu258 codec.Registers) string {
+	if name, ok := addressTypes.Lookup(pnu258.Uint16(0)); ok {
+		return name
 	}
+	err := NewApiError(http.StatusBadGateway, ErrInvalidControllerData, fmt.Sprintf("Invalid address type %d on PNU 258", pnu258.Uint16(0)), nil)
+	err.Pnu = 258
+	panic(err)
 }
 
-func decodeApplicationName(pnu2060_2063 []byte) string {
-	appPrefix := string(rune(binary.BigEndian.Uint16(pnu2060_2063[0:2])))
-	appType := binary.BigEndian.Uint16(pnu2060_2063[2:4])
-	appSubType := binary.BigEndian.Uint16(pnu2060_2063[4:6])
-	return fmt.Sprintf("%v%d.%d", appPrefix, appType, appSubType)
+func decodeApplicationName(pnu2060_2063 codec.Registers) string {
+	return fmt.Sprintf("%v%d.%d", pnu2060_2063.Char(0), pnu2060_2063.Uint16(1), pnu2060_2063.Uint16(2))
 }
 
 func (s *SystemApiService) GetSystemCircuit(ctx context.Context, circuitNo int32) (response openapi.ImplResponse, funcErr error) {
@@ -134,31 +109,29 @@ func (s *SystemApiService) GetSystemCircuit(ctx context.Context, circuitNo int32
 		}
 	}()
 
-	if circuitNo < 1 || circuitNo > 3 {
-		return openapi.ImplResponse{}, NewApiError(400, "Circuit number must be in [1-3]", nil)
-	}
-
-	var circMode []byte
-	var circState []byte
-	var err error
+	assertValidCircuit(circuitNo)
 
-	modeAddr := 4200 + circuitNo
-	stateAddr := 4210 + circuitNo
+	modeAddr := getCircuitModePnu(circuitNo)
+	stateAddr := getCircuitStatePnu(circuitNo)
 
-	if circMode, err = s.client.ReadHoldingRegisters(uint16(modeAddr), 1); err != nil {
-		panic(NewApiError(http.StatusBadGateway, fmt.Sprintf("PNU%d", modeAddr), err))
-	}
-	if circState, err = s.client.ReadHoldingRegisters(uint16(stateAddr), 1); err != nil {
-		panic(NewApiError(http.StatusBadGateway, fmt.Sprintf("PNU%d", stateAddr), err))
-	}
+	registers := readPnus(contextClient(ctx, s.client), s.readPlanner, wrapper.ReadRange{Address: modeAddr, Quantity: 1}, wrapper.ReadRange{Address: stateAddr, Quantity: 1})
+	circMode, circState := registers[0], registers[1]
 
 	body := openapi.GetSystemCircuitResponse{
-		Mode:   GetCircuitMode(binary.BigEndian.Uint16(circMode)).String(),
-		Status: GetCircuitState(binary.BigEndian.Uint16(circState)).String(),
+		Mode:   GetCircuitMode(circMode.Uint16(0)).String(),
+		Status: GetCircuitState(circState.Uint16(0)).String(),
 	}
 	return openapi.Response(200, body), nil
 }
 
+func getCircuitModePnu(circuitNo int32) uint16 {
+	return 4200 + uint16(circuitNo)
+}
+
+func getCircuitStatePnu(circuitNo int32) uint16 {
+	return 4210 + uint16(circuitNo)
+}
+
 func (s *SystemApiService) GetSystemCircuits(ctx context.Context) (response openapi.ImplResponse, funcErr error) {
 	defer func() {
 		if panic := recover(); panic != nil {
@@ -166,43 +139,36 @@ func (s *SystemApiService) GetSystemCircuits(ctx context.Context) (response open
 		}
 	}()
 
-	var circModes []byte
-	var circStates []byte
-	var err error
-
 	var modeBaseAddr uint16 = 4200
 	var stateBaseAddr uint16 = 4210
 
-	if circModes, err = s.client.ReadHoldingRegisters(modeBaseAddr+1, 2); err != nil {
-		panic(NewApiError(http.StatusBadGateway, "PNU4201+2", err))
-	}
-	if circStates, err = s.client.ReadHoldingRegisters(stateBaseAddr+1, 2); err != nil {
-		panic(NewApiError(http.StatusBadGateway, "PNU4211+2", err))
-	}
+	registers := readPnus(contextClient(ctx, s.client), s.readPlanner, wrapper.ReadRange{Address: modeBaseAddr + 1, Quantity: 2}, wrapper.ReadRange{Address: stateBaseAddr + 1, Quantity: 2})
+	circModes, circStates := registers[0], registers[1]
 
 	heating := openapi.GetSystemCircuitResponse{
-		Mode:   GetCircuitMode(binary.BigEndian.Uint16(circModes[:2])).String(),
-		Status: GetCircuitState(binary.BigEndian.Uint16(circStates[:2])).String(),
+		Mode:   GetCircuitMode(circModes.Uint16(0)).String(),
+		Status: GetCircuitState(circStates.Uint16(0)).String(),
 	}
 	warmWater := openapi.GetSystemCircuitResponse{
-		Mode:   GetCircuitMode(binary.BigEndian.Uint16(circModes[2:4])).String(),
-		Status: GetCircuitState(binary.BigEndian.Uint16(circStates[2:4])).String(),
+		Mode:   GetCircuitMode(circModes.Uint16(1)).String(),
+		Status: GetCircuitState(circStates.Uint16(1)).String(),
 	}
 
 	// this should be a pointer, unfortunatelly the openapi-generator does not seem to support it
 	circ3 := openapi.GetSystemCircuitResponse{}
+	client := contextClient(ctx, s.client)
 
-	if circModes, err = s.client.ReadHoldingRegisters(modeBaseAddr+3, 1); err == nil {
-		if circStates, err = s.client.ReadHoldingRegisters(stateBaseAddr+3, 1); err == nil {
+	if circ3Mode, err := client.ReadHoldingRegisters(modeBaseAddr+3, 1); err == nil {
+		if circ3State, err := client.ReadHoldingRegisters(stateBaseAddr+3, 1); err == nil {
 			circ3 = openapi.GetSystemCircuitResponse{
-				Mode:   GetCircuitMode(binary.BigEndian.Uint16(circModes[:2])).String(),
-				Status: GetCircuitState(binary.BigEndian.Uint16(circStates[:2])).String(),
+				Mode:   GetCircuitMode(codec.Registers(circ3Mode).Uint16(0)).String(),
+				Status: GetCircuitState(codec.Registers(circ3State).Uint16(0)).String(),
 			}
 		} else {
-			log.Printf("Error reading PNU 4213: %v", err)
+			logging.FromContext(ctx).Warn("Error reading circuit 3 state", "pnu", stateBaseAddr+3, "error", err)
 		}
 	} else {
-		log.Printf("Error reading PNU 4203: %v", err)
+		logging.FromContext(ctx).Warn("Error reading circuit 3 mode", "pnu", modeBaseAddr+3, "error", err)
 	}
 
 	body := openapi.GetSystemCircuitsResponse{
@@ -221,7 +187,8 @@ func (s *SystemApiService) GetSystemDateTime(ctx context.Context) (response open
 		}
 	}()
 
-	body := s.getDateTime()
+	body, tag := s.getDateTime(ctx)
+	etag.Set(ctx, tag)
 	return openapi.Response(http.StatusOK, body), nil
 }
 
@@ -232,102 +199,145 @@ var pnuMonth uint16 = 64048
 var pnuYear uint16 = 64049
 var pnuDst uint16 = 10198
 
-func (s *SystemApiService) getDateTime() openapi.GetSystemDateTime {
-	var datetime []byte
-	var dst []byte
-	var err error
-
-	if datetime, err = s.client.ReadHoldingRegisters(pnuHour, 5); err != nil {
-		panic(NewApiError(http.StatusBadGateway, fmt.Sprintf("PNU%d:5", pnuHour), err))
-	}
-
-	if dst, err = s.client.ReadHoldingRegisters(pnuDst, 1); err != nil {
-		panic(NewApiError(http.StatusBadGateway, fmt.Sprintf("PNU%d:1", pnuDst), err))
-	}
+// getDateTime returns the controller's clock and the ETag of its registers, which changes every minute
+func (s *SystemApiService) getDateTime(ctx context.Context) (openapi.GetSystemDateTime, string) {
+	return readDateTime(ctx, s.client, s.serviceOptions)
+}
 
-	return openapi.GetSystemDateTime{
-		Hour:               int32(binary.BigEndian.Uint16(datetime[0:2])),
-		Minute:             int32(binary.BigEndian.Uint16(datetime[2:4])),
-		Day:                int32(binary.BigEndian.Uint16(datetime[4:6])),
-		Month:              int32(binary.BigEndian.Uint16(datetime[6:8])),
-		Year:               int32(binary.BigEndian.Uint16(datetime[8:10])),
-		AutoDaylightSaving: binary.BigEndian.Uint16(dst) == uint16(1),
+// readDateTime reads the controller's clock and compares it with the host's in the configured time zone
+func readDateTime(ctx context.Context, client wrapper.ZeroBasedAddressClientWrapper, options serviceOptions) (openapi.GetSystemDateTime, string) {
+	registers := readPnus(contextClient(ctx, client), options.readPlanner, wrapper.ReadRange{Address: pnuHour, Quantity: 5}, wrapper.ReadRange{Address: pnuDst, Quantity: 1})
+	datetime, dst := registers[0], registers[1]
+
+	body := openapi.GetSystemDateTime{
+		Hour:               int32(datetime.Uint16(0)),
+		Minute:             int32(datetime.Uint16(1)),
+		Day:                int32(datetime.Uint16(2)),
+		Month:              int32(datetime.Uint16(3)),
+		Year:               int32(datetime.Uint16(4)),
+		AutoDaylightSaving: dst.Uint16(0) == uint16(1),
 	}
+	body.Iso = controllerInstant(body, options.timeZone)
+	body.DriftSeconds = int32(body.Iso.Sub(options.now()).Round(time.Second) / time.Second)
+	return body, etag.Compute(datetime, dst)
 }
 
-func (s *SystemApiService) SetSystemDateTime(ctx context.Context, newDateTime openapi.GetSystemDateTime) (response openapi.ImplResponse, funcErr error) {
+func (s *SystemApiService) SetSystemDateTime(ctx context.Context, request openapi.SetSystemDateTimeRequest, dryRun bool, ifMatch string) (response openapi.ImplResponse, funcErr error) {
 	defer func() {
 		if panic := recover(); panic != nil {
 			response, funcErr = handlePanic(panic)
 		}
 	}()
 
+	newDateTime := s.requestedDateTime(request, func() bool {
+		current, _ := s.getDateTime(ctx)
+		return current.AutoDaylightSaving
+	})
+	assertValidDateTime(newDateTime)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	now, currentETag := s.getDateTime(ctx)
+	s.assertIfMatch(ifMatch, func() string { return currentETag })
+	tx := newPnuTransaction(ctx, s.client, s.serviceOptions, 0)
+	updateDateTime(tx, now, newDateTime)
+	tx.update(pnuDst, codec.EncodeBool(newDateTime.AutoDaylightSaving), "DST")
+
+	if dryRun {
+		return dryRunResponse(tx.diff()), nil
+	}
+
+	tx.commit()
+
+	return s.GetSystemDateTime(ctx)
+}
+
+/*
+requestedDateTime converts an iso timestamp into the controller's local time. If the date
+and time fields are given too, they must agree with it. Without autoDaylightSaving the
+controller's current setting is read and kept.
+*/
+func (s *SystemApiService) requestedDateTime(request openapi.SetSystemDateTimeRequest, currentDaylightSaving func() bool) openapi.GetSystemDateTime {
+	var autoDaylightSaving bool
+	if request.AutoDaylightSaving != nil {
+		autoDaylightSaving = *request.AutoDaylightSaving
+	} else {
+		autoDaylightSaving = currentDaylightSaving()
+	}
+	dateTime := openapi.GetSystemDateTime{
+		Year:               request.Year,
+		Month:              request.Month,
+		Day:                request.Day,
+		Hour:               request.Hour,
+		Minute:             request.Minute,
+		AutoDaylightSaving: autoDaylightSaving,
+	}
+	if request.Iso.IsZero() {
+		return dateTime
+	}
+	fromIso := toSystemDateTime(controllerClock(request.Iso.In(s.timeZone), autoDaylightSaving), autoDaylightSaving)
+	if request.Year != 0 && wallTime(dateTime) != wallTime(fromIso) {
+		panic(NewValidationError(ErrMalformedRequest, "iso", fmt.Sprintf("Timestamp %s is %s in the controller's time, not the given date and time", request.Iso.Format(time.RFC3339), wallTime(fromIso).Format("2006-01-02 15:04"))))
+	}
+	return fromIso
+}
+
+func assertValidDateTime(newDateTime openapi.GetSystemDateTime) {
 	if newDateTime.Year < 2009 || newDateTime.Year > 2099 {
-		panic(NewApiError(http.StatusBadRequest, fmt.Sprintf("Invalid year %d [2009, 2099]", newDateTime.Year), nil))
+		panic(NewValidationError(ErrValueOutOfRange, "year", fmt.Sprintf("Invalid year %d [2009, 2099]", newDateTime.Year)))
 	}
 	if newDateTime.Month < 1 || newDateTime.Month > 12 {
-		panic(NewApiError(http.StatusBadRequest, fmt.Sprintf("Invalid month %d [1, 12]", newDateTime.Month), nil))
+		panic(NewValidationError(ErrValueOutOfRange, "month", fmt.Sprintf("Invalid month %d [1, 12]", newDateTime.Month)))
 	}
 	if newDateTime.Hour < 0 || newDateTime.Hour > 23 {
-		panic(NewApiError(http.StatusBadRequest, fmt.Sprintf("Invalid hour %d [0, 23]", newDateTime.Hour), nil))
+		panic(NewValidationError(ErrValueOutOfRange, "hour", fmt.Sprintf("Invalid hour %d [0, 23]", newDateTime.Hour)))
 	}
 	if newDateTime.Minute < 0 || newDateTime.Minute > 59 {
-		panic(NewApiError(http.StatusBadRequest, fmt.Sprintf("Invalid minute %d [0, 59]", newDateTime.Minute), nil))
+		panic(NewValidationError(ErrValueOutOfRange, "minute", fmt.Sprintf("Invalid minute %d [0, 59]", newDateTime.Minute)))
 	}
 
-	daysPerMonth := map[int32]int32{1: 31, 2: 29, 3: 31, 4: 30, 5: 31, 6: 30, 7: 31, 8: 31, 9: 30, 10: 31, 11: 30, 12: 31}
-
 	if daysPerMonth[newDateTime.Month] < newDateTime.Day {
-		panic(NewApiError(
-			http.StatusBadRequest,
+		panic(NewValidationError(
+			ErrInvalidDate,
+			"day",
 			fmt.Sprintf("Invalid day %d for month %d [%d]", newDateTime.Day, newDateTime.Month, daysPerMonth[newDateTime.Month]),
-			nil,
 		))
 	}
 
 	if newDateTime.Month == 2 {
 		if newDateTime.Day > 28 && !isLeapYear(newDateTime.Year) {
-			panic(NewApiError(http.StatusBadRequest, fmt.Sprintf("Invalid day %d for month %d", newDateTime.Day, newDateTime.Month), nil))
+			panic(NewValidationError(ErrInvalidDate, "day", fmt.Sprintf("Invalid day %d for month %d", newDateTime.Day, newDateTime.Month)))
 		}
 	}
+}
 
-	now := s.getDateTime()
+var daysPerMonth = map[int32]int32{1: 31, 2: 29, 3: 31, 4: 30, 5: 31, 6: 30, 7: 31, 8: 31, 9: 30, 10: 31, 11: 30, 12: 31}
 
+// updateDateTime orders the writes of the date, so the controller never sees a day not existing in the month
+func updateDateTime(tx *pnuTransaction, now openapi.GetSystemDateTime, newDateTime openapi.GetSystemDateTime) {
 	if newDateTime.Month == 2 && newDateTime.Day == 29 {
 		// must be a leap year, otherwise we would have triggered a panic before
 		// year, day, month
-		updateSinglePnu(s.client, pnuYear, uint16(newDateTime.Year), "year")
-		updateSinglePnu(s.client, pnuDay, uint16(newDateTime.Day), "day")
-		updateSinglePnu(s.client, pnuMonth, uint16(newDateTime.Month), "month")
+		tx.update(pnuYear, uint16(newDateTime.Year), "year")
+		tx.update(pnuDay, uint16(newDateTime.Day), "day")
+		tx.update(pnuMonth, uint16(newDateTime.Month), "month")
 	} else if daysPerMonth[newDateTime.Month] > daysPerMonth[now.Month] {
 		// month, day, year
-		updateSinglePnu(s.client, pnuMonth, uint16(newDateTime.Month), "month")
-		updateSinglePnu(s.client, pnuDay, uint16(newDateTime.Day), "day")
-		updateSinglePnu(s.client, pnuYear, uint16(newDateTime.Year), "year")
+		tx.update(pnuMonth, uint16(newDateTime.Month), "month")
+		tx.update(pnuDay, uint16(newDateTime.Day), "day")
+		tx.update(pnuYear, uint16(newDateTime.Year), "year")
 	} else {
 		// day, month, year
-		updateSinglePnu(s.client, pnuDay, uint16(newDateTime.Day), "day")
-		updateSinglePnu(s.client, pnuMonth, uint16(newDateTime.Month), "month")
-		updateSinglePnu(s.client, pnuYear, uint16(newDateTime.Year), "year")
+		tx.update(pnuDay, uint16(newDateTime.Day), "day")
+		tx.update(pnuMonth, uint16(newDateTime.Month), "month")
+		tx.update(pnuYear, uint16(newDateTime.Year), "year")
 	}
 
-	updateSinglePnu(s.client, pnuHour, uint16(newDateTime.Hour), "hour")
-	updateSinglePnu(s.client, pnuMinute, uint16(newDateTime.Minute), "minute")
-
-	updateSinglePnu(s.client, pnuDst, boolToUint16(newDateTime.AutoDaylightSaving), "DST")
-
-	return s.GetSystemDateTime(ctx)
+	tx.update(pnuHour, uint16(newDateTime.Hour), "hour")
+	tx.update(pnuMinute, uint16(newDateTime.Minute), "minute")
 }
 
 func isLeapYear(year int32) bool {
 	// we ignore 100/400 year rules, b/c valid range is 2009-2099
 	return year%4 == 0
 }
-
-func boolToUint16(value bool) uint16 {
-	if value {
-		return 1
-	} else {
-		return 0
-	}
-}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/treblada/ecl310-rest/generated/openapi"
 	"github.com/treblada/ecl310-rest/mocks"
@@ -134,6 +135,23 @@ func TestGetSystemCircuit__success(t *testing.T) {
 	assert.Check(t, body.Status == api.PreComfort.String())
 }
 
+func TestGetSystemCircuit__unknownMode(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			if address == 4201 {
+				return []byte{0, 9}, nil
+			}
+			return []byte{0, 2}, nil
+		},
+	}
+	service := api.NewSystemApiService(mock)
+	response, err := service.GetSystemCircuit(context.TODO(), 1)
+	assert.NilError(t, err)
+	body := response.Body.(openapi.GetSystemCircuitResponse)
+	assert.Equal(t, "UNKNOWN(9)", body.Mode)
+	assert.Equal(t, "COMFORT", body.Status)
+}
+
 func TestGetSystemCircuit__invalidRequestParam(t *testing.T) {
 	mock := &mocks.ClientMock{
 		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
@@ -237,8 +255,8 @@ func TestGetSystemDateTime__success(t *testing.T) {
 func TestSetSystemDateTime__invalidYear(t *testing.T) {
 	mock := &mocks.ClientMock{}
 	service := api.NewSystemApiService(mock)
-	request := openapi.GetSystemDateTime{Year: 1999, Month: 2, Day: 1, Hour: 12, Minute: 2, AutoDaylightSaving: false}
-	_, err := service.SetSystemDateTime(context.TODO(), request)
+	request := openapi.SetSystemDateTimeRequest{Year: 1999, Month: 2, Day: 1, Hour: 12, Minute: 2, AutoDaylightSaving: ptr(false)}
+	_, err := service.SetSystemDateTime(context.TODO(), request, false, "")
 	assert.ErrorContains(t, err, "year 1999")
 	apiError := err.(*api.ApiError)
 	assert.Equal(t, apiError.Code, http.StatusBadRequest)
@@ -247,8 +265,8 @@ func TestSetSystemDateTime__invalidYear(t *testing.T) {
 func TestSetSystemDateTime__invalidMonth(t *testing.T) {
 	mock := &mocks.ClientMock{}
 	service := api.NewSystemApiService(mock)
-	request := openapi.GetSystemDateTime{Year: 2009, Month: 13, Day: 1, Hour: 12, Minute: 2, AutoDaylightSaving: false}
-	_, err := service.SetSystemDateTime(context.TODO(), request)
+	request := openapi.SetSystemDateTimeRequest{Year: 2009, Month: 13, Day: 1, Hour: 12, Minute: 2, AutoDaylightSaving: ptr(false)}
+	_, err := service.SetSystemDateTime(context.TODO(), request, false, "")
 	assert.ErrorContains(t, err, "month 13")
 	apiError := err.(*api.ApiError)
 	assert.Equal(t, apiError.Code, http.StatusBadRequest)
@@ -257,8 +275,8 @@ func TestSetSystemDateTime__invalidMonth(t *testing.T) {
 func TestSetSystemDateTime__invalidDay(t *testing.T) {
 	mock := &mocks.ClientMock{}
 	service := api.NewSystemApiService(mock)
-	request := openapi.GetSystemDateTime{Year: 2009, Month: 2, Day: 32, Hour: 12, Minute: 2, AutoDaylightSaving: false}
-	_, err := service.SetSystemDateTime(context.TODO(), request)
+	request := openapi.SetSystemDateTimeRequest{Year: 2009, Month: 2, Day: 32, Hour: 12, Minute: 2, AutoDaylightSaving: ptr(false)}
+	_, err := service.SetSystemDateTime(context.TODO(), request, false, "")
 	assert.ErrorContains(t, err, "day 32")
 	apiError := err.(*api.ApiError)
 	assert.Equal(t, apiError.Code, http.StatusBadRequest)
@@ -267,8 +285,8 @@ func TestSetSystemDateTime__invalidDay(t *testing.T) {
 func TestSetSystemDateTime__invalidHour(t *testing.T) {
 	mock := &mocks.ClientMock{}
 	service := api.NewSystemApiService(mock)
-	request := openapi.GetSystemDateTime{Year: 2009, Month: 2, Day: 1, Hour: 24, Minute: 2, AutoDaylightSaving: false}
-	_, err := service.SetSystemDateTime(context.TODO(), request)
+	request := openapi.SetSystemDateTimeRequest{Year: 2009, Month: 2, Day: 1, Hour: 24, Minute: 2, AutoDaylightSaving: ptr(false)}
+	_, err := service.SetSystemDateTime(context.TODO(), request, false, "")
 	assert.ErrorContains(t, err, "hour 24")
 	apiError := err.(*api.ApiError)
 	assert.Equal(t, apiError.Code, http.StatusBadRequest)
@@ -277,8 +295,8 @@ func TestSetSystemDateTime__invalidHour(t *testing.T) {
 func TestSetSystemDateTime__invalidMinute(t *testing.T) {
 	mock := &mocks.ClientMock{}
 	service := api.NewSystemApiService(mock)
-	request := openapi.GetSystemDateTime{Year: 2009, Month: 2, Day: 1, Hour: 10, Minute: 60, AutoDaylightSaving: false}
-	_, err := service.SetSystemDateTime(context.TODO(), request)
+	request := openapi.SetSystemDateTimeRequest{Year: 2009, Month: 2, Day: 1, Hour: 10, Minute: 60, AutoDaylightSaving: ptr(false)}
+	_, err := service.SetSystemDateTime(context.TODO(), request, false, "")
 	assert.ErrorContains(t, err, "minute 60")
 	apiError := err.(*api.ApiError)
 	assert.Equal(t, apiError.Code, http.StatusBadRequest)
@@ -287,8 +305,8 @@ func TestSetSystemDateTime__invalidMinute(t *testing.T) {
 func TestSetSystemDateTime__invalidDaysInFebruaryNotLeapYear(t *testing.T) {
 	mock := &mocks.ClientMock{}
 	service := api.NewSystemApiService(mock)
-	request := openapi.GetSystemDateTime{Year: 2009, Month: 2, Day: 29, Hour: 10, Minute: 11, AutoDaylightSaving: false}
-	_, err := service.SetSystemDateTime(context.TODO(), request)
+	request := openapi.SetSystemDateTimeRequest{Year: 2009, Month: 2, Day: 29, Hour: 10, Minute: 11, AutoDaylightSaving: ptr(false)}
+	_, err := service.SetSystemDateTime(context.TODO(), request, false, "")
 	assert.ErrorContains(t, err, "day 29 for month 2")
 	apiError := err.(*api.ApiError)
 	assert.Equal(t, apiError.Code, http.StatusBadRequest)
@@ -297,8 +315,8 @@ func TestSetSystemDateTime__invalidDaysInFebruaryNotLeapYear(t *testing.T) {
 func TestSetSystemDateTime__invalidDaysInFebruaryLeapYear(t *testing.T) {
 	mock := &mocks.ClientMock{}
 	service := api.NewSystemApiService(mock)
-	request := openapi.GetSystemDateTime{Year: 2016, Month: 2, Day: 30, Hour: 10, Minute: 11, AutoDaylightSaving: false}
-	_, err := service.SetSystemDateTime(context.TODO(), request)
+	request := openapi.SetSystemDateTimeRequest{Year: 2016, Month: 2, Day: 30, Hour: 10, Minute: 11, AutoDaylightSaving: ptr(false)}
+	_, err := service.SetSystemDateTime(context.TODO(), request, false, "")
 	assert.ErrorContains(t, err, "day 30 for month 2")
 	apiError := err.(*api.ApiError)
 	assert.Equal(t, apiError.Code, http.StatusBadRequest)
@@ -328,16 +346,133 @@ func TestSetSystemDateTime__updateMoreDayInNewMonthThanCurrent(t *testing.T) {
 		},
 	}
 	service := api.NewSystemApiService(mock)
-	request := openapi.GetSystemDateTime{Year: 2016, Month: 3, Day: 5, Hour: 9, Minute: 13, AutoDaylightSaving: false}
-	_, err := service.SetSystemDateTime(context.TODO(), request)
+	request := openapi.SetSystemDateTimeRequest{Year: 2016, Month: 3, Day: 5, Hour: 9, Minute: 13, AutoDaylightSaving: ptr(false)}
+	_, err := service.SetSystemDateTime(context.TODO(), request, false, "")
 	assert.NilError(t, err)
 	for i, call := range mock.Calls {
 		fmt.Printf("% 2d. %v\n", i, call)
 	}
 	assert.Equal(t, 16, len(mock.Calls))
-	assertDeepEqual(t, mock.Calls[3], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(64048), uint16(3)}})    // month
-	assertDeepEqual(t, mock.Calls[5], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(64047), uint16(5)}})    // day
-	assertDeepEqual(t, mock.Calls[7], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(64049), uint16(2016)}}) // year
-	assertDeepEqual(t, mock.Calls[9], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(64045), uint16(9)}})    // hour
-	assertDeepEqual(t, mock.Calls[11], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(64046), uint16(13)}})  // minute
+	assertDeepEqual(t, mock.Calls[8], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(64048), uint16(3)}})     // month
+	assertDeepEqual(t, mock.Calls[9], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(64047), uint16(5)}})     // day
+	assertDeepEqual(t, mock.Calls[10], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(64049), uint16(2016)}}) // year
+	assertDeepEqual(t, mock.Calls[11], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(64045), uint16(9)}})    // hour
+	assertDeepEqual(t, mock.Calls[12], mocks.Call{FuncName: "WriteSingleRegister", Params: []mocks.Param{uint16(64046), uint16(13)}})   // minute
+}
+
+func TestSetSystemDateTime__dryRun(t *testing.T) {
+	mock := &mocks.ClientMock{
+		ReadHoldingRegistersMock: func(address, quantity uint16) ([]byte, error) {
+			switch {
+			case address >= 64045 && address <= 64049:
+				from := (address - 64045) * 2
+				to := (address + quantity - 64045) * 2
+				return []byte{0, 10, 0, 11, 0, 14, 0, 2, 7, 229}[from:to], nil
+			case address == 10198:
+				assert.Equal(t, uint16(1), quantity)
+				return []byte{0, 1}, nil
+			default:
+				t.Errorf("Unexpected address %d", address)
+				t.FailNow()
+				return nil, errors.New("Test failure")
+			}
+		},
+	}
+	service := api.NewSystemApiService(mock)
+	request := openapi.SetSystemDateTimeRequest{Year: 2021, Month: 2, Day: 14, Hour: 9, Minute: 11, AutoDaylightSaving: ptr(true)}
+	response, err := service.SetSystemDateTime(context.TODO(), request, true, "")
+	assert.NilError(t, err)
+	body, ok := response.Body.(openapi.DryRunResponse)
+	assert.Assert(t, ok, "%T", response.Body)
+	assertDeepEqual(t, body.Changes, []openapi.PnuChange{{Pnu: 64045, Label: "hour", OldValue: 10, NewValue: 9}})
+	for _, call := range mock.Calls {
+		assert.Check(t, call.FuncName == "ReadHoldingRegisters", "%v", call)
+	}
+}
+
+func berlinSystemService(t *testing.T, registers map[uint16]uint16, now string) openapi.SystemApiServicer {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NilError(t, err)
+	hostTime := mustParseTime(t, now)
+	return api.NewSystemApiService(controllerMock(registers), api.WithTimeZone(berlin), api.WithClock(func() time.Time { return hostTime }))
+}
+
+func TestGetSystemDateTime__isoAndDrift(t *testing.T) {
+	service := berlinSystemService(t, clockRegisters(2026, 7, 1, 14, 5, true), "2026-07-01T12:04:30Z")
+	response, err := service.GetSystemDateTime(context.TODO())
+	assert.NilError(t, err)
+	body := response.Body.(openapi.GetSystemDateTime)
+	assert.Equal(t, "2026-07-01T14:05:00+02:00", body.Iso.Format(time.RFC3339))
+	assert.Equal(t, int32(30), body.DriftSeconds)
+
+	// without automatic daylight saving the controller shows standard time
+	service = berlinSystemService(t, clockRegisters(2026, 7, 1, 13, 4, false), "2026-07-01T12:04:30Z")
+	response, err = service.GetSystemDateTime(context.TODO())
+	assert.NilError(t, err)
+	body = response.Body.(openapi.GetSystemDateTime)
+	assert.Equal(t, "2026-07-01T13:04:00+01:00", body.Iso.Format(time.RFC3339))
+	assert.Equal(t, int32(-30), body.DriftSeconds)
+}
+
+func TestSetSystemDateTime__iso(t *testing.T) {
+	tests := []struct {
+		name               string
+		autoDaylightSaving bool
+		changes            []openapi.PnuChange
+	}{
+		{"local time", true, []openapi.PnuChange{{Pnu: 64045, Label: "hour", OldValue: 10, NewValue: 12}, {Pnu: 64046, Label: "minute", OldValue: 0, NewValue: 30}}},
+		{"standard time", false, []openapi.PnuChange{{Pnu: 64045, Label: "hour", OldValue: 10, NewValue: 11}, {Pnu: 64046, Label: "minute", OldValue: 0, NewValue: 30}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := berlinSystemService(t, clockRegisters(2026, 7, 1, 10, 0, test.autoDaylightSaving), "2026-07-01T10:30:00Z")
+			request := openapi.SetSystemDateTimeRequest{Iso: mustParseTime(t, "2026-07-01T10:30:59Z"), AutoDaylightSaving: &test.autoDaylightSaving}
+			response, err := service.SetSystemDateTime(context.TODO(), request, true, "")
+			assert.NilError(t, err)
+			assertDeepEqual(t, response.Body.(openapi.DryRunResponse).Changes, test.changes)
+		})
+	}
+}
+
+func TestSetSystemDateTime__isoKeepsDaylightSaving(t *testing.T) {
+	service := berlinSystemService(t, clockRegisters(2026, 7, 1, 10, 0, true), "2026-07-01T10:30:00Z")
+	request := openapi.SetSystemDateTimeRequest{Iso: mustParseTime(t, "2026-07-01T10:30:00Z")}
+	response, err := service.SetSystemDateTime(context.TODO(), request, true, "")
+	assert.NilError(t, err)
+	// converted into the local time, the DST flag isn't changed
+	assertDeepEqual(t, response.Body.(openapi.DryRunResponse).Changes, []openapi.PnuChange{
+		{Pnu: 64045, Label: "hour", OldValue: 10, NewValue: 12},
+		{Pnu: 64046, Label: "minute", OldValue: 0, NewValue: 30},
+	})
+}
+
+func TestSetSystemDateTime__isoValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		request   openapi.SetSystemDateTimeRequest
+		errorCode api.ErrorCode
+		field     string
+	}{
+		// 10:30 UTC is 12:30 CEST
+		{"fields differ", openapi.SetSystemDateTimeRequest{Year: 2026, Month: 7, Day: 1, Hour: 10, Minute: 30, Iso: mustParseTime(t, "2026-07-01T10:30:00Z")}, api.ErrMalformedRequest, "iso"},
+		{"year out of range", openapi.SetSystemDateTimeRequest{Iso: mustParseTime(t, "2099-12-31T23:30:00Z")}, api.ErrValueOutOfRange, "year"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.request.AutoDaylightSaving = ptr(true)
+			service := berlinSystemService(t, clockRegisters(2026, 7, 1, 10, 0, true), "2026-07-01T10:30:00Z")
+			_, err := service.SetSystemDateTime(context.TODO(), test.request, true, "")
+			apiErr, ok := err.(*api.ApiError)
+			assert.Assert(t, ok, "%T", err)
+			assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+			assert.Equal(t, test.errorCode, apiErr.ErrorCode)
+			assert.Equal(t, test.field, apiErr.Field)
+		})
+	}
+}
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	assert.NilError(t, err)
+	return parsed
 }
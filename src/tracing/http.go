@@ -0,0 +1,91 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// W3C trace context header, see https://www.w3.org/TR/trace-context/
+const TraceparentHeader = "traceparent"
+
+// Incoming traceparent headers are continued even before Install set the global propagator
+var traceContext = propagation.TraceContext{}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+/*
+Middleware starts a server span for every HTTP request, continuing the trace of an
+incoming traceparent header. The span's traceparent is returned in the response so
+clients can look the trace up.
+*/
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := traceContext.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := Tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			),
+		)
+		defer span.End()
+		if !span.IsRecording() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		traceContext.Inject(ctx, propagation.HeaderCarrier(w.Header()))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, fmt.Sprintf("%s %s answered %d", r.Method, r.URL.Path, recorder.status))
+		}
+	})
+}
+
+// RouteMiddleware names the server span after the matched route, to be installed with Router.Use
+func RouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			span := trace.SpanFromContext(r.Context())
+			if template, err := route.GetPathTemplate(); err == nil {
+				span.SetName(r.Method + " " + template)
+				span.SetAttributes(attribute.String("http.route", template))
+			}
+			if name := route.GetName(); name != "" {
+				span.SetAttributes(attribute.String("operation", name))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
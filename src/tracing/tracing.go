@@ -0,0 +1,84 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Instrumentation scope of the gateway's spans
+const scopeName = "github.com/treblada/ecl310-rest"
+
+// Tracer creates the gateway's spans, they aren't recorded until Install sets up an exporter
+func Tracer() trace.Tracer {
+	return otel.Tracer(scopeName)
+}
+
+/*
+Install exports the spans of the service in batches from now on. The returned provider's
+Shutdown exports the pending spans and closes the exporter.
+*/
+func Install(exporter sdktrace.SpanExporter, serviceName string) *sdktrace.TracerProvider {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider
+}
+
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+// NewFileExporter appends the spans to the file as JSON, one span per line
+func NewFileExporter(path string) (sdktrace.SpanExporter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open trace file %s: %w", path, err)
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileExporter{SpanExporter: exporter, file: file}, nil
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.SpanExporter.Shutdown(ctx), e.file.Close())
+}
+
+// NewHTTPExporter sends the spans to the OTLP/HTTP collector at the base URL, e.g. http://localhost:4318
+func NewHTTPExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	return otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"))
+}
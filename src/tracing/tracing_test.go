@@ -0,0 +1,155 @@
+/*
+This file is part of ecl310-rest.
+
+ecl310-rest is free software: you can redistribute it and/or modify it under
+the terms of the GNU General Public License as published by the Free Software
+Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+ecl310-rest is distributed in the hope that it will be useful, but WITHOUT ANY
+WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with
+ecl310-rest. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/treblada/ecl310-rest/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"gotest.tools/v3/assert"
+)
+
+func install(t *testing.T, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	provider := tracing.Install(exporter, "ecl310-rest")
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	return provider
+}
+
+func attributes(keyValues []attribute.KeyValue) map[attribute.Key]any {
+	values := map[attribute.Key]any{}
+	for _, keyValue := range keyValues {
+		values[keyValue.Key] = keyValue.Value.AsInterface()
+	}
+	return values
+}
+
+func TestMiddleware__disabledWithoutExporter(t *testing.T) {
+	handler := tracing.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Assert(t, !trace.SpanFromContext(r.Context()).IsRecording())
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, "", w.Header().Get(tracing.TraceparentHeader))
+}
+
+func TestMiddleware__continuesIncomingTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := install(t, exporter)
+
+	router := mux.NewRouter()
+	router.Use(tracing.RouteMiddleware)
+	router.Methods(http.MethodPost).Path("/system/datetime").Name("SetSystemDateTime").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Tracer().Start(r.Context(), "modbus WriteSingleRegister", trace.WithSpanKind(trace.SpanKindClient))
+		span.End()
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	handler := tracing.Middleware(router)
+
+	r := httptest.NewRequest(http.MethodPost, "/system/datetime", nil)
+	r.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.NilError(t, provider.ForceFlush(context.Background()))
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 2, len(spans))
+	modbus, server := spans[0], spans[1]
+	assert.Equal(t, "POST /system/datetime", server.Name)
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.Equal(t, server.SpanContext.SpanID(), modbus.Parent.SpanID())
+	assert.Equal(t, codes.Error, server.Status.Code)
+	assert.Assert(t, strings.Contains(server.Status.Description, "502"), server.Status.Description)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+server.SpanContext.SpanID().String()+"-01", w.Header().Get(tracing.TraceparentHeader))
+
+	values := attributes(server.Attributes)
+	assert.Equal(t, "/system/datetime", values["http.route"])
+	assert.Equal(t, "SetSystemDateTime", values["operation"])
+	assert.Equal(t, int64(http.StatusBadGateway), values["http.response.status_code"])
+}
+
+func TestMiddleware__startsTraceForInvalidTraceparent(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := install(t, exporter)
+
+	handler := tracing.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest(http.MethodGet, "/health", nil)
+	r.Header.Set(tracing.TraceparentHeader, "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.NilError(t, provider.ForceFlush(context.Background()))
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 1, len(spans))
+	assert.Assert(t, spans[0].SpanContext.TraceID().IsValid())
+	assert.Assert(t, !spans[0].Parent.IsValid())
+}
+
+func TestFileExporter__writesPendingSpansOnShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter, err := tracing.NewFileExporter(path)
+	assert.NilError(t, err)
+	provider := install(t, exporter)
+
+	ctx, parent := tracing.Tracer().Start(context.Background(), "GET /system/info", trace.WithSpanKind(trace.SpanKindServer))
+	_, child := tracing.Tracer().Start(ctx, "modbus ReadHoldingRegisters", trace.WithAttributes(attribute.Int("modbus.pnu", 278)))
+	child.RecordError(errors.New("Mocked error"))
+	child.SetStatus(codes.Error, "Mocked error")
+	child.End()
+	parent.End()
+	assert.NilError(t, provider.Shutdown(context.Background()))
+
+	content, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Equal(t, 2, len(lines))
+	var span struct {
+		Name        string
+		SpanContext struct{ TraceID string }
+		Parent      struct{ SpanID string }
+		Status      struct{ Code string }
+		Resource    []struct {
+			Key   string
+			Value struct{ Value any }
+		}
+	}
+	assert.NilError(t, json.Unmarshal([]byte(lines[0]), &span))
+	assert.Equal(t, "modbus ReadHoldingRegisters", span.Name)
+	assert.Equal(t, "Error", span.Status.Code)
+	assert.Assert(t, span.Parent.SpanID != "")
+	found := false
+	for _, attribute := range span.Resource {
+		found = found || attribute.Key == "service.name" && attribute.Value.Value == "ecl310-rest"
+	}
+	assert.Assert(t, found, "service.name missing in %s", lines[0])
+}